@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pbs-exporter/internal/logtail"
+	"pbs-exporter/internal/pbs"
+)
+
+// Check results, from best to worst
+const (
+	checkOK   = "OK"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+)
+
+// checkResult is the outcome of one check
+type checkResult struct {
+	name   string
+	status string
+	detail string
+}
+
+// runCheck implements `pbs-exporter check`: verifies the PBS commands work and
+// return sane data. It exits 1 if any check fails.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pbs-exporter check [flags]\n\nVerifies that the PBS commands are reachable, parse cleanly and return sane values.\nExits 1 if any check fails.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	collector := registerCollectorFlags(fs)
+	fs.Parse(args)
+
+	// Command errors are reported in the results instead of the log
+	log.SetOutput(io.Discard)
+
+	var results []checkResult
+	_, client, _, err := collector.build()
+	if err != nil {
+		results = append(results, checkResult{"configuration", checkFail, err.Error()})
+	} else {
+		results = append(results,
+			timed("qstat -t -f", func() (string, string, error) { return checkJobs(client) }),
+			timed("pbsnodes -aSj", func() (string, string, error) { return checkNodes(client) }),
+			timed("qstat -q", func() (string, string, error) { return checkQueues(client) }),
+			timed("qstat -B -f", func() (string, string, error) { return checkServer(client) }),
+		)
+		if *collector.historyWindow > 0 {
+			results = append(results, timed("qstat -x -f", func() (string, string, error) { return checkHistory(client, *collector.historyWindow) }))
+		}
+		if *collector.accountingDir != "" {
+			results = append(results, timed("accounting logs", func() (string, string, error) { return checkLogDir(*collector.accountingDir) }))
+		}
+		if *collector.schedLogDir != "" {
+			results = append(results, timed("scheduler logs", func() (string, string, error) { return checkLogDir(*collector.schedLogDir) }))
+		}
+	}
+
+	failed := false
+	for _, r := range results {
+		fmt.Printf("%-4s  %-16s %s\n", r.status, r.name, r.detail)
+		if r.status == checkFail {
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// timed runs a check, turning an error into a failure and adding how long it took
+func timed(name string, check func() (status, detail string, err error)) checkResult {
+	start := time.Now()
+	status, detail, err := check()
+	if err != nil {
+		return checkResult{name, checkFail, err.Error()}
+	}
+	return checkResult{name, status, fmt.Sprintf("%s (%s)", detail, time.Since(start).Round(time.Millisecond))}
+}
+
+func checkJobs(c *pbs.Client) (string, string, error) {
+	output, err := c.GetQstatFullOutput()
+	if err != nil {
+		return "", "", err
+	}
+	data := c.ParseQstatFullOutput(output)
+	if len(data.Jobs) == 0 && strings.Contains(output, "Job Id:") {
+		return "", "", errors.New("output lists jobs but none could be parsed")
+	}
+
+	incomplete := 0
+	for _, j := range data.Jobs {
+		if j.State == "" || j.Queue == "" || j.Owner == "" {
+			incomplete++
+		}
+	}
+	detail := fmt.Sprintf("%d jobs: %d running, %d queued, %d held", len(data.Jobs), data.TotalR, data.TotalQ, data.TotalH)
+	if incomplete > 0 {
+		return checkWarn, fmt.Sprintf("%s; %d without owner, state or queue", detail, incomplete), nil
+	}
+	return checkOK, detail, nil
+}
+
+func checkNodes(c *pbs.Client) (string, string, error) {
+	output, err := c.GetPbsnodesOutput()
+	if err != nil {
+		return "", "", err
+	}
+	data := c.ParsePbsnodesOutput(output)
+	if len(data.Nodes) == 0 {
+		return "", "", errors.New("no nodes parsed")
+	}
+
+	var insane []string
+	cpus := 0
+	for name, n := range data.Nodes {
+		cpus += n.CPUsTotal
+		if n.CPUsTotal <= 0 || n.CPUsAvailable > n.CPUsTotal || n.GPUsAvailable > n.GPUsTotal || n.MemoryAvailable > n.MemoryTotal {
+			insane = append(insane, name)
+		}
+	}
+	detail := fmt.Sprintf("%d nodes (%d free, %d down, %d offline), %d CPUs",
+		len(data.Nodes), data.CountFree, data.CountDown, data.CountOffline, cpus)
+	if cpus == 0 {
+		return "", "", fmt.Errorf("%s; no node reports any CPUs", detail)
+	}
+	if len(insane) > 0 {
+		sort.Strings(insane)
+		return checkWarn, fmt.Sprintf("%s; implausible CPU/GPU/memory totals on %s", detail, strings.Join(firstN(insane, 5), ", ")), nil
+	}
+	return checkOK, detail, nil
+}
+
+func checkQueues(c *pbs.Client) (string, string, error) {
+	output, err := c.GetQstatQOutput()
+	if err != nil {
+		return "", "", err
+	}
+	states := c.ParseQstatQStates(output)
+	if len(states) == 0 {
+		return "", "", errors.New("no queues parsed")
+	}
+
+	var down []string
+	for name, st := range states {
+		if !st.Enabled || !st.Started {
+			down = append(down, name)
+		}
+	}
+	running, queued := c.ParseQstatQSummary(output)
+	detail := fmt.Sprintf("%d queues, %d running, %d queued", len(states), running, queued)
+	if len(down) > 0 {
+		sort.Strings(down)
+		return checkWarn, fmt.Sprintf("%s; disabled or stopped: %s", detail, strings.Join(firstN(down, 5), ", ")), nil
+	}
+	return checkOK, detail, nil
+}
+
+func checkServer(c *pbs.Client) (string, string, error) {
+	output, err := c.GetQstatBOutput()
+	if err != nil {
+		return "", "", err
+	}
+	status := c.ParseQstatBOutput(output)
+	if status.Name == "" {
+		return "", "", errors.New("no server status parsed")
+	}
+	detail := fmt.Sprintf("server %s is %s", status.Name, status.State)
+	if !status.Scheduling {
+		return checkWarn, detail + ", scheduling is off", nil
+	}
+	return checkOK, detail + ", scheduling", nil
+}
+
+func checkHistory(c *pbs.Client, window time.Duration) (string, string, error) {
+	ids, err := c.GetFinishedJobIDs(time.Now().Add(-window))
+	if err != nil {
+		return "", "", err
+	}
+	if len(ids) == 0 {
+		return checkWarn, fmt.Sprintf("no jobs ended within %s (is job_history_enable set?)", window), nil
+	}
+	output, err := c.GetQstatHistoryOutput(ids)
+	if err != nil {
+		return "", "", err
+	}
+	jobs := c.ParseQstatHistoryOutput(output)
+	finished := 0
+	for _, j := range jobs {
+		if j.State == "F" {
+			finished++
+		}
+	}
+	if finished == 0 {
+		return checkWarn, fmt.Sprintf("%d jobs, none finished (is job_history_enable set?)", len(jobs)), nil
+	}
+	return checkOK, fmt.Sprintf("%d jobs, %d finished", len(jobs), finished), nil
+}
+
+func checkLogDir(dir string) (string, string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.IsDir() {
+		return "", "", fmt.Errorf("%s is not a directory", dir)
+	}
+	today := filepath.Join(dir, logtail.FileName(time.Now()))
+	f, err := os.Open(today)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkWarn, fmt.Sprintf("%s does not exist yet", today), nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	f.Close()
+	return checkOK, fmt.Sprintf("%s is readable", today), nil
+}
+
+// firstN returns at most n items, noting how many were left out
+func firstN(items []string, n int) []string {
+	if len(items) <= n {
+		return items
+	}
+	return append(items[:n:n], fmt.Sprintf("and %d more", len(items)-n))
+}
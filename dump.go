@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"pbs-exporter/internal/output"
+)
+
+// runDump implements `pbs-exporter dump`: one collection printed to stdout
+func runDump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pbs-exporter dump [flags]\n\nRuns one collection and prints the metrics (or the parsed data as JSON) to stdout.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	collector := registerCollectorFlags(fs)
+	format := fs.String("format", "prometheus", "Output format: prometheus (text exposition) or json (parsed job, node, queue and server data)")
+	fs.Parse(args)
+
+	if *format != "prometheus" && *format != "json" {
+		return subcommandError("dump", "unknown -format %q (expected prometheus or json)", *format)
+	}
+
+	// Collection errors go to stderr so stdout stays parseable
+	log.SetOutput(os.Stderr)
+
+	registry, _, srv, err := collector.build()
+	if err != nil {
+		return subcommandError("dump", "%v", err)
+	}
+	collectErr := srv.UpdateMetrics()
+
+	if *format == "json" {
+		snap := srv.Snapshot()
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(map[string]interface{}{
+			"jobs":   snap.Jobs,
+			"nodes":  snap.Nodes,
+			"queues": snap.Queues,
+			"server": snap.Server,
+		})
+	} else {
+		var families []*dto.MetricFamily
+		if families, err = registry.Gatherer().Gather(); err == nil {
+			err = output.WriteText(os.Stdout, families)
+		}
+	}
+	if err != nil {
+		return subcommandError("dump", "%v", err)
+	}
+	// What was collected is still printed, but a partial collection must not pass for a complete one
+	if collectErr != nil {
+		fmt.Fprintf(os.Stderr, "pbs-exporter dump: collection failed: %v\n", collectErr)
+		return 1
+	}
+	return 0
+}
+
+// subcommandError prints a subcommand error and returns the exit status
+func subcommandError(name, format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "pbs-exporter %s: "+format+"\n", append([]interface{}{name}, args...)...)
+	return 2
+}
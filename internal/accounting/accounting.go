@@ -0,0 +1,285 @@
+package accounting
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pbs-exporter/internal/logtail"
+)
+
+// Record types handled by the exporter
+const (
+	TypeQueued  = "Q"
+	TypeStarted = "S"
+	TypeEnded   = "E"
+	TypeDeleted = "D"
+	TypeAborted = "A"
+)
+
+const (
+	timeLayout = "01/02/2006 15:04:05"
+	// maxJobsCache bounds the owner/queue cache for jobs whose E record was never seen
+	maxJobsCache = 200000
+)
+
+// Record is a single accounting log entry:
+// "10/18/2026 09:00:00;E;1234.pbs01;user=alice queue=long Exit_status=0 ..."
+type Record struct {
+	Time  time.Time
+	Type  string
+	JobID string
+	Attrs map[string]string
+
+	// User and Queue are taken from the record, or from earlier records of the same job
+	User  string
+	Queue string
+}
+
+// ParseRecord parses one accounting log line
+func ParseRecord(line string) (Record, error) {
+	parts := strings.SplitN(strings.TrimSpace(line), ";", 4)
+	if len(parts) < 3 {
+		return Record{}, fmt.Errorf("malformed accounting record %q", line)
+	}
+
+	ts, err := time.ParseInLocation(timeLayout, parts[0], time.Local)
+	if err != nil {
+		return Record{}, fmt.Errorf("malformed accounting timestamp %q: %w", parts[0], err)
+	}
+
+	rec := Record{
+		Time:  ts,
+		Type:  parts[1],
+		JobID: parts[2],
+		Attrs: make(map[string]string),
+	}
+	if len(parts) == 4 {
+		for _, token := range splitAttrs(parts[3]) {
+			if idx := strings.Index(token, "="); idx > 0 {
+				rec.Attrs[token[:idx]] = strings.Trim(token[idx+1:], `"`)
+			}
+		}
+	}
+
+	rec.User = rec.Attrs["user"]
+	rec.Queue = rec.Attrs["queue"]
+	if rec.User == "" && rec.Type == TypeDeleted {
+		// D records only name the requestor ("alice@login01")
+		if req := rec.Attrs["requestor"]; req != "" {
+			rec.User = strings.SplitN(req, "@", 2)[0]
+		}
+	}
+
+	return rec, nil
+}
+
+// splitAttrs splits "key=value key2="quoted value"" on unquoted spaces
+func splitAttrs(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	inQuotes := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			cur.WriteRune(r)
+		case r == ' ' && !inQuotes:
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens
+}
+
+// ExitStatus returns the Exit_status of an E record and whether it is present
+func (r Record) ExitStatus() (int, bool) {
+	v, ok := r.Attrs["Exit_status"]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// NCPUs returns the number of CPUs requested by the job
+func (r Record) NCPUs() int {
+	n, _ := strconv.Atoi(r.Attrs["Resource_List.ncpus"])
+	return n
+}
+
+// NGPUs returns the number of GPUs requested by the job
+func (r Record) NGPUs() int {
+	n, _ := strconv.Atoi(r.Attrs["Resource_List.ngpus"])
+	return n
+}
+
+// WalltimeHours returns resources_used.walltime in hours
+func (r Record) WalltimeHours() float64 {
+	return ParseDuration(r.Attrs["resources_used.walltime"]).Hours()
+}
+
+// CPUHours returns the CPU-hours consumed by the job (ncpus x walltime)
+func (r Record) CPUHours() float64 {
+	return float64(r.NCPUs()) * r.WalltimeHours()
+}
+
+// GPUHours returns the GPU-hours consumed by the job (ngpus x walltime)
+func (r Record) GPUHours() float64 {
+	return float64(r.NGPUs()) * r.WalltimeHours()
+}
+
+// ParseDuration parses PBS durations such as "HH:MM:SS", "MM:SS" or plain seconds
+func ParseDuration(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	var total float64
+	for _, part := range strings.Split(s, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + v
+	}
+	return time.Duration(total * float64(time.Second))
+}
+
+// jobInfo remembers who owns a job and where it was queued
+type jobInfo struct {
+	user  string
+	queue string
+}
+
+// Reader tails the accounting log directory and returns parsed records
+type Reader struct {
+	tailer *logtail.Tailer
+	jobs   map[string]jobInfo
+}
+
+// NewReader creates a reader for server_priv/accounting
+func NewReader(dir string) *Reader {
+	return &Reader{
+		tailer: logtail.New(dir),
+		jobs:   make(map[string]jobInfo),
+	}
+}
+
+// Read returns the records appended since the last call and the number of lines that failed to parse
+func (r *Reader) Read() ([]Record, int, error) {
+	lines, err := r.tailer.ReadLines()
+
+	var records []Record
+	parseErrors := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		rec, perr := ParseRecord(line)
+		if perr != nil {
+			parseErrors++
+			continue
+		}
+		r.resolve(&rec)
+		records = append(records, rec)
+	}
+
+	return records, parseErrors, err
+}
+
+// resolve fills user/queue from earlier records and maintains the job cache
+func (r *Reader) resolve(rec *Record) {
+	info := r.jobs[rec.JobID]
+	if rec.User == "" || rec.Type == TypeDeleted {
+		// Prefer the owner over the D record's requestor (which may be an operator)
+		if info.user != "" {
+			rec.User = info.user
+		}
+	}
+	if rec.Queue == "" {
+		rec.Queue = info.queue
+	}
+
+	switch rec.Type {
+	case TypeQueued, TypeStarted:
+		if rec.Attrs["user"] != "" {
+			info.user = rec.Attrs["user"]
+		}
+		if rec.Queue != "" {
+			info.queue = rec.Queue
+		}
+		if len(r.jobs) >= maxJobsCache {
+			r.jobs = make(map[string]jobInfo)
+		}
+		r.jobs[rec.JobID] = info
+	case TypeEnded, TypeAborted:
+		delete(r.jobs, rec.JobID)
+	}
+}
+
+// ReadRange reads the complete daily logs covering [from, to) and returns the
+// records in that range and the number of lines that failed to parse.
+// Days without a log file are skipped.
+func ReadRange(dir string, from, to time.Time) ([]Record, int, error) {
+	r := &Reader{jobs: make(map[string]jobInfo)}
+
+	var records []Record
+	parseErrors := 0
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for day := start; day.Before(to); day = day.AddDate(0, 0, 1) {
+		f, err := os.Open(filepath.Join(dir, logtail.FileName(day)))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return records, parseErrors, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			rec, perr := ParseRecord(line)
+			if perr != nil {
+				parseErrors++
+				continue
+			}
+			r.resolve(&rec)
+			if rec.Time.Before(from) || !rec.Time.Before(to) {
+				continue
+			}
+			records = append(records, rec)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return records, parseErrors, err
+		}
+	}
+
+	return records, parseErrors, nil
+}
+
+// Close releases the underlying log file
+func (r *Reader) Close() error {
+	return r.tailer.Close()
+}
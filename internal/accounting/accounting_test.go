@@ -0,0 +1,217 @@
+package accounting
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"pbs-exporter/internal/logtail"
+)
+
+func TestParseRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		want      Record
+		wantErr   bool
+		wantExit  int
+		hasExit   bool
+		wantHours float64
+	}{
+		{
+			name: "ended job",
+			line: `10/18/2026 09:00:00;E;1234.pbs01;user=alice group=physics queue=long Resource_List.ncpus=8 resources_used.walltime=02:30:00 Exit_status=0`,
+			want: Record{
+				Time:  time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local),
+				Type:  TypeEnded,
+				JobID: "1234.pbs01",
+				Attrs: map[string]string{
+					"user": "alice", "group": "physics", "queue": "long",
+					"Resource_List.ncpus": "8", "resources_used.walltime": "02:30:00", "Exit_status": "0",
+				},
+				User:  "alice",
+				Queue: "long",
+			},
+			hasExit:   true,
+			wantHours: 20,
+		},
+		{
+			name: "quoted values keep their spaces",
+			line: `10/18/2026 09:00:00;S;1235.pbs01;user=bob queue=small jobname="my job" Exit_status=-1`,
+			want: Record{
+				Time:  time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local),
+				Type:  TypeStarted,
+				JobID: "1235.pbs01",
+				Attrs: map[string]string{"user": "bob", "queue": "small", "jobname": "my job", "Exit_status": "-1"},
+				User:  "bob",
+				Queue: "small",
+			},
+			wantExit: -1,
+			hasExit:  true,
+		},
+		{
+			name: "deleted job names the requestor",
+			line: `10/18/2026 09:00:00;D;1236.pbs01;requestor=carol@login01`,
+			want: Record{
+				Time:  time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local),
+				Type:  TypeDeleted,
+				JobID: "1236.pbs01",
+				Attrs: map[string]string{"requestor": "carol@login01"},
+				User:  "carol",
+			},
+		},
+		{
+			name: "record without attributes",
+			line: `10/18/2026 09:00:00;L;license;`,
+			want: Record{
+				Time:  time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local),
+				Type:  "L",
+				JobID: "license",
+				Attrs: map[string]string{},
+			},
+		},
+		{name: "too few fields", line: `10/18/2026 09:00:00;E`, wantErr: true},
+		{name: "bad timestamp", line: `18.10.2026 09:00:00;E;1.pbs01;user=alice`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRecord(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRecord() = %+v, want %+v", got, tt.want)
+			}
+			if code, ok := got.ExitStatus(); ok != tt.hasExit || code != tt.wantExit {
+				t.Errorf("ExitStatus() = %d, %v, want %d, %v", code, ok, tt.wantExit, tt.hasExit)
+			}
+			if h := got.CPUHours(); h != tt.wantHours {
+				t.Errorf("CPUHours() = %v, want %v", h, tt.wantHours)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"02:30:00", 150 * time.Minute},
+		{"100:00:01", 100*time.Hour + time.Second},
+		{"05:30", 5*time.Minute + 30*time.Second},
+		{"90", 90 * time.Second},
+		{"", 0},
+		{"1:xx:00", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseDuration(tt.in); got != tt.want {
+			t.Errorf("ParseDuration(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// writeDay writes the accounting log for day in dir
+func writeDay(t *testing.T, dir string, day time.Time, lines ...string) {
+	t.Helper()
+	var data string
+	for _, l := range lines {
+		data += l + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, logtail.FileName(day)), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReaderResolvesOwners(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	writeDay(t, dir, now)
+
+	r := NewReader(dir)
+	defer r.Close()
+	r.tailer.Now = func() time.Time { return now }
+	if _, _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logtail.FileName(now)), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`10/18/2026 09:00:00;Q;1.pbs01;user=alice queue=long
+10/18/2026 09:00:05;D;1.pbs01;requestor=root@pbs01
+not an accounting record
+10/18/2026 09:01:00;E;2.pbs01;Exit_status=0
+`)
+	f.Close()
+
+	records, parseErrors, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parseErrors != 1 {
+		t.Errorf("parse errors = %d, want 1", parseErrors)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	// The operator deleting alice's job is not taken for its owner
+	if del := records[1]; del.User != "alice" || del.Queue != "long" {
+		t.Errorf("D record user/queue = %q/%q, want alice/long", del.User, del.Queue)
+	}
+	// A job whose earlier records were not seen keeps what its own record says
+	if end := records[2]; end.User != "" || end.Queue != "" {
+		t.Errorf("E record user/queue = %q/%q, want empty", end.User, end.Queue)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	dir := t.TempDir()
+	day1 := time.Date(2026, 9, 30, 0, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	writeDay(t, dir, day1,
+		"09/30/2026 22:00:00;Q;1.pbs01;user=alice queue=long",
+		"09/30/2026 23:00:00;E;0.pbs01;user=bob queue=small Exit_status=0",
+	)
+	writeDay(t, dir, day2,
+		"10/01/2026 01:00:00;E;1.pbs01;Exit_status=0",
+		"garbage",
+		"10/01/2026 02:00:00;E;3.pbs01;user=carol queue=long Exit_status=1",
+	)
+
+	// Days before from are not read, so job 1's owner is unknown
+	records, parseErrors, err := ReadRange(dir, day2, day2.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parseErrors != 1 {
+		t.Errorf("parse errors = %d, want 1", parseErrors)
+	}
+	var got []string
+	for _, rec := range records {
+		got = append(got, rec.JobID+" "+rec.User)
+	}
+	if want := []string{"1.pbs01 ", "3.pbs01 carol"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+
+	// Records of the first day before from still resolve owners; the E record
+	// inherits the owner of the Q record, and records from to on are left out
+	records, _, err = ReadRange(dir, day1.Add(22*time.Hour+30*time.Minute), day2.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, rec := range records {
+		got = append(got, rec.Type+" "+rec.JobID+" "+rec.User)
+	}
+	if want := []string{"E 0.pbs01 bob", "E 1.pbs01 alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
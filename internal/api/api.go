@@ -0,0 +1,461 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"pbs-exporter/internal/pbs"
+	"pbs-exporter/internal/server"
+)
+
+// Prefix is the path the API is mounted under
+const Prefix = "/api/v1/"
+
+// Node is the JSON form of a node
+type Node struct {
+	Name            string  `json:"name"`
+	State           string  `json:"state"`
+	Jobs            int     `json:"jobs"`
+	CPUsAvailable   int     `json:"cpus_available"`
+	CPUsTotal       int     `json:"cpus_total"`
+	GPUsAvailable   int     `json:"gpus_available"`
+	GPUsTotal       int     `json:"gpus_total"`
+	MemoryAvailable float64 `json:"memory_available_gb"`
+	MemoryTotal     float64 `json:"memory_total_gb"`
+	// Reservations lists the active reservations holding the node
+	Reservations []string `json:"reservations,omitempty"`
+}
+
+// Queue is the JSON form of a queue
+type Queue struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Started bool   `json:"started"`
+	Running int    `json:"running"`
+	Queued  int    `json:"queued"`
+}
+
+// Reservation is the JSON form of a `pbs_rstat -f` reservation
+type Reservation struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name,omitempty"`
+	Owner string    `json:"owner"`
+	Queue string    `json:"queue"`
+	Type  string    `json:"type"`
+	State string    `json:"state"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Nodes []string  `json:"nodes"`
+	NCPUs int       `json:"ncpus"`
+	NGPUs int       `json:"ngpus"`
+}
+
+// Job is the JSON form of a job
+type Job struct {
+	ID          string            `json:"id"`
+	Owner       string            `json:"owner"`
+	State       string            `json:"state"`
+	Queue       string            `json:"queue"`
+	ArrayParent string            `json:"array_parent,omitempty"`
+	IsArray     bool              `json:"is_array,omitempty"`
+	Department  string            `json:"department,omitempty"`
+	NCPUs       int               `json:"ncpus"`
+	NGPUs       int               `json:"ngpus"`
+	MemoryGB    float64           `json:"memory_gb"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// User is the JSON form of a user's jobs and resources
+type User struct {
+	Name         string  `json:"name"`
+	Running      int     `json:"running"`
+	Queued       int     `json:"queued"`
+	Held         int     `json:"held"`
+	RunningCPUs  int     `json:"running_cpus"`
+	RunningGPUs  int     `json:"running_gpus"`
+	RunningMemGB float64 `json:"running_memory_gb"`
+	QueuedCPUs   int     `json:"queued_cpus"`
+	QueuedGPUs   int     `json:"queued_gpus"`
+	QueuedMemGB  float64 `json:"queued_memory_gb"`
+}
+
+// Summary is the cluster overview returned by /api/v1/summary
+type Summary struct {
+	Cluster   string         `json:"cluster,omitempty"`
+	Up        bool           `json:"up"`
+	Server    *ServerInfo    `json:"server,omitempty"`
+	Jobs      map[string]int `json:"jobs"`
+	Nodes     map[string]int `json:"nodes"`
+	Queues    int            `json:"queues"`
+	CPUsTotal int            `json:"cpus_total"`
+	CPUsFree  int            `json:"cpus_available"`
+	GPUsTotal int            `json:"gpus_total"`
+	GPUsFree  int            `json:"gpus_available"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Cluster is the JSON form of a monitored PBS server in /api/v1/clusters
+type Cluster struct {
+	Name        string    `json:"name"`
+	Up          bool      `json:"up"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// ServerInfo is the JSON form of the `qstat -B` status
+type ServerInfo struct {
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	Scheduling bool   `json:"scheduling"`
+}
+
+// Handler serves the JSON API from the servers' last collected snapshots.
+// With several servers the cluster query parameter selects one (the first by
+// default) and /api/v1/clusters lists them. Responses carry an ETag so clients
+// polling with If-None-Match get a 304 until the next collection changes the data.
+// Raw `qstat -f` attributes, which include paths, the environment and submit
+// arguments, are only included in /api/v1/jobs with jobAttributes.
+func Handler(jobAttributes bool, servers ...*server.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+		if path == "clusters" {
+			writeJSON(w, r, clusterList(servers))
+			return
+		}
+
+		s := servers[0]
+		if name := r.URL.Query().Get("cluster"); name != "" {
+			s = nil
+			for _, srv := range servers {
+				if srv.Cluster() == name {
+					s = srv
+					break
+				}
+			}
+			if s == nil {
+				writeError(w, http.StatusNotFound, "cluster "+name+" not found")
+				return
+			}
+		}
+		snap := s.Snapshot()
+
+		switch {
+		case path == "nodes":
+			if snap.Nodes == nil {
+				writeError(w, http.StatusServiceUnavailable, "nodes not collected yet")
+				return
+			}
+			writeJSON(w, r, nodeList(snap.Nodes, snap.Reservations))
+		case strings.HasPrefix(path, "nodes/"):
+			if snap.Nodes == nil {
+				writeError(w, http.StatusServiceUnavailable, "nodes not collected yet")
+				return
+			}
+			name := strings.TrimPrefix(path, "nodes/")
+			info, ok := snap.Nodes.Nodes[name]
+			if !ok {
+				writeError(w, http.StatusNotFound, "node "+name+" not found")
+				return
+			}
+			writeJSON(w, r, newNode(name, info, reservationsByNode(snap.Reservations)))
+		case path == "reservations":
+			if snap.ReservationsAt.IsZero() {
+				writeError(w, http.StatusServiceUnavailable, "reservations not collected (see -collector.reservations)")
+				return
+			}
+			writeJSON(w, r, reservationList(snap.Reservations))
+		case path == "queues":
+			if snap.Queues == nil {
+				writeError(w, http.StatusServiceUnavailable, "queues not collected yet")
+				return
+			}
+			writeJSON(w, r, queueList(snap.Queues))
+		case path == "jobs":
+			if snap.Jobs == nil {
+				writeError(w, http.StatusServiceUnavailable, "jobs not collected yet")
+				return
+			}
+			q := r.URL.Query()
+			writeJSON(w, r, jobList(snap.Jobs, q.Get("user"), q.Get("queue"), q.Get("state"), jobAttributes))
+		case path == "users":
+			if snap.Jobs == nil {
+				writeError(w, http.StatusServiceUnavailable, "jobs not collected yet")
+				return
+			}
+			writeJSON(w, r, userList(snap.Jobs))
+		case path == "history":
+			history(w, r, s.Store(), s.Cluster())
+		case path == "summary":
+			sum := summary(snap)
+			sum.Cluster = s.Cluster()
+			writeJSON(w, r, sum)
+		default:
+			writeError(w, http.StatusNotFound, "unknown endpoint")
+		}
+	})
+}
+
+// clusterList lists the monitored servers in the order they were configured
+func clusterList(servers []*server.Server) []Cluster {
+	clusters := make([]Cluster, 0, len(servers))
+	for _, s := range servers {
+		snap := s.Snapshot()
+		clusters = append(clusters, Cluster{Name: s.Cluster(), Up: snap.Up, CollectedAt: snap.CollectedAt})
+	}
+	return clusters
+}
+
+// newNode converts a parsed node
+func newNode(name string, info pbs.NodeInfo, reservations map[string][]string) Node {
+	return Node{
+		Name:            name,
+		State:           info.State,
+		Jobs:            info.Jobs,
+		CPUsAvailable:   info.CPUsAvailable,
+		CPUsTotal:       info.CPUsTotal,
+		GPUsAvailable:   info.GPUsAvailable,
+		GPUsTotal:       info.GPUsTotal,
+		MemoryAvailable: info.MemoryAvailable,
+		MemoryTotal:     info.MemoryTotal,
+		Reservations:    reservations[name],
+	}
+}
+
+// nodeList returns all nodes sorted by name
+func nodeList(data *pbs.NodeData, resvs []pbs.Reservation) []Node {
+	byNode := reservationsByNode(resvs)
+	nodes := make([]Node, 0, len(data.Nodes))
+	for name, info := range data.Nodes {
+		nodes = append(nodes, newNode(name, info, byNode))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// reservationsByNode maps each node to the active reservations holding it
+func reservationsByNode(resvs []pbs.Reservation) map[string][]string {
+	byNode := make(map[string][]string)
+	for _, r := range resvs {
+		if !r.Active() {
+			continue
+		}
+		for _, node := range r.Nodes {
+			byNode[node] = append(byNode[node], r.ID)
+		}
+	}
+	return byNode
+}
+
+// reservationList returns all reservations ordered by start time
+func reservationList(resvs []pbs.Reservation) []Reservation {
+	list := make([]Reservation, 0, len(resvs))
+	for _, r := range resvs {
+		list = append(list, Reservation{
+			ID:    r.ID,
+			Name:  r.Name,
+			Owner: r.Owner,
+			Queue: r.Queue,
+			Type:  r.Type,
+			State: r.State,
+			Start: r.Start,
+			End:   r.End,
+			Nodes: r.Nodes,
+			NCPUs: r.NCPUs,
+			NGPUs: r.NGPUs,
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+// queueList returns all queues sorted by name
+func queueList(queues map[string]server.QueueInfo) []Queue {
+	list := make([]Queue, 0, len(queues))
+	for name, q := range queues {
+		list = append(list, Queue{
+			Name:    name,
+			Enabled: q.State.Enabled,
+			Started: q.State.Started,
+			Running: q.Running,
+			Queued:  q.Queued,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// jobList returns the jobs matching the filters (empty filters match everything).
+// state accepts a comma-separated list of single-letter PBS states.
+func jobList(data *pbs.JobData, user, queue, state string, attributes bool) []Job {
+	var states map[string]bool
+	if state != "" {
+		states = make(map[string]bool)
+		for _, s := range strings.Split(state, ",") {
+			states[strings.ToUpper(strings.TrimSpace(s))] = true
+		}
+	}
+
+	jobs := make([]Job, 0, len(data.Jobs))
+	for _, j := range data.Jobs {
+		if (user != "" && j.Owner != user) || (queue != "" && j.Queue != queue) || (states != nil && !states[j.State]) {
+			continue
+		}
+		job := Job{
+			ID:          j.ID,
+			Owner:       j.Owner,
+			State:       j.State,
+			Queue:       j.Queue,
+			ArrayParent: j.ArrayParent,
+			IsArray:     j.IsArray,
+			Department:  j.Department,
+			NCPUs:       j.NCPUs(),
+			NGPUs:       j.NGPUs(),
+			MemoryGB:    j.MemoryGB(),
+		}
+		if attributes {
+			job.Attributes = j.Attributes
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// userList returns per-user usage, busiest users (by running CPUs, then jobs) first
+func userList(data *pbs.JobData) []User {
+	users := make([]User, 0, len(data.UserUsage))
+	for name, u := range data.UserUsage {
+		// Usernames dropped by -user-privacy=drop are not listed
+		if name == "" {
+			continue
+		}
+		users = append(users, User{
+			Name:         name,
+			Running:      u.Running,
+			Queued:       u.Queued,
+			Held:         u.Held,
+			RunningCPUs:  u.RunningNCPUs,
+			RunningGPUs:  u.RunningNGPUs,
+			RunningMemGB: u.RunningMemGB,
+			QueuedCPUs:   u.QueuedNCPUs,
+			QueuedGPUs:   u.QueuedNGPUs,
+			QueuedMemGB:  u.QueuedMemGB,
+		})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if a.RunningCPUs != b.RunningCPUs {
+			return a.RunningCPUs > b.RunningCPUs
+		}
+		if a.Running+a.Queued != b.Running+b.Queued {
+			return a.Running+a.Queued > b.Running+b.Queued
+		}
+		return a.Name < b.Name
+	})
+	return users
+}
+
+// summary builds the cluster overview from whatever parts have been collected
+func summary(snap server.Snapshot) Summary {
+	sum := Summary{
+		Jobs:   map[string]int{},
+		Nodes:  map[string]int{},
+		Queues: len(snap.Queues),
+		Up:     snap.Up,
+	}
+
+	if snap.Server != nil {
+		sum.Server = &ServerInfo{
+			Name:       snap.Server.Name,
+			State:      snap.Server.State,
+			Scheduling: snap.Server.Scheduling,
+		}
+	}
+
+	if snap.Jobs != nil {
+		sum.Jobs["running"] = snap.Jobs.TotalR
+		sum.Jobs["queued"] = snap.Jobs.TotalQ
+		sum.Jobs["held"] = snap.Jobs.TotalH
+		sum.Jobs["exiting"] = snap.Jobs.TotalE
+		sum.Jobs["finished"] = snap.Jobs.TotalF
+		sum.Jobs["array_parents"] = snap.Jobs.TotalB
+		sum.Jobs["total"] = snap.Jobs.TotalAll
+	}
+
+	if snap.Nodes != nil {
+		sum.Nodes["free"] = snap.Nodes.CountFree
+		sum.Nodes["busy"] = snap.Nodes.CountBusy
+		sum.Nodes["offline"] = snap.Nodes.CountOffline
+		sum.Nodes["down"] = snap.Nodes.CountDown
+		sum.Nodes["total"] = len(snap.Nodes.Nodes)
+		for _, n := range snap.Nodes.Nodes {
+			sum.CPUsTotal += n.CPUsTotal
+			sum.CPUsFree += n.CPUsAvailable
+			sum.GPUsTotal += n.GPUsTotal
+			sum.GPUsFree += n.GPUsAvailable
+		}
+	}
+
+	for _, t := range []time.Time{snap.JobsAt, snap.NodesAt, snap.QueuesAt, snap.ServerAt} {
+		if t.After(sum.UpdatedAt) {
+			sum.UpdatedAt = t
+		}
+	}
+
+	return sum
+}
+
+// writeJSON encodes v and answers conditional requests from its ETag
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError sends a JSON error body
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"pbs-exporter/internal/metrics"
+	"pbs-exporter/internal/pbs"
+	"pbs-exporter/internal/server"
+)
+
+// fakeQstat answers qstat -t -f with three jobs and qstat -B -f with the server status
+const fakeQstat = `#!/bin/sh
+case "$*" in
+"-t -f") cat <<'EOF'
+Job Id: 1.pbs01
+    Job_Owner = alice@login01
+    job_state = R
+    queue = long
+    Resource_List.ncpus = 8
+    Resource_List.ngpus = 1
+    Resource_List.mem = 16gb
+
+Job Id: 2.pbs01
+    Job_Owner = bob@login01
+    job_state = Q
+    queue = long
+    Resource_List.ncpus = 4
+    Resource_List.mem = 4gb
+
+Job Id: 3.pbs01
+    Job_Owner = alice@login01
+    job_state = H
+    queue = short
+    Resource_List.ncpus = 2
+    Resource_List.mem = 2gb
+EOF
+;;
+"-B -f") cat <<'EOF'
+Server: pbs01
+    server_state = Active
+    scheduling = True
+EOF
+;;
+*) exit 2 ;;
+esac
+`
+
+// newHandler returns the API handler over a server that has collected jobs
+// and the server status from fakeQstat, and one named "idle" that has not collected yet
+func newHandler(t *testing.T) http.Handler {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as qstat")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "qstat"), []byte(fakeQstat), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	srv := server.New(metrics.NewRegistry(), pbs.NewClient())
+	srv.SetCluster("main")
+	srv.SetCollectors([]string{metrics.GroupJobs, metrics.GroupServer})
+	if err := srv.UpdateMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	idle := server.New(metrics.NewRegistry(), pbs.NewClient())
+	idle.SetCluster("idle")
+	return Handler(false, srv, idle)
+}
+
+// get requests path from h and decodes a 200 response into v
+func get(t *testing.T, h http.Handler, path string, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code == http.StatusOK && v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	return rec
+}
+
+func TestJobFilters(t *testing.T) {
+	h := newHandler(t)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"1.pbs01", "2.pbs01", "3.pbs01"}},
+		{"?user=alice", []string{"1.pbs01", "3.pbs01"}},
+		{"?queue=long", []string{"1.pbs01", "2.pbs01"}},
+		{"?state=q,%20h", []string{"2.pbs01", "3.pbs01"}},
+		{"?user=alice&queue=long&state=R", []string{"1.pbs01"}},
+		{"?user=carol", []string{}},
+	}
+	for _, tt := range tests {
+		var jobs []Job
+		if rec := get(t, h, Prefix+"jobs"+tt.query, &jobs); rec.Code != http.StatusOK {
+			t.Fatalf("GET jobs%s status = %d", tt.query, rec.Code)
+		}
+		ids := []string{}
+		for _, j := range jobs {
+			if j.Attributes != nil {
+				t.Errorf("job %s has attributes without jobAttributes", j.ID)
+			}
+			ids = append(ids, j.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("GET jobs%s = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	var users []User
+	if rec := get(t, newHandler(t), Prefix+"users", &users); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	want := []User{
+		{Name: "alice", Running: 1, Held: 1, RunningCPUs: 8, RunningGPUs: 1, RunningMemGB: 16},
+		{Name: "bob", Queued: 1, QueuedCPUs: 4, QueuedMemGB: 4},
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("users = %+v, want %+v", users, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	var sum Summary
+	if rec := get(t, newHandler(t), Prefix+"summary", &sum); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if sum.Cluster != "main" || sum.Server == nil || *sum.Server != (ServerInfo{Name: "pbs01", State: "Active", Scheduling: true}) {
+		t.Errorf("summary cluster %q server %+v", sum.Cluster, sum.Server)
+	}
+	if sum.Jobs["running"] != 1 || sum.Jobs["queued"] != 1 || sum.Jobs["held"] != 1 || sum.Jobs["total"] != 3 {
+		t.Errorf("summary jobs = %v", sum.Jobs)
+	}
+	// Nodes were not collected
+	if len(sum.Nodes) != 0 || sum.UpdatedAt.IsZero() {
+		t.Errorf("summary nodes %v updated at %s", sum.Nodes, sum.UpdatedAt)
+	}
+}
+
+func TestClusters(t *testing.T) {
+	h := newHandler(t)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{Prefix + "jobs?cluster=main", http.StatusOK},
+		{Prefix + "jobs?cluster=idle", http.StatusServiceUnavailable},
+		{Prefix + "jobs?cluster=other", http.StatusNotFound},
+		{Prefix + "nodes", http.StatusServiceUnavailable},
+		{Prefix + "summary?cluster=idle", http.StatusOK},
+		{Prefix + "unknown", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := get(t, h, tt.path, nil); rec.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.path, rec.Code, tt.status)
+		}
+	}
+
+	var clusters []Cluster
+	get(t, h, Prefix+"clusters", &clusters)
+	if len(clusters) != 2 || clusters[0].Name != "main" || !clusters[0].Up || clusters[1].Name != "idle" {
+		t.Errorf("clusters = %+v", clusters)
+	}
+}
+
+func TestETag(t *testing.T) {
+	h := newHandler(t)
+	first := get(t, h, Prefix+"jobs", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag %q", first.Code, etag)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		ifNoneMatch string
+		status      int
+		body        bool
+	}{
+		{"same data", http.MethodGet, Prefix + "jobs", etag, http.StatusNotModified, false},
+		{"weak and in a list", http.MethodGet, Prefix + "jobs", `"other", W/` + etag, http.StatusNotModified, false},
+		{"any", http.MethodGet, Prefix + "jobs", "*", http.StatusNotModified, false},
+		{"other data", http.MethodGet, Prefix + "jobs?user=bob", etag, http.StatusOK, true},
+		{"stale", http.MethodGet, Prefix + "jobs", `"stale"`, http.StatusOK, true},
+		{"head", http.MethodHead, Prefix + "jobs", "", http.StatusOK, false},
+		{"post", http.MethodPost, Prefix + "jobs", "", http.StatusMethodNotAllowed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Body.Len() > 0; got != tt.body {
+				t.Errorf("body %q, want a body %v", rec.Body, tt.body)
+			}
+		})
+	}
+}
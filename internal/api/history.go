@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pbs-exporter/internal/store"
+)
+
+// defaultHistoryRange is the time range returned when from is not given
+const defaultHistoryRange = 24 * time.Hour
+
+// HistorySeries is the JSON form of a stored series; points are [unix seconds, value]
+type HistorySeries struct {
+	Kind   string       `json:"kind"`
+	Name   string       `json:"name,omitempty"`
+	Metric string       `json:"metric"`
+	Points [][2]float64 `json:"points"`
+}
+
+// history serves /api/v1/history?kind=&name=&metric=&from=&to=&step=
+func history(w http.ResponseWriter, r *http.Request, st *store.Store, cluster string) {
+	if st == nil {
+		writeError(w, http.StatusNotFound, "history store not enabled (-store-db)")
+		return
+	}
+
+	q := r.URL.Query()
+	now := time.Now()
+	query := store.Query{
+		Cluster: cluster,
+		Kind:    q.Get("kind"),
+		Name:    q.Get("name"),
+		Metric:  q.Get("metric"),
+		From:    now.Add(-defaultHistoryRange),
+		To:      now,
+	}
+	if query.Kind == "" {
+		query.Kind = store.KindCluster
+	}
+	switch query.Kind {
+	case store.KindCluster, store.KindQueue, store.KindUser, store.KindProject, store.KindNodeState:
+	default:
+		writeError(w, http.StatusBadRequest, "unknown kind "+query.Kind)
+		return
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if query.From, err = parseTime(v, now); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if query.To, err = parseTime(v, now); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if v := q.Get("step"); v != "" {
+		if query.Step, err = time.ParseDuration(v); err != nil || query.Step < 0 {
+			writeError(w, http.StatusBadRequest, "invalid step "+v)
+			return
+		}
+	}
+
+	series, err := st.Query(query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := make([]HistorySeries, 0, len(series))
+	for _, s := range series {
+		hs := HistorySeries{Kind: s.Kind, Name: s.Name, Metric: s.Metric, Points: make([][2]float64, 0, len(s.Points))}
+		for _, p := range s.Points {
+			hs.Points = append(hs.Points, [2]float64{float64(p.Time.Unix()), p.Value})
+		}
+		out = append(out, hs)
+	}
+	writeJSON(w, r, out)
+}
+
+// parseTime accepts RFC 3339, unix seconds or a duration relative to now ("-720h")
+func parseTime(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (expected RFC 3339, unix seconds or a duration such as -24h)", v)
+}
@@ -0,0 +1,24 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// Prefix is the path the dashboard is mounted under
+const Prefix = "/dashboard/"
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the embedded dashboard. The page only talks to the JSON API
+// of the same exporter, so it works without any external assets.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// The embedded directory is fixed at build time
+		panic(err)
+	}
+	return http.StripPrefix(Prefix, http.FileServer(http.FS(files)))
+}
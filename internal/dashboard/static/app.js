@@ -0,0 +1,171 @@
+"use strict";
+
+// The dashboard polls the exporter's JSON API; ETags keep unchanged responses cheap
+const api = "../api/v1/";
+const refreshMs = 30000;
+const topUsers = 20;
+
+const cache = {};
+
+// cluster is the selected cluster when the exporter monitors several (kept in the URL hash)
+let cluster = decodeURIComponent(location.hash.slice(1));
+
+async function get(path) {
+  if (cluster) {
+    path += "?cluster=" + encodeURIComponent(cluster);
+  }
+  const headers = {};
+  if (cache[path]) {
+    headers["If-None-Match"] = cache[path].etag;
+  }
+  const resp = await fetch(api + path, { headers });
+  if (resp.status === 304) {
+    return cache[path].data;
+  }
+  if (!resp.ok) {
+    throw new Error(path + ": " + resp.status + " " + resp.statusText);
+  }
+  const data = await resp.json();
+  cache[path] = { etag: resp.headers.get("ETag"), data };
+  return data;
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    e.setAttribute(k, v);
+  }
+  for (const c of children) {
+    e.append(c);
+  }
+  return e;
+}
+
+// nodeClass maps PBS node states ("free", "job-busy", "down,offline", ...) to a colour
+function nodeClass(n) {
+  const s = n.state;
+  if (s.includes("down") || s.includes("unknown") || s.includes("stale")) return "down";
+  if (s.includes("offline")) return "offline";
+  if (s.includes("busy") || s.includes("exclusive")) return "busy";
+  if (s.includes("free")) return n.jobs > 0 ? "partial" : "free";
+  return "other";
+}
+
+const legend = [
+  ["free", "free"],
+  ["partial", "partially used"],
+  ["busy", "busy"],
+  ["offline", "offline"],
+  ["down", "down"],
+  ["other", "other"],
+];
+
+function renderLegend() {
+  const box = document.getElementById("legend");
+  for (const [cls, label] of legend) {
+    box.append(el("span", { style: "--swatch: var(--" + cls + ")" }, label));
+  }
+}
+
+// renderClusters offers a cluster picker when there is more than one cluster
+function renderClusters(clusters) {
+  const select = document.getElementById("cluster");
+  if (clusters.length < 2) {
+    select.hidden = true;
+    return;
+  }
+  if (!clusters.some((c) => c.name === cluster)) {
+    cluster = clusters[0].name;
+  }
+  select.replaceChildren(...clusters.map((c) =>
+    el("option", c.name === cluster ? { value: c.name, selected: "" } : { value: c.name },
+      c.name + (c.up ? "" : " (down)"))));
+  select.hidden = false;
+}
+
+function renderSummary(s) {
+  document.getElementById("server").textContent = s.server ? s.server.name : "";
+  document.getElementById("updated").textContent =
+    "updated " + new Date(s.updated_at).toLocaleTimeString();
+
+  const totals = [
+    [s.jobs.running, "running jobs"],
+    [s.jobs.queued, "queued jobs"],
+    [s.jobs.held, "held jobs"],
+    [s.nodes.total, "nodes (" + s.nodes.down + " down, " + s.nodes.offline + " offline)"],
+    [s.cpus_total - s.cpus_available + " / " + s.cpus_total, "CPUs in use"],
+    [s.gpus_total - s.gpus_available + " / " + s.gpus_total, "GPUs in use"],
+  ];
+  if (s.server && !s.server.scheduling) {
+    totals.push(["off", "scheduling"]);
+  }
+
+  const box = document.getElementById("totals");
+  box.replaceChildren(...totals.map(([v, label]) => el("div", {}, el("b", {}, String(v)), el("small", {}, label))));
+}
+
+function renderNodes(nodes) {
+  const grid = document.getElementById("nodes");
+  grid.replaceChildren(...nodes.map((n) => {
+    const title = n.name + "\n" + n.state +
+      "\nCPUs " + (n.cpus_total - n.cpus_available) + "/" + n.cpus_total +
+      "\nGPUs " + (n.gpus_total - n.gpus_available) + "/" + n.gpus_total +
+      "\nMemory " + (n.memory_total_gb - n.memory_available_gb).toFixed(0) + "/" + n.memory_total_gb.toFixed(0) + " GB" +
+      "\nJobs " + n.jobs;
+    return el("div", { class: "node state-" + nodeClass(n), title },
+      n.name, el("small", {}, n.state));
+  }));
+}
+
+function renderQueues(queues) {
+  const body = document.querySelector("#queues tbody");
+  body.replaceChildren(...queues.map((q) => {
+    const ok = q.enabled && q.started;
+    const state = [q.enabled ? "enabled" : "disabled", q.started ? "started" : "stopped"].join(", ");
+    return el("tr", {},
+      el("td", {}, q.name),
+      el("td", ok ? {} : { class: "bad" }, state),
+      el("td", { class: "num" }, String(q.running)),
+      el("td", { class: "num" }, String(q.queued)));
+  }));
+}
+
+function renderUsers(users) {
+  const body = document.querySelector("#users tbody");
+  body.replaceChildren(...users.slice(0, topUsers).map((u) => el("tr", {},
+    el("td", {}, u.name),
+    el("td", { class: "num" }, String(u.running)),
+    el("td", { class: "num" }, String(u.queued)),
+    el("td", { class: "num" }, String(u.held)),
+    el("td", { class: "num" }, String(u.running_cpus)),
+    el("td", { class: "num" }, String(u.running_gpus)),
+    el("td", { class: "num" }, u.running_memory_gb.toFixed(1)))));
+}
+
+async function refresh() {
+  const error = document.getElementById("error");
+  try {
+    renderClusters(await get("clusters"));
+    const [summary, nodes, queues, users] = await Promise.all([
+      get("summary"), get("nodes"), get("queues"), get("users"),
+    ]);
+    renderSummary(summary);
+    renderNodes(nodes);
+    renderQueues(queues);
+    renderUsers(users);
+    error.hidden = true;
+  } catch (e) {
+    error.textContent = "Could not load cluster state: " + e.message;
+    error.hidden = false;
+  }
+}
+
+document.getElementById("cluster").addEventListener("change", (e) => {
+  cluster = e.target.value;
+  location.hash = encodeURIComponent(cluster);
+  refresh();
+});
+
+renderLegend();
+refresh();
+setInterval(refresh, refreshMs);
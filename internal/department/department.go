@@ -0,0 +1,325 @@
+package department
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Unknown is the department reported for users without a mapping
+const Unknown = "unknown"
+
+// Source loads a username -> department mapping
+type Source interface {
+	Load() (map[string]string, error)
+}
+
+// Mapper resolves usernames to departments, reloading its source periodically
+// in the background so lookups never wait on the source
+type Mapper struct {
+	source  Source
+	refresh time.Duration
+
+	mu        sync.Mutex
+	mapping   map[string]string
+	loadedAt  time.Time
+	reloading bool
+}
+
+// NewMapper creates a mapper and performs the initial load
+func NewMapper(source Source, refresh time.Duration) (*Mapper, error) {
+	m := &Mapper{source: source, refresh: refresh}
+	mapping, err := source.Load()
+	if err != nil {
+		return nil, err
+	}
+	m.mapping = mapping
+	m.loadedAt = time.Now()
+	return m, nil
+}
+
+// Lookup returns the department for a user, or Unknown. When the mapping is
+// older than the refresh interval, a reload is started and the current
+// mapping keeps being served until it completes.
+func (m *Mapper) Lookup(user string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.refresh > 0 && !m.reloading && time.Since(m.loadedAt) > m.refresh {
+		m.reloading = true
+		go m.reload()
+	}
+
+	if dept, ok := m.mapping[user]; ok && dept != "" {
+		return dept
+	}
+	return Unknown
+}
+
+// reload loads the source again, keeping the previous mapping if that fails
+func (m *Mapper) reload() {
+	mapping, err := m.source.Load()
+	if err != nil {
+		log.Printf("Error reloading department mapping: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.mapping = mapping
+	}
+	m.loadedAt = time.Now()
+	m.reloading = false
+}
+
+// FileSource reads the mapping from a CSV ("user,department") or YAML file.
+// YAML files may map users to departments ("alice: physics") or departments to
+// lists of users ("physics: [alice, bob]").
+type FileSource struct {
+	Path string
+}
+
+// Load implements Source
+func (s FileSource) Load() (map[string]string, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading department file: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(s.Path)) {
+	case ".yaml", ".yml":
+		return parseYAML(f)
+	default:
+		return parseCSV(f)
+	}
+}
+
+// parseCSV reads "user,department" records; lines starting with # are ignored
+func parseCSV(r io.Reader) (map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	mapping := make(map[string]string)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing department CSV: %w", err)
+		}
+		if len(record) < 2 {
+			continue
+		}
+		user, dept := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		// Allow an optional "user,department" header row
+		if strings.EqualFold(user, "user") && strings.EqualFold(dept, "department") {
+			continue
+		}
+		mapping[user] = dept
+	}
+	return mapping, nil
+}
+
+// parseYAML reads either "user: department" or "department: [users]" entries
+func parseYAML(r io.Reader) (map[string]string, error) {
+	var doc map[string]interface{}
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing department YAML: %w", err)
+	}
+
+	mapping := make(map[string]string)
+	for key, value := range doc {
+		switch v := value.(type) {
+		case string:
+			mapping[key] = v
+		case []interface{}:
+			for _, user := range v {
+				if name, ok := user.(string); ok {
+					mapping[name] = key
+				}
+			}
+		default:
+			return nil, fmt.Errorf("parsing department YAML: unsupported value for %q", key)
+		}
+	}
+	return mapping, nil
+}
+
+// GroupSource maps users to the system groups whose names match Pattern.
+// A user's primary group (`getent passwd`) wins; otherwise the first group
+// listing the user as a member (`getent group`) in database order wins.
+type GroupSource struct {
+	Pattern *regexp.Regexp
+	// Timeout bounds each getent command so a hung NSS lookup cannot stall a reload (0 disables)
+	Timeout time.Duration
+}
+
+// Load implements Source
+func (s GroupSource) Load() (map[string]string, error) {
+	groups, err := output(s.Timeout, "getent", "group")
+	if err != nil {
+		return nil, fmt.Errorf("running getent group: %w", err)
+	}
+	passwd, err := output(s.Timeout, "getent", "passwd")
+	if err != nil {
+		return nil, fmt.Errorf("running getent passwd: %w", err)
+	}
+	return parseGroupDB(string(groups), string(passwd), s.Pattern), nil
+}
+
+// parseGroupDB maps users to departments from group(5) lines
+// ("name:password:gid:user1,user2") and passwd(5) lines
+// ("name:password:uid:gid:gecos:home:shell")
+func parseGroupDB(groups, passwd string, pattern *regexp.Regexp) map[string]string {
+	mapping := make(map[string]string)
+	byGID := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(groups))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) < 4 {
+			continue
+		}
+		group := fields[0]
+		if pattern != nil && !pattern.MatchString(group) {
+			continue
+		}
+		if _, seen := byGID[fields[2]]; !seen {
+			byGID[fields[2]] = group
+		}
+		for _, user := range strings.Split(fields[3], ",") {
+			user = strings.TrimSpace(user)
+			if _, seen := mapping[user]; user != "" && !seen {
+				mapping[user] = group
+			}
+		}
+	}
+
+	// Primary group members are usually not listed in the group database
+	scanner = bufio.NewScanner(strings.NewReader(passwd))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) < 4 || fields[0] == "" {
+			continue
+		}
+		if group, ok := byGID[fields[3]]; ok {
+			mapping[fields[0]] = group
+		}
+	}
+	return mapping
+}
+
+// LDAPSource queries an LDAP directory with `ldapsearch` and maps UserAttr to DeptAttr
+type LDAPSource struct {
+	URI      string
+	BaseDN   string
+	Filter   string
+	UserAttr string
+	DeptAttr string
+	// Timeout bounds ldapsearch so a hung directory server cannot stall a reload (0 disables)
+	Timeout time.Duration
+}
+
+// Load implements Source
+func (s LDAPSource) Load() (map[string]string, error) {
+	args := []string{"-x", "-LLL", "-o", "ldif-wrap=no", "-H", s.URI}
+	if s.BaseDN != "" {
+		args = append(args, "-b", s.BaseDN)
+	}
+	args = append(args, s.Filter, s.UserAttr, s.DeptAttr)
+
+	ldif, err := output(s.Timeout, "ldapsearch", args...)
+	if err != nil {
+		return nil, fmt.Errorf("running ldapsearch: %w", err)
+	}
+	return parseLDIF(string(ldif), s.UserAttr, s.DeptAttr), nil
+}
+
+// output runs a command and returns its standard output, killing it after timeout
+func output(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Don't wait on children still holding the output pipe after a timeout
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return out, err
+}
+
+// parseLDIF extracts userAttr/deptAttr pairs from ldapsearch LDIF output
+func parseLDIF(output string, userAttr, deptAttr string) map[string]string {
+	mapping := make(map[string]string)
+	var user, dept string
+
+	flush := func() {
+		if user != "" && dept != "" {
+			mapping[user] = dept
+		}
+		user, dept = "", ""
+	}
+
+	// Unfold continuation lines (a leading space continues the previous line)
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		name := line[:idx]
+		value := strings.TrimSpace(line[idx+1:])
+		// "attr:: value" carries base64
+		if strings.HasPrefix(line[idx+1:], ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line[idx+2:]))
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		switch {
+		case strings.EqualFold(name, userAttr) && user == "":
+			user = value
+		case strings.EqualFold(name, deptAttr) && dept == "":
+			dept = value
+		}
+	}
+	flush()
+
+	return mapping
+}
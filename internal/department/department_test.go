@@ -0,0 +1,371 @@
+package department
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseLDIF(t *testing.T) {
+	tests := []struct {
+		name string
+		ldif string
+		want map[string]string
+	}{
+		{
+			name: "plain entries",
+			ldif: `dn: uid=alice,ou=people,dc=example,dc=org
+uid: alice
+departmentNumber: physics
+
+dn: uid=bob,ou=people,dc=example,dc=org
+departmentNumber: chemistry
+uid: bob
+`,
+			want: map[string]string{"alice": "physics", "bob": "chemistry"},
+		},
+		{
+			name: "folded lines",
+			ldif: `dn: uid=carol,ou=people,dc=exam
+ ple,dc=org
+uid: carol
+departmentNumber: Institute for Theoretical
+  Physics
+`,
+			want: map[string]string{"carol": "Institute for Theoretical Physics"},
+		},
+		{
+			name: "base64 values",
+			// "Chimie appliquée" and "josé" are not safe LDIF strings
+			ldif: `dn: uid=jose,ou=people,dc=example,dc=org
+uid:: am9zw6k=
+departmentNumber:: Q2hpbWllIGFwcGxpcXXDqWU=
+`,
+			want: map[string]string{"josé": "Chimie appliquée"},
+		},
+		{
+			name: "first value of multi-valued attributes, case-insensitive names",
+			ldif: `dn: uid=dave,ou=people,dc=example,dc=org
+UID: dave
+departmentnumber: biology
+departmentNumber: medicine
+`,
+			want: map[string]string{"dave": "biology"},
+		},
+		{
+			name: "entries without both attributes and invalid base64 are skipped",
+			ldif: `dn: uid=erin,ou=people,dc=example,dc=org
+uid: erin
+
+dn: uid=frank,ou=people,dc=example,dc=org
+uid: frank
+departmentNumber:: not base64!
+`,
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLDIF(tt.ldif, "uid", "departmentNumber"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLDIF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLDAPSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as ldapsearch")
+	}
+
+	// A stand-in ldapsearch that records its arguments and prints fixed LDIF
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := `#!/bin/sh
+printf '%s\n' "$@" > ` + argsFile + `
+cat <<'LDIF'
+dn: uid=alice,ou=people,dc=example,dc=org
+uid: alice
+ou: physics
+
+dn: uid=bob,ou=people,dc=example,dc=org
+uid: bob
+ou: chem
+ istry
+LDIF
+`
+	if err := os.WriteFile(filepath.Join(dir, "ldapsearch"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	source := LDAPSource{
+		URI:      "ldap://ldap.example.org",
+		BaseDN:   "ou=people,dc=example,dc=org",
+		Filter:   "(objectClass=posixAccount)",
+		UserAttr: "uid",
+		DeptAttr: "ou",
+	}
+	got, err := source.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := map[string]string{"alice": "physics", "bob": "chemistry"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-x -LLL -o ldif-wrap=no -H ldap://ldap.example.org -b ou=people,dc=example,dc=org (objectClass=posixAccount) uid ou"
+	if got := strings.Join(strings.Fields(string(args)), " "); got != want {
+		t.Errorf("ldapsearch arguments = %q, want %q", got, want)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "csv with header and comments",
+			file: "departments.csv",
+			content: `user,department
+# staff
+alice, physics
+bob,"Chemistry, Organic"
+carol
+`,
+			want: map[string]string{"alice": "physics", "bob": "Chemistry, Organic"},
+		},
+		{
+			name:    "csv with unbalanced quotes",
+			file:    "departments.csv",
+			content: "alice,\"physics\n",
+			wantErr: true,
+		},
+		{
+			name: "yaml user to department",
+			file: "departments.yaml",
+			content: `alice: physics
+bob: chemistry
+`,
+			want: map[string]string{"alice": "physics", "bob": "chemistry"},
+		},
+		{
+			name: "yaml department to users",
+			file: "departments.yml",
+			content: `physics: [alice, bob]
+chemistry:
+  - carol
+`,
+			want: map[string]string{"alice": "physics", "bob": "physics", "carol": "chemistry"},
+		},
+		{
+			name:    "empty yaml",
+			file:    "departments.yaml",
+			content: "",
+			want:    map[string]string{},
+		},
+		{
+			name:    "yaml with unsupported values",
+			file:    "departments.yaml",
+			content: "alice: {dept: physics}\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := FileSource{Path: path}.Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (FileSource{Path: filepath.Join(t.TempDir(), "missing.csv")}).Load(); err == nil {
+		t.Error("Load() of a missing file succeeded")
+	}
+}
+
+func TestParseGroupDB(t *testing.T) {
+	groups := `root:x:0:
+physics:x:2001:alice,carol
+chemistry:x:2002:bob,carol
+users:x:100:alice,bob,carol,dave
+biology:x:2003:
+`
+	passwd := `root:x:0:0:root:/root:/bin/bash
+alice:x:1001:100:Alice:/home/alice:/bin/bash
+dave:x:1004:2003:Dave:/home/dave:/bin/bash
+erin:x:1005:2002:Erin:/home/erin:/bin/bash
+carol:x:1003:2002:Carol:/home/carol:/bin/bash
+`
+	got := parseGroupDB(groups, passwd, regexp.MustCompile(`^(physics|chemistry|biology)$`))
+	want := map[string]string{
+		"alice": "physics",   // member; the primary group users does not match
+		"bob":   "chemistry", // member
+		"carol": "chemistry", // primary group wins over the first membership
+		"dave":  "biology",   // primary group only
+		"erin":  "chemistry", // primary group only
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGroupDB() = %v, want %v", got, want)
+	}
+}
+
+// countingSource returns successive mappings, or err once set. While
+// release is set, loads wait for it to be closed.
+type countingSource struct {
+	mu      sync.Mutex
+	calls   int
+	loads   int
+	err     error
+	release chan struct{}
+}
+
+func (s *countingSource) Load() (map[string]string, error) {
+	s.mu.Lock()
+	s.calls++
+	release := s.release
+	s.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.loads++
+	if s.loads == 1 {
+		return map[string]string{"alice": "physics"}, nil
+	}
+	return map[string]string{"alice": "chemistry"}, nil
+}
+
+// set changes the source's error and release channel
+func (s *countingSource) set(err error, release chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err, s.release = err, release
+}
+
+// expire makes the mapping due for a reload
+func (m *Mapper) expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loadedAt = time.Now().Add(-2 * m.refresh)
+}
+
+// waitReload waits for a background reload to finish
+func (m *Mapper) waitReload(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		reloading := m.reloading
+		m.mu.Unlock()
+		if !reloading {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("reload did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMapperRefresh(t *testing.T) {
+	source := &countingSource{}
+	m, err := NewMapper(source, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Lookup("alice"); got != "physics" {
+		t.Errorf("Lookup(alice) = %q, want physics", got)
+	}
+	if got := m.Lookup("bob"); got != Unknown {
+		t.Errorf("Lookup(bob) = %q, want %q", got, Unknown)
+	}
+
+	// Lookups do not wait on a slow reload, and start only one
+	release := make(chan struct{})
+	source.set(errors.New("directory unavailable"), release)
+	m.expire()
+	for i := 0; i < 3; i++ {
+		if got := m.Lookup("alice"); got != "physics" {
+			t.Errorf("Lookup(alice) during a reload = %q, want physics", got)
+		}
+	}
+	close(release)
+	m.waitReload(t)
+	if source.calls != 2 {
+		t.Errorf("source loaded %d times, want 2", source.calls)
+	}
+
+	// A failed reload keeps the previous mapping
+	if got := m.Lookup("alice"); got != "physics" {
+		t.Errorf("Lookup(alice) after a failed reload = %q, want physics", got)
+	}
+
+	source.set(nil, nil)
+	m.expire()
+	m.Lookup("alice")
+	m.waitReload(t)
+	if got := m.Lookup("alice"); got != "chemistry" {
+		t.Errorf("Lookup(alice) after a reload = %q, want chemistry", got)
+	}
+}
+
+func TestSourceTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as getent and ldapsearch")
+	}
+
+	// Stand-ins for an unresponsive NSS backend and directory server
+	dir := t.TempDir()
+	for _, name := range []string{"getent", "ldapsearch"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	sources := map[string]Source{
+		"getent": GroupSource{Timeout: 100 * time.Millisecond},
+		"ldap":   LDAPSource{URI: "ldap://ldap.example.org", UserAttr: "uid", DeptAttr: "ou", Timeout: 100 * time.Millisecond},
+	}
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			_, err := source.Load()
+			if err == nil || !strings.Contains(err.Error(), "timed out") {
+				t.Errorf("Load() error = %v, want a timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Load() took %s", elapsed)
+			}
+		})
+	}
+}
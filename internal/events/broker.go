@@ -0,0 +1,135 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Filter selects the events a subscriber receives; empty fields match everything
+type Filter struct {
+	Types   []string
+	Cluster string
+	Node    string
+	Queue   string
+	User    string
+}
+
+// Match reports whether the event passes the filter
+func (f Filter) Match(ev Event) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == ev.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Cluster != "" && f.Cluster != ev.Cluster {
+		return false
+	}
+	if f.Node != "" && f.Node != ev.Node {
+		return false
+	}
+	if f.Queue != "" && f.Queue != ev.Queue {
+		return false
+	}
+	if f.User != "" && f.User != ev.User {
+		return false
+	}
+	return true
+}
+
+// Subscription receives published events on C until it is closed
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+}
+
+// Broker fans events out to subscribers. Each subscriber has a bounded
+// buffer; events that do not fit are dropped for that subscriber only, so a
+// slow client never blocks collection or other clients.
+type Broker struct {
+	bufferSize int
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+
+	published atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// NewBroker creates a broker whose subscribers buffer up to bufferSize events
+func NewBroker(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	return &Broker{
+		bufferSize: bufferSize,
+		subs:       make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	ch := make(chan Event, b.bufferSize)
+	sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish delivers events to every matching subscriber without blocking
+func (b *Broker) Publish(evs ...Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ev := range evs {
+		b.published.Add(1)
+		for sub := range b.subs {
+			if !sub.filter.Match(ev) {
+				continue
+			}
+			select {
+			case sub.ch <- ev:
+			default:
+				b.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Subscribers returns the current number of subscribers
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Published returns the total number of events published
+func (b *Broker) Published() uint64 {
+	return b.published.Load()
+}
+
+// Dropped returns the total number of events dropped because a subscriber's buffer was full
+func (b *Broker) Dropped() uint64 {
+	return b.dropped.Load()
+}
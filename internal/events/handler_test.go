@@ -0,0 +1,125 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamEvents are published to every subscriber of TestHandlerFilters. The
+// last one passes every filter used, so once it is received nothing else can follow.
+var streamEvents = []Event{
+	{Type: TypeJobStarted, Cluster: "a", Job: "1.pbs01", Queue: "long", User: "alice", Message: "1"},
+	{Type: TypeNodeFlap, Cluster: "b", Node: "n1", Message: "2"},
+	{Type: TypeJobCompleted, Cluster: "a", Job: "3.pbs01", Queue: "gpu", User: "bob", Message: "3"},
+	{Type: TypeJobCompleted, Cluster: "b", Node: "n1", Job: "4.pbs01", Queue: "gpu", User: "alice", Message: "4"},
+}
+
+// readStream reads the connected comment, then events until the one with message last
+func readStream(t *testing.T, r *bufio.Reader, last string) []Event {
+	t.Helper()
+	readLine := func() string {
+		t.Helper()
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		return strings.TrimSuffix(line, "\n")
+	}
+
+	if line, blank := readLine(), readLine(); line != ": connected" || blank != "" {
+		t.Fatalf("stream starts with %q, %q", line, blank)
+	}
+	var evs []Event
+	for len(evs) == 0 || evs[len(evs)-1].Message != last {
+		event, data, blank := readLine(), readLine(), readLine()
+		var ev Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &ev); err != nil {
+			t.Fatalf("event data %q: %v", data, err)
+		}
+		if event != "event: "+ev.Type || blank != "" {
+			t.Fatalf("event lines %q, %q, %q", event, data, blank)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestHandlerFilters(t *testing.T) {
+	b := NewBroker(16)
+	srv := httptest.NewServer(Handler(b))
+	defer srv.Close()
+
+	tests := []struct {
+		query string
+		want  []string // messages of the events received
+	}{
+		{"", []string{"1", "2", "3", "4"}},
+		{"?type=job_completed", []string{"3", "4"}},
+		{"?type=node_flap,%20job_completed", []string{"2", "3", "4"}},
+		{"?type=,", []string{"1", "2", "3", "4"}},
+		{"?cluster=b", []string{"2", "4"}},
+		{"?node=n1", []string{"2", "4"}},
+		{"?queue=gpu", []string{"3", "4"}},
+		{"?user=alice", []string{"1", "4"}},
+		{"?type=job_completed&cluster=b", []string{"4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+				t.Fatalf("status %d, content type %q", resp.StatusCode, ct)
+			}
+
+			// The handler subscribes before sending the response headers
+			b.Publish(streamEvents...)
+
+			var got []string
+			for _, ev := range readStream(t, bufio.NewReader(resp.Body), "4") {
+				got = append(got, ev.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received events %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Disconnected clients are unsubscribed
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Subscribers() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := b.Subscribers(); n != 0 {
+		t.Errorf("%d subscribers left after the clients disconnected", n)
+	}
+}
+
+// noFlushWriter hides the recorder's Flush method
+type noFlushWriter struct {
+	http.ResponseWriter
+}
+
+func TestHandlerNeedsFlusher(t *testing.T) {
+	b := NewBroker(1)
+	rec := httptest.NewRecorder()
+	Handler(b).ServeHTTP(noFlushWriter{rec}, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Code != http.StatusInternalServerError || b.Subscribers() != 0 {
+		t.Errorf("status %d with %d subscribers, want 500 without subscribing", rec.Code, b.Subscribers())
+	}
+}
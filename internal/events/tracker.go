@@ -0,0 +1,353 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"pbs-exporter/internal/pbs"
+)
+
+// Event types derived from snapshot diffs
+const (
+	TypeJobStarted        = "job_started"
+	TypeJobCompleted      = "job_completed"
+	TypeJobRequeued       = "job_requeued"
+	TypeNodeStateChanged  = "node_state_changed"
+	TypeNodeFlap          = "node_flap"
+	TypeQueueEnabled      = "queue_enabled"
+	TypeQueueDisabled     = "queue_disabled"
+	TypeQueueStarted      = "queue_started"
+	TypeQueueStopped      = "queue_stopped"
+	TypeQueueWaitExceeded = "queue_wait_exceeded"
+	TypeSchedulingStopped = "scheduling_stopped"
+	TypeSchedulingStarted = "scheduling_started"
+)
+
+// Event describes a change between two consecutive collections
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Cluster     string    `json:"cluster,omitempty"`
+	Job         string    `json:"job,omitempty"`
+	Node        string    `json:"node,omitempty"`
+	Queue       string    `json:"queue,omitempty"`
+	User        string    `json:"user,omitempty"`
+	From        string    `json:"from,omitempty"`
+	To          string    `json:"to,omitempty"`
+	WaitSeconds float64   `json:"wait_seconds,omitempty"`
+	Message     string    `json:"message"`
+}
+
+// jobState is the part of a job remembered between snapshots
+type jobState struct {
+	state string
+	queue string
+	user  string
+	// started is set once the job has run, and stays set while it is
+	// suspended, until it finishes or is requeued
+	started bool
+}
+
+// nodeHistory is the part of a node remembered between snapshots
+type nodeHistory struct {
+	state      string
+	prevState  string
+	lastChange time.Time
+}
+
+// Tracker keeps the previous job and node snapshot and reports what changed
+type Tracker struct {
+	// FlapWindow is how soon a node must return to its previous state to count as a flap
+	FlapWindow time.Duration
+	// QueueWaitThreshold raises queue_wait_exceeded when a queue's oldest job waits longer (0 disables)
+	QueueWaitThreshold time.Duration
+
+	mu         sync.Mutex
+	jobs       map[string]jobState
+	nodes      map[string]*nodeHistory
+	queues     map[string]pbs.QueueState
+	waitOver   map[string]bool
+	scheduling *bool
+}
+
+// NewTracker creates a tracker with no previous snapshot
+func NewTracker() *Tracker {
+	return &Tracker{FlapWindow: 15 * time.Minute}
+}
+
+// ObserveJobs records a job snapshot and returns the job events since the previous one.
+// The first snapshot only establishes the baseline. A job first seen already
+// exiting or suspended is reported as started, so its completion follows a
+// start; jobs that start and finish between two snapshots are not reported.
+func (t *Tracker) ObserveJobs(jobs []pbs.Job, now time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.jobs
+	cur := make(map[string]jobState, len(jobs))
+	for _, job := range jobs {
+		// The array parent summarizes its subjobs, which are tracked individually
+		if job.IsArray {
+			continue
+		}
+		old := prev[job.ID]
+		cur[job.ID] = jobState{
+			state:   job.State,
+			queue:   job.Queue,
+			user:    job.Owner,
+			started: isActive(job.State) || (old.started && !isRequeued(job.State) && !isFinished(job.State)),
+		}
+	}
+
+	t.jobs = cur
+	if prev == nil {
+		return nil
+	}
+
+	var events []Event
+	for _, job := range jobs {
+		if job.IsArray || !isActive(job.State) {
+			continue
+		}
+		// A job resumed after a suspension, or now exiting, has already started
+		if old, ok := prev[job.ID]; ok && old.started {
+			continue
+		}
+		ev := Event{
+			Type:  TypeJobStarted,
+			Time:  now,
+			Job:   job.ID,
+			Queue: job.Queue,
+			User:  job.Owner,
+		}
+		if wait, ok := waitTime(job); ok {
+			ev.WaitSeconds = wait.Seconds()
+			ev.Message = fmt.Sprintf("job %s started after %s in queue", job.ID, wait.Round(time.Minute))
+		} else {
+			ev.Message = fmt.Sprintf("job %s started", job.ID)
+		}
+		events = append(events, ev)
+	}
+
+	for id, old := range prev {
+		if !old.started {
+			continue
+		}
+		c, ok := cur[id]
+		switch {
+		case !ok || isFinished(c.state):
+			events = append(events, Event{
+				Type:    TypeJobCompleted,
+				Time:    now,
+				Job:     id,
+				Queue:   old.queue,
+				User:    old.user,
+				Message: fmt.Sprintf("job %s completed", id),
+			})
+		case isRequeued(c.state):
+			events = append(events, Event{
+				Type:    TypeJobRequeued,
+				Time:    now,
+				Job:     id,
+				Queue:   c.queue,
+				User:    old.user,
+				From:    old.state,
+				To:      c.state,
+				Message: fmt.Sprintf("job %s was requeued (%s -> %s)", id, old.state, c.state),
+			})
+		}
+	}
+
+	return events
+}
+
+// ObserveNodes records a node snapshot and returns the node events since the previous one
+func (t *Tracker) ObserveNodes(nodes map[string]pbs.NodeInfo, now time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.nodes == nil {
+		t.nodes = make(map[string]*nodeHistory, len(nodes))
+	}
+
+	var events []Event
+	for name, info := range nodes {
+		h, ok := t.nodes[name]
+		if !ok {
+			t.nodes[name] = &nodeHistory{state: info.State, lastChange: now}
+			continue
+		}
+		if h.state == info.State {
+			continue
+		}
+
+		events = append(events, Event{
+			Type:    TypeNodeStateChanged,
+			Time:    now,
+			Node:    name,
+			From:    h.state,
+			To:      info.State,
+			Message: nodeMessage(name, h.state, info.State),
+		})
+
+		// Returning to the state held before the last change, quickly, is a flap
+		if info.State == h.prevState && now.Sub(h.lastChange) <= t.FlapWindow {
+			events = append(events, Event{
+				Type:    TypeNodeFlap,
+				Time:    now,
+				Node:    name,
+				From:    h.state,
+				To:      info.State,
+				Message: fmt.Sprintf("node %s flapped %s -> %s -> %s", name, h.prevState, h.state, info.State),
+			})
+		}
+
+		h.prevState = h.state
+		h.state = info.State
+		h.lastChange = now
+	}
+
+	// Nodes removed from the server are forgotten
+	for name := range t.nodes {
+		if _, ok := nodes[name]; !ok {
+			delete(t.nodes, name)
+		}
+	}
+
+	return events
+}
+
+// ObserveQueues records the queue states and returns enable/disable and start/stop events
+func (t *Tracker) ObserveQueues(queues map[string]pbs.QueueState, now time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.queues
+	t.queues = queues
+	if prev == nil {
+		return nil
+	}
+
+	var events []Event
+	for name, cur := range queues {
+		old, ok := prev[name]
+		if !ok {
+			continue
+		}
+		if old.Enabled != cur.Enabled {
+			ev := Event{Type: TypeQueueEnabled, Time: now, Queue: name, Message: fmt.Sprintf("queue %s enabled", name)}
+			if !cur.Enabled {
+				ev.Type = TypeQueueDisabled
+				ev.Message = fmt.Sprintf("queue %s disabled", name)
+			}
+			events = append(events, ev)
+		}
+		if old.Started != cur.Started {
+			ev := Event{Type: TypeQueueStarted, Time: now, Queue: name, Message: fmt.Sprintf("queue %s started", name)}
+			if !cur.Started {
+				ev.Type = TypeQueueStopped
+				ev.Message = fmt.Sprintf("queue %s stopped", name)
+			}
+			events = append(events, ev)
+		}
+	}
+
+	return events
+}
+
+// ObserveQueueWaits checks each queue's oldest queued job against QueueWaitThreshold.
+// An event is raised when a queue crosses the threshold, not on every collection.
+func (t *Tracker) ObserveQueueWaits(oldest map[string]time.Duration, now time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.QueueWaitThreshold <= 0 {
+		return nil
+	}
+	if t.waitOver == nil {
+		t.waitOver = make(map[string]bool)
+	}
+
+	var events []Event
+	over := make(map[string]bool)
+	for queue, wait := range oldest {
+		if wait <= t.QueueWaitThreshold {
+			continue
+		}
+		over[queue] = true
+		if !t.waitOver[queue] {
+			events = append(events, Event{
+				Type:        TypeQueueWaitExceeded,
+				Time:        now,
+				Queue:       queue,
+				WaitSeconds: wait.Seconds(),
+				Message:     fmt.Sprintf("oldest job in queue %s has waited %s", queue, wait.Round(time.Minute)),
+			})
+		}
+	}
+	t.waitOver = over
+
+	return events
+}
+
+// ObserveServer records whether the server is scheduling and returns an event when that changes
+func (t *Tracker) ObserveServer(status *pbs.ServerStatus, now time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.scheduling
+	cur := status.Scheduling
+	t.scheduling = &cur
+	if prev == nil || *prev == cur {
+		return nil
+	}
+
+	if cur {
+		return []Event{{Type: TypeSchedulingStarted, Time: now, Message: fmt.Sprintf("server %s resumed scheduling", status.Name)}}
+	}
+	return []Event{{Type: TypeSchedulingStopped, Time: now, Message: fmt.Sprintf("server %s stopped scheduling", status.Name)}}
+}
+
+// isActive reports whether a job is running, suspended or cleaning up
+func isActive(state string) bool {
+	return state == "R" || state == "E" || state == "S" || state == "U"
+}
+
+// isFinished reports whether a job is done: finished, a finished subjob or moved away
+func isFinished(state string) bool {
+	return state == "F" || state == "X" || state == "M"
+}
+
+// isRequeued reports whether a started job in this state was put back in the
+// queue, e.g. by qrerun or a node failure; qhold on a running job requeues it held
+func isRequeued(state string) bool {
+	return state == "Q" || state == "H" || state == "W"
+}
+
+// waitTime returns how long a job was queued before it started
+func waitTime(job pbs.Job) (time.Duration, bool) {
+	queued, ok := job.Time("qtime")
+	if !ok {
+		return 0, false
+	}
+	started, ok := job.Time("stime")
+	if !ok || started.Before(queued) {
+		return 0, false
+	}
+	return started.Sub(queued), true
+}
+
+// nodeMessage describes a node state change for humans
+func nodeMessage(node, from, to string) string {
+	switch {
+	case strings.Contains(to, "down"):
+		return fmt.Sprintf("node %s went down", node)
+	case strings.Contains(to, "offline"):
+		return fmt.Sprintf("node %s went offline", node)
+	case to == "free":
+		return fmt.Sprintf("node %s is free again (was %s)", node, from)
+	}
+	return fmt.Sprintf("node %s changed from %s to %s", node, from, to)
+}
@@ -0,0 +1,109 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"pbs-exporter/internal/pbs"
+)
+
+func TestObserveJobs(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []string // the job's state in each snapshot, "" when it is not listed
+		want   []string // event types from the last snapshot
+	}{
+		{"started", []string{"Q", "R"}, []string{TypeJobStarted}},
+		{"completed by leaving the list", []string{"R", ""}, []string{TypeJobCompleted}},
+		{"completed while exiting", []string{"E", ""}, []string{TypeJobCompleted}},
+		{"finished subjob", []string{"R", "X"}, []string{TypeJobCompleted}},
+		{"finished in history", []string{"R", "F"}, []string{TypeJobCompleted}},
+		{"requeued", []string{"R", "Q"}, []string{TypeJobRequeued}},
+		{"requeued held", []string{"R", "H"}, []string{TypeJobRequeued}},
+		{"suspended", []string{"R", "S"}, nil},
+		{"resumed", []string{"R", "S", "R"}, nil},
+		{"completed after suspension", []string{"R", "S", ""}, []string{TypeJobCompleted}},
+		{"started again after requeue", []string{"R", "Q", "R"}, []string{TypeJobStarted}},
+		{"queued job removed", []string{"Q", ""}, nil},
+		{"held job released", []string{"H", "Q"}, nil},
+	}
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker()
+			var evs []Event
+			for i, state := range tt.states {
+				var jobs []pbs.Job
+				if state != "" {
+					jobs = []pbs.Job{{ID: "1.pbs01", Owner: "alice", Queue: "long", State: state}}
+				}
+				evs = tracker.ObserveJobs(jobs, start.Add(time.Duration(i)*time.Minute))
+			}
+
+			var got []string
+			for _, ev := range evs {
+				got = append(got, ev.Type)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("events = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestObserveJobsFirstSeen(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []string // the job's state in each snapshot after an empty baseline, "" when it is not listed
+		want   []string // event types from all snapshots
+	}{
+		{"first seen running", []string{"R", ""}, []string{TypeJobStarted, TypeJobCompleted}},
+		{"first seen exiting", []string{"E", ""}, []string{TypeJobStarted, TypeJobCompleted}},
+		{"first seen exiting, then finished", []string{"E", "F"}, []string{TypeJobStarted, TypeJobCompleted}},
+		{"first seen suspended, then resumed", []string{"S", "R", ""}, []string{TypeJobStarted, TypeJobCompleted}},
+		// Started and finished between two snapshots: neither is reported
+		{"first seen finished", []string{"F", ""}, nil},
+		{"first seen as a finished subjob", []string{"X"}, nil},
+	}
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker()
+			tracker.ObserveJobs(nil, start)
+
+			var got []string
+			for i, state := range tt.states {
+				var jobs []pbs.Job
+				if state != "" {
+					jobs = []pbs.Job{{ID: "1.pbs01", Owner: "alice", Queue: "long", State: state}}
+				}
+				for _, ev := range tracker.ObserveJobs(jobs, start.Add(time.Duration(i+1)*time.Minute)) {
+					got = append(got, ev.Type)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveJobsRequeueFields(t *testing.T) {
+	tracker := NewTracker()
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	tracker.ObserveJobs([]pbs.Job{{ID: "1.pbs01", Owner: "alice", Queue: "long", State: "R"}}, now)
+	evs := tracker.ObserveJobs([]pbs.Job{{ID: "1.pbs01", Owner: "alice", Queue: "long", State: "Q"}}, now.Add(time.Minute))
+	if len(evs) != 1 {
+		t.Fatalf("got %d events, want 1", len(evs))
+	}
+	ev := evs[0]
+	if ev.Job != "1.pbs01" || ev.Queue != "long" || ev.User != "alice" || ev.From != "R" || ev.To != "Q" {
+		t.Errorf("requeue event = %+v", ev)
+	}
+}
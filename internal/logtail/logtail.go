@@ -0,0 +1,144 @@
+package logtail
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Tailer follows a directory of daily PBS log files named YYYYMMDD
+// (server_priv/accounting, sched_logs, server_logs...). Each call to
+// ReadLines returns the complete lines appended since the previous call and
+// switches to the next day's file once it appears.
+type Tailer struct {
+	dir     string
+	name    string
+	file    *os.File
+	offset  int64
+	partial []byte
+	primed  bool
+
+	// Now is used to pick the current day's file; defaults to time.Now
+	Now func() time.Time
+}
+
+// New creates a tailer for dir. The first file opened is read from its end,
+// so existing history is not replayed; files that appear later are read from the start.
+func New(dir string) *Tailer {
+	return &Tailer{dir: dir, Now: time.Now}
+}
+
+// FileName returns the log file name for a day
+func FileName(day time.Time) string {
+	return day.Format("20060102")
+}
+
+// ReadLines returns the new complete lines since the last call
+func (t *Tailer) ReadLines() ([]string, error) {
+	today := FileName(t.Now())
+	var lines []string
+
+	if t.file == nil {
+		if err := t.open(today, !t.primed); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				t.primed = true
+				return nil, nil
+			}
+			return nil, err
+		}
+		t.primed = true
+	}
+
+	// Drain the current file first so records written just before midnight are not lost
+	read, err := t.read()
+	lines = append(lines, read...)
+	if err != nil {
+		return lines, err
+	}
+
+	if t.name != today {
+		if _, err := os.Stat(filepath.Join(t.dir, today)); err == nil {
+			t.close()
+			if err := t.open(today, false); err != nil {
+				return lines, err
+			}
+			read, err := t.read()
+			lines = append(lines, read...)
+			if err != nil {
+				return lines, err
+			}
+		}
+	}
+
+	return lines, nil
+}
+
+// Close releases the open file
+func (t *Tailer) Close() error {
+	return t.close()
+}
+
+// open opens the named file, optionally positioning at its end
+func (t *Tailer) open(name string, atEnd bool) error {
+	f, err := os.Open(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+	t.offset = 0
+	if atEnd {
+		if t.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	t.file = f
+	t.name = name
+	t.partial = nil
+	return nil
+}
+
+// close closes the current file, dropping any incomplete trailing line
+func (t *Tailer) close() error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	t.partial = nil
+	return err
+}
+
+// read returns the complete lines appended to the current file
+func (t *Tailer) read() ([]string, error) {
+	// A file that shrank was truncated or replaced; start again from the top
+	if info, err := t.file.Stat(); err == nil && info.Size() < t.offset {
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		t.offset = 0
+		t.partial = nil
+	}
+
+	data, err := io.ReadAll(t.file)
+	t.offset += int64(len(data))
+	if len(data) == 0 {
+		return nil, err
+	}
+
+	data = append(t.partial, data...)
+	var lines []string
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			break
+		}
+		lines = append(lines, string(bytes.TrimRight(data[:idx], "\r")))
+		data = data[idx+1:]
+	}
+	t.partial = append([]byte(nil), data...)
+
+	return lines, err
+}
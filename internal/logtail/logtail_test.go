@@ -0,0 +1,133 @@
+package logtail
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// appendLog appends data to the daily log file name in dir, creating it if needed
+func appendLog(t *testing.T, dir, name, data string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	day1 := time.Date(2026, 10, 18, 23, 59, 0, 0, time.Local)
+	day2 := day1.Add(2 * time.Minute)
+
+	// Each step appends to files, reads at now and expects lines
+	type step struct {
+		name   string
+		writes map[string]string
+		now    time.Time
+		want   []string
+	}
+	tests := []struct {
+		name  string
+		setup map[string]string // files present before the first read
+		steps []step
+	}{
+		{
+			name:  "existing content is skipped",
+			setup: map[string]string{"20261018": "old 1\nold 2\n"},
+			steps: []step{
+				{name: "prime", now: day1},
+				{name: "append", writes: map[string]string{"20261018": "new 1\nnew 2\n"}, now: day1, want: []string{"new 1", "new 2"}},
+				{name: "nothing new", now: day1},
+			},
+		},
+		{
+			name:  "partial lines wait for their newline",
+			setup: map[string]string{"20261018": ""},
+			steps: []step{
+				{name: "prime", now: day1},
+				{name: "partial", writes: map[string]string{"20261018": "half"}, now: day1},
+				{name: "complete", writes: map[string]string{"20261018": " a line\r\nnext\n"}, now: day1, want: []string{"half a line", "next"}},
+			},
+		},
+		{
+			name:  "rotation at midnight",
+			setup: map[string]string{"20261018": ""},
+			steps: []step{
+				{name: "prime", now: day1},
+				{name: "before midnight", writes: map[string]string{"20261018": "late 1\n"}, now: day1, want: []string{"late 1"}},
+				{name: "new file not there yet", writes: map[string]string{"20261018": "late 2\n"}, now: day2, want: []string{"late 2"}},
+				// The old file is drained before switching; the new one is read from its start
+				{
+					name:   "new file appears",
+					writes: map[string]string{"20261018": "late 3\n", "20261019": "early 1\n"},
+					now:    day2,
+					want:   []string{"late 3", "early 1"},
+				},
+				{name: "old file is no longer read", writes: map[string]string{"20261018": "ignored\n", "20261019": "early 2\n"}, now: day2, want: []string{"early 2"}},
+			},
+		},
+		{
+			name: "a file created later is read from its start",
+			steps: []step{
+				{name: "no file yet", now: day1},
+				{name: "created", writes: map[string]string{"20261018": "first\n"}, now: day1, want: []string{"first"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, data := range tt.setup {
+				appendLog(t, dir, name, data)
+			}
+			tailer := New(dir)
+			defer tailer.Close()
+
+			for _, st := range tt.steps {
+				for name, data := range st.writes {
+					appendLog(t, dir, name, data)
+				}
+				now := st.now
+				tailer.Now = func() time.Time { return now }
+				got, err := tailer.ReadLines()
+				if err != nil {
+					t.Fatalf("%s: ReadLines: %v", st.name, err)
+				}
+				if (len(got) != 0 || len(st.want) != 0) && !reflect.DeepEqual(got, st.want) {
+					t.Errorf("%s: ReadLines() = %q, want %q", st.name, got, st.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	appendLog(t, dir, FileName(now), "a long line written before the exporter started\n")
+
+	tailer := New(dir)
+	defer tailer.Close()
+	tailer.Now = func() time.Time { return now }
+	if _, err := tailer.ReadLines(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is replaced by a shorter one in place
+	if err := os.WriteFile(filepath.Join(dir, FileName(now)), []byte("short\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tailer.ReadLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"short"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadLines() after truncation = %q, want %q", got, want)
+	}
+}
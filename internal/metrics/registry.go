@@ -1,9 +1,29 @@
 package metrics
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Metric groups, each filled by the collector of the same name
+const (
+	GroupJobs         = "jobs"
+	GroupNodes        = "nodes"
+	GroupQueues       = "queues"
+	GroupServer       = "server"
+	GroupHistory      = "history"
+	GroupAccounting   = "accounting"
+	GroupReservations = "reservations"
+	GroupScheduler    = "scheduler"
 )
 
+// DefaultGroups are the groups registered by NewRegistry. GroupHistory,
+// GroupAccounting and GroupScheduler are registered by their Enable methods, and
+// GroupReservations only when its collector is switched on.
+var DefaultGroups = []string{GroupJobs, GroupNodes, GroupQueues, GroupServer}
+
 // Registry holds all Prometheus metrics for the PBS exporter
 type Registry struct {
 	// Job metrics
@@ -20,6 +40,28 @@ type Registry struct {
 	TotalAllJobs       prometheus.Gauge
 	JobsByStatus       *prometheus.GaugeVec
 
+	// Per-user demand metrics
+	QueuedJobsByUser    *prometheus.GaugeVec
+	HeldJobsByUser      *prometheus.GaugeVec
+	UserAllocatedCpus   *prometheus.GaugeVec
+	UserAllocatedGpus   *prometheus.GaugeVec
+	UserAllocatedMemory *prometheus.GaugeVec
+	UserRequestedCpus   *prometheus.GaugeVec
+	UserRequestedGpus   *prometheus.GaugeVec
+	UserRequestedMemory *prometheus.GaugeVec
+
+	// Per-project, per-account and per-group aggregations (registered on demand)
+	ProjectUsage *UsageGauges
+	AccountUsage *UsageGauges
+	GroupUsage   *UsageGauges
+
+	// Per-department aggregation (registered when a department mapping is configured)
+	DepartmentUsage *UsageGauges
+
+	// Array job metrics
+	ArraySubjobs          *prometheus.GaugeVec
+	ArraySubjobsSubmitted *prometheus.GaugeVec
+
 	// Node metrics
 	NodeState            *prometheus.GaugeVec
 	NodeJobs             *prometheus.GaugeVec
@@ -33,23 +75,136 @@ type Registry struct {
 	NodeMemoryUsed       *prometheus.GaugeVec
 	NodeMemoryTotal      *prometheus.GaugeVec
 
+	// Snapshot transition counters
+	JobsStarted          *prometheus.CounterVec
+	JobsCompleted        *prometheus.CounterVec
+	JobsRequeued         *prometheus.CounterVec
+	NodeStateTransitions *prometheus.CounterVec
+	NodeFlaps            *prometheus.CounterVec
+
 	// Node count metrics
 	NodeCountFree    prometheus.Gauge
 	NodeCountBusy    prometheus.Gauge
 	NodeCountOffline prometheus.Gauge
 	NodeCountDown    prometheus.Gauge
 
+	// Collection status of the PBS server
+	Up                prometheus.Gauge
+	CommandErrors     *prometheus.CounterVec
+	CollectorDuration *prometheus.GaugeVec
+	CollectorSuccess  *prometheus.GaugeVec
+
+	// Server and queue wait metrics
+	ServerScheduling   prometheus.Gauge
+	QueueOldestJobWait *prometheus.GaugeVec
+
 	// qstat -q summary totals
 	QueueSummaryRunning prometheus.Gauge
 	QueueSummaryQueued  prometheus.Gauge
 	QueueQueuedByQueue  *prometheus.GaugeVec
 
-	// Prometheus registry
+	// Accounting log metrics (registered when accounting ingestion is enabled)
+	AccountingJobEvents   *prometheus.CounterVec
+	AccountingExitStatus  *prometheus.CounterVec
+	AccountingCPUHours    *prometheus.CounterVec
+	AccountingGPUHours    *prometheus.CounterVec
+	AccountingRecords     *prometheus.CounterVec
+	AccountingParseErrors prometheus.Counter
+
+	// Job exit metrics
+	JobExits       *prometheus.CounterVec
+	JobExitsByUser *prometheus.CounterVec
+
+	// Job history metrics from `qstat -x -f` (registered when the history window is set)
+	HistoryJobs        *prometheus.CounterVec
+	HistoryWaitSeconds *prometheus.HistogramVec
+	HistoryRunSeconds  *prometheus.HistogramVec
+	HistoryWindowJobs  *prometheus.GaugeVec
+	HistoryWindowWait  *prometheus.GaugeVec
+	HistoryWindowRun   *prometheus.GaugeVec
+
+	// Reservation metrics from `pbs_rstat -f`
+	Reservations      *prometheus.GaugeVec
+	ReservationInfo   *prometheus.GaugeVec
+	ReservationState  *prometheus.GaugeVec
+	ReservationStart  *prometheus.GaugeVec
+	ReservationEnd    *prometheus.GaugeVec
+	ReservationNodes  *prometheus.GaugeVec
+	ReservationCpus   *prometheus.GaugeVec
+	ReservationGpus   *prometheus.GaugeVec
+	NodeInReservation *prometheus.GaugeVec
+
+	// Scheduler cycle metrics from sched_logs (registered when the scheduler log is tailed)
+	SchedCycleDuration  prometheus.Histogram
+	SchedJobsConsidered prometheus.Histogram
+	SchedJobsRun        prometheus.Histogram
+	SchedJobsNotRunning *prometheus.CounterVec
+	SchedLogParseErrors prometheus.Counter
+
+	// Output sink metrics (registered when metrics are written somewhere besides /metrics)
+	OutputWrites      *prometheus.CounterVec
+	OutputLastSuccess *prometheus.GaugeVec
+
+	// Prometheus registry the metrics are gathered from, and the root holding it
 	registry *prometheus.Registry
+	root     *Root
+	// registerer adds this registry's metrics to registry, labelled with the cluster if there is one
+	registerer prometheus.Registerer
+	// usersDropped is set by DropUserMetrics
+	usersDropped bool
+}
+
+// durationBuckets covers job wait and run times from one minute to one week
+var durationBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400, 172800, 604800}
+
+// schedCycleBuckets covers scheduling cycles from a tenth of a second to ten minutes
+var schedCycleBuckets = []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600}
+
+// schedJobBuckets covers the jobs handled in one scheduling cycle
+var schedJobBuckets = []float64{0, 1, 5, 10, 50, 100, 500, 1000, 5000, 10000}
+
+// Root is the Prometheus registry the metrics are gathered from, shared by
+// the registries of several clusters
+type Root struct {
+	registry *prometheus.Registry
+	// mu keeps gathering out of metric updates: updates hold it shared, Gather exclusively
+	mu sync.RWMutex
+}
+
+// NewRoot creates an empty root registry
+func NewRoot() *Root {
+	return &Root{registry: prometheus.NewRegistry()}
+}
+
+// Gather collects the metrics once no collector is halfway through an update
+func (root *Root) Gather() ([]*dto.MetricFamily, error) {
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	return root.registry.Gather()
 }
 
 // NewRegistry creates and returns a new metrics registry
 func NewRegistry() *Registry {
+	root := NewRoot()
+	return newRegistry(root, root.registry, DefaultGroups)
+}
+
+// NewRegistryFor creates a registry with only the metrics of the given groups
+// and the collection status metrics, e.g. for a probe of part of a server
+func NewRegistryFor(groups []string) *Registry {
+	root := NewRoot()
+	return newRegistry(root, root.registry, groups)
+}
+
+// NewClusterRegistry creates a registry with the metrics of groups for one of
+// several PBS servers. Its metrics are registered in root with a cluster label; the process-wide
+// event, notifier and output metrics are registered in root unlabelled.
+func NewClusterRegistry(root *Root, cluster string, groups []string) *Registry {
+	return newRegistry(root, prometheus.WrapRegistererWith(prometheus.Labels{"cluster": cluster}, root.registry), groups)
+}
+
+// newRegistry creates the metrics and registers those of groups with registerer
+func newRegistry(root *Root, registerer prometheus.Registerer, groups []string) *Registry {
 	r := &Registry{
 		// Job metrics
 		RunningJobsByUser: prometheus.NewGaugeVec(
@@ -114,7 +269,7 @@ func NewRegistry() *Registry {
 		TotalEJobs: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "qstat_total_e_jobs",
-				Help: "Total number of Error (E) jobs",
+				Help: "Total number of Exiting (E) jobs",
 			},
 		),
 
@@ -140,6 +295,94 @@ func NewRegistry() *Registry {
 			[]string{"status"},
 		),
 
+		// Per-user demand metrics
+		QueuedJobsByUser: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "qstat_queued_jobs_by_user",
+				Help: "Number of queued jobs per user",
+			},
+			[]string{"user"},
+		),
+
+		HeldJobsByUser: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "qstat_held_jobs_by_user",
+				Help: "Number of held jobs per user",
+			},
+			[]string{"user"},
+		),
+
+		UserAllocatedCpus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_user_allocated_ncpus",
+				Help: "CPUs allocated to running jobs per user",
+			},
+			[]string{"user"},
+		),
+
+		UserAllocatedGpus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_user_allocated_ngpus",
+				Help: "GPUs allocated to running jobs per user",
+			},
+			[]string{"user"},
+		),
+
+		UserAllocatedMemory: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_user_allocated_memory_gb",
+				Help: "Memory allocated to running jobs per user in GB",
+			},
+			[]string{"user"},
+		),
+
+		UserRequestedCpus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_user_requested_ncpus",
+				Help: "CPUs requested by queued jobs per user",
+			},
+			[]string{"user"},
+		),
+
+		UserRequestedGpus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_user_requested_ngpus",
+				Help: "GPUs requested by queued jobs per user",
+			},
+			[]string{"user"},
+		),
+
+		UserRequestedMemory: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_user_requested_memory_gb",
+				Help: "Memory requested by queued jobs per user in GB",
+			},
+			[]string{"user"},
+		),
+
+		ProjectUsage: newUsageGauges("pbs_project", "project", "PBS project"),
+		AccountUsage: newUsageGauges("pbs_account", "account", "Account_Name"),
+		GroupUsage:   newUsageGauges("pbs_egroup", "egroup", "effective group"),
+
+		DepartmentUsage: newUsageGauges("pbs_department", "department", "department"),
+
+		// Array job metrics
+		ArraySubjobs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_array_subjobs",
+				Help: "Number of subjobs of an array job by state (queued, running, held, exiting, finished, failed)",
+			},
+			[]string{"array", "user", "queue", "state"},
+		),
+
+		ArraySubjobsSubmitted: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_array_subjobs_submitted",
+				Help: "Number of subjobs submitted in an array job",
+			},
+			[]string{"array", "user", "queue"},
+		),
+
 		// Node metrics
 		NodeState: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -229,6 +472,47 @@ func NewRegistry() *Registry {
 			[]string{"node"},
 		),
 
+		// Snapshot transition counters
+		JobsStarted: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_jobs_started_total",
+				Help: "Jobs seen entering the running state between two collections",
+			},
+			[]string{"queue"},
+		),
+
+		JobsCompleted: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_jobs_completed_total",
+				Help: "Started jobs seen leaving the system or finishing between two collections",
+			},
+			[]string{"queue"},
+		),
+
+		JobsRequeued: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_jobs_requeued_total",
+				Help: "Started jobs seen returning to the queued or held state between two collections",
+			},
+			[]string{"queue"},
+		),
+
+		NodeStateTransitions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_node_state_transitions_total",
+				Help: "Node state changes between two collections",
+			},
+			[]string{"node", "from", "to"},
+		),
+
+		NodeFlaps: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_node_flaps_total",
+				Help: "Times a node returned to its previous state shortly after changing",
+			},
+			[]string{"node"},
+		),
+
 		// Node count metrics
 		NodeCountFree: prometheus.NewGauge(
 			prometheus.GaugeOpts{
@@ -258,6 +542,52 @@ func NewRegistry() *Registry {
 			},
 		),
 
+		Up: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "pbs_up",
+				Help: "Whether the last run of every collector running PBS commands succeeded (1) or any failed (0)",
+			},
+		),
+
+		CommandErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_command_errors_total",
+				Help: "PBS commands that failed or timed out by command",
+			},
+			[]string{"command"},
+		),
+
+		CollectorDuration: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_collector_duration_seconds",
+				Help: "Time taken by the last run of each collector",
+			},
+			[]string{"collector"},
+		),
+
+		CollectorSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_collector_success",
+				Help: "Whether the last run of each collector succeeded (1) or failed (0)",
+			},
+			[]string{"collector"},
+		),
+
+		ServerScheduling: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "pbs_server_scheduling",
+				Help: "Whether the PBS server is scheduling jobs (1=True, 0=False) from qstat -B -f",
+			},
+		),
+
+		QueueOldestJobWait: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_queue_oldest_job_wait_seconds",
+				Help: "Time the oldest queued job in each queue has been waiting in seconds",
+			},
+			[]string{"queue"},
+		),
+
 		QueueSummaryRunning: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "qstatq_total_running",
@@ -280,54 +610,539 @@ func NewRegistry() *Registry {
 			[]string{"queue"},
 		),
 
-		registry: prometheus.NewRegistry(),
+		AccountingExitStatus: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_accounting_job_exit_status_total",
+				Help: "Ended jobs by Exit_status from the accounting log",
+			},
+			[]string{"queue", "exit_status"},
+		),
+
+		AccountingRecords: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_accounting_records_total",
+				Help: "Accounting log records read by record type",
+			},
+			[]string{"type"},
+		),
+
+		AccountingParseErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "pbs_accounting_parse_errors_total",
+				Help: "Accounting log lines that could not be parsed",
+			},
+		),
+
+		JobExits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_job_exits_total",
+				Help: "Finished jobs by exit class (success, nonzero, signal, walltime, memory, node_failure)",
+			},
+			[]string{"queue", "class"},
+		),
+
+		JobExitsByUser: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_job_exits_by_user_total",
+				Help: "Finished jobs by user and exit class",
+			},
+			[]string{"queue", "user", "class"},
+		),
+
+		HistoryJobs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_history_jobs_total",
+				Help: "Jobs that left the system by queue and final state (finished, moved), counted once per job",
+			},
+			[]string{"queue", "state"},
+		),
+
+		HistoryWaitSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "pbs_history_wait_seconds",
+				Help:    "Queue wait time (stime - qtime) of finished jobs in seconds",
+				Buckets: durationBuckets,
+			},
+			[]string{"queue"},
+		),
+
+		HistoryRunSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "pbs_history_run_seconds",
+				Help:    "Run time of finished jobs in seconds",
+				Buckets: durationBuckets,
+			},
+			[]string{"queue"},
+		),
+
+		HistoryWindowJobs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_history_window_jobs",
+				Help: "Jobs that left the system within the history window by queue and final state",
+			},
+			[]string{"queue", "state"},
+		),
+
+		HistoryWindowWait: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_history_window_wait_seconds",
+				Help: "Wait time of jobs finished within the history window (stat=mean, p50, p90, p99)",
+			},
+			[]string{"queue", "stat"},
+		),
+
+		HistoryWindowRun: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_history_window_run_seconds",
+				Help: "Run time of jobs finished within the history window (stat=mean, p50, p90, p99)",
+			},
+			[]string{"queue", "stat"},
+		),
+
+		Reservations: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservations",
+				Help: "Number of reservations by state",
+			},
+			[]string{"state"},
+		),
+
+		ReservationInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservation_info",
+				Help: "Reservation details (always 1) with name, owner, queue and type (advance, standing, maintenance)",
+			},
+			[]string{"reservation", "name", "owner", "queue", "type"},
+		),
+
+		ReservationState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservation_state",
+				Help: "Current reservation state (always 1), e.g. confirmed or running",
+			},
+			[]string{"reservation", "state"},
+		),
+
+		ReservationStart: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservation_start_timestamp_seconds",
+				Help: "Unix time the reservation (or the current occurrence of a standing reservation) starts",
+			},
+			[]string{"reservation"},
+		),
+
+		ReservationEnd: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservation_end_timestamp_seconds",
+				Help: "Unix time the reservation (or the current occurrence of a standing reservation) ends",
+			},
+			[]string{"reservation"},
+		),
+
+		ReservationNodes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservation_nodes",
+				Help: "Number of nodes assigned to the reservation",
+			},
+			[]string{"reservation"},
+		),
+
+		ReservationCpus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservation_cpus",
+				Help: "Number of CPUs reserved",
+			},
+			[]string{"reservation"},
+		),
+
+		ReservationGpus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_reservation_gpus",
+				Help: "Number of GPUs reserved",
+			},
+			[]string{"reservation"},
+		),
+
+		NodeInReservation: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_node_in_reservation",
+				Help: "Whether the node is held by an active (running) reservation (1) or not (0)",
+			},
+			[]string{"node"},
+		),
+
+		SchedCycleDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "pbs_sched_cycle_duration_seconds",
+				Help:    "Duration of scheduling cycles from the scheduler log in seconds",
+				Buckets: schedCycleBuckets,
+			},
+		),
+
+		SchedJobsConsidered: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "pbs_sched_cycle_jobs_considered",
+				Help:    "Jobs considered to run per scheduling cycle",
+				Buckets: schedJobBuckets,
+			},
+		),
+
+		SchedJobsRun: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "pbs_sched_cycle_jobs_run",
+				Help:    "Jobs run per scheduling cycle",
+				Buckets: schedJobBuckets,
+			},
+		),
+
+		SchedJobsNotRunning: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_sched_jobs_not_running_total",
+				Help: "Jobs the scheduler did not run, counted once per job and cycle, by reason (insufficient_resources, queue_limit, server_limit, reservation_conflict, other)",
+			},
+			[]string{"reason"},
+		),
+
+		SchedLogParseErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "pbs_sched_log_parse_errors_total",
+				Help: "Scheduler log lines that could not be parsed",
+			},
+		),
+
+		OutputWrites: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pbs_output_writes_total",
+				Help: "Metric set writes to output sinks by sink and result (success, error)",
+			},
+			[]string{"sink", "result"},
+		),
+
+		OutputLastSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pbs_output_last_success_timestamp_seconds",
+				Help: "Unix time of the last successful write to each output sink",
+			},
+			[]string{"sink"},
+		),
+
+		root:       root,
+		registry:   root.registry,
+		registerer: registerer,
 	}
+	r.AccountingJobEvents, r.AccountingCPUHours, r.AccountingGPUHours = accountingCounters(true)
 
-	// Register all metrics
-	r.registerMetrics()
+	// Register the metrics of the requested groups
+	r.registerMetrics(groups)
 
 	return r
 }
 
-// registerMetrics registers all metrics with the Prometheus registry
-func (r *Registry) registerMetrics() {
+// registerMetrics registers the collection status metrics and those of the given groups
+func (r *Registry) registerMetrics(groups []string) {
+	r.registerer.MustRegister(
+		r.Up,
+		r.CommandErrors,
+		r.CollectorDuration,
+		r.CollectorSuccess,
+	)
+	for _, group := range groups {
+		r.registerer.MustRegister(r.groupCollectors(group)...)
+	}
+}
+
+// groupCollectors returns the metrics a collector fills; history, accounting
+// and scheduler metrics are registered by their Enable methods instead
+func (r *Registry) groupCollectors(group string) []prometheus.Collector {
+	switch group {
+	case GroupJobs:
+		return []prometheus.Collector{
+			r.RunningJobsByUser,
+			r.RunningJobsByQueue,
+			r.JobsInQueue,
+			r.TotalRunningJobs,
+			r.TotalRJobs,
+			r.TotalHJobs,
+			r.TotalFJobs,
+			r.TotalQJobs,
+			r.TotalEJobs,
+			r.TotalBJobs,
+			r.TotalAllJobs,
+			r.JobsByStatus,
+			r.QueuedJobsByUser,
+			r.HeldJobsByUser,
+			r.UserAllocatedCpus,
+			r.UserAllocatedGpus,
+			r.UserAllocatedMemory,
+			r.UserRequestedCpus,
+			r.UserRequestedGpus,
+			r.UserRequestedMemory,
+			r.ArraySubjobs,
+			r.ArraySubjobsSubmitted,
+			r.JobsStarted,
+			r.JobsCompleted,
+			r.JobsRequeued,
+			r.QueueOldestJobWait,
+		}
+	case GroupNodes:
+		return []prometheus.Collector{
+			r.NodeState,
+			r.NodeJobs,
+			r.NodeCpusAvailable,
+			r.NodeCpusUsed,
+			r.NodeCpusTotal,
+			r.NodeGpusAvailable,
+			r.NodeGpusUsed,
+			r.NodeGpusTotal,
+			r.NodeMemoryAvailable,
+			r.NodeMemoryUsed,
+			r.NodeMemoryTotal,
+			r.NodeStateTransitions,
+			r.NodeFlaps,
+			r.NodeCountFree,
+			r.NodeCountBusy,
+			r.NodeCountOffline,
+			r.NodeCountDown,
+		}
+	case GroupQueues:
+		return []prometheus.Collector{
+			r.QueueSummaryRunning,
+			r.QueueSummaryQueued,
+			r.QueueQueuedByQueue,
+		}
+	case GroupServer:
+		return []prometheus.Collector{
+			r.ServerScheduling,
+		}
+	case GroupReservations:
+		return []prometheus.Collector{
+			r.Reservations,
+			r.ReservationInfo,
+			r.ReservationState,
+			r.ReservationStart,
+			r.ReservationEnd,
+			r.ReservationNodes,
+			r.ReservationCpus,
+			r.ReservationGpus,
+			r.NodeInReservation,
+		}
+	}
+	return nil
+}
+
+// EnableProjectMetrics registers the per-project aggregation metrics
+func (r *Registry) EnableProjectMetrics() {
+	r.registerer.MustRegister(r.ProjectUsage.collectors()...)
+}
+
+// EnableAccountMetrics registers the per-Account_Name aggregation metrics
+func (r *Registry) EnableAccountMetrics() {
+	r.registerer.MustRegister(r.AccountUsage.collectors()...)
+}
+
+// EnableGroupMetrics registers the per-egroup aggregation metrics
+func (r *Registry) EnableGroupMetrics() {
+	r.registerer.MustRegister(r.GroupUsage.collectors()...)
+}
+
+// EnableDepartmentMetrics registers the per-department aggregation metrics
+func (r *Registry) EnableDepartmentMetrics() {
+	r.registerer.MustRegister(r.DepartmentUsage.collectors()...)
+}
+
+// EnableAccountingMetrics registers the accounting log metrics
+func (r *Registry) EnableAccountingMetrics() {
+	r.registerOnce(r.registerer, r.JobExits)
+	r.registerer.MustRegister(
+		r.AccountingJobEvents,
+		r.AccountingExitStatus,
+		r.AccountingCPUHours,
+		r.AccountingGPUHours,
+		r.AccountingRecords,
+		r.AccountingParseErrors,
+	)
+}
+
+// EnableHistoryMetrics registers the `qstat -x` job history metrics
+func (r *Registry) EnableHistoryMetrics() {
+	r.registerOnce(r.registerer, r.JobExits)
+	r.registerer.MustRegister(
+		r.HistoryJobs,
+		r.HistoryWaitSeconds,
+		r.HistoryRunSeconds,
+		r.HistoryWindowJobs,
+		r.HistoryWindowWait,
+		r.HistoryWindowRun,
+	)
+}
+
+// EnableSchedulerMetrics registers the scheduler log metrics
+func (r *Registry) EnableSchedulerMetrics() {
+	r.registerer.MustRegister(
+		r.SchedCycleDuration,
+		r.SchedJobsConsidered,
+		r.SchedJobsRun,
+		r.SchedJobsNotRunning,
+		r.SchedLogParseErrors,
+	)
+}
+
+// EnableOutputMetrics registers the output sink metrics
+func (r *Registry) EnableOutputMetrics() {
+	r.registerOnce(r.registry, r.OutputWrites)
+	r.registerOnce(r.registry, r.OutputLastSuccess)
+}
+
+// registerOnce registers a collector shared by several optional metric groups
+func (r *Registry) registerOnce(reg prometheus.Registerer, c prometheus.Collector) {
+	if err := reg.Register(c); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			panic(err)
+		}
+	}
+}
+
+// EnableEventMetrics registers metrics describing the /events stream
+func (r *Registry) EnableEventMetrics(subscribers, published, dropped func() float64) {
+	r.registry.MustRegister(
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "pbs_events_subscribers",
+				Help: "Number of connected /events clients",
+			},
+			subscribers,
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "pbs_events_published_total",
+				Help: "Events published to /events clients",
+			},
+			published,
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "pbs_events_dropped_total",
+				Help: "Events dropped because a client's buffer was full",
+			},
+			dropped,
+		),
+	)
+}
+
+// EnableNotifierMetrics registers metrics describing webhook deliveries
+func (r *Registry) EnableNotifierMetrics(sent, failed, suppressed, dropped func() float64) {
 	r.registry.MustRegister(
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "pbs_webhook_notifications_sent_total",
+				Help: "Webhook notifications delivered",
+			},
+			sent,
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "pbs_webhook_notifications_failed_total",
+				Help: "Webhook notifications that failed after all retries",
+			},
+			failed,
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "pbs_webhook_notifications_suppressed_total",
+				Help: "Webhook notifications suppressed by deduplication or the rate limit",
+			},
+			suppressed,
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "pbs_webhook_notifications_dropped_total",
+				Help: "Webhook notifications dropped because deliveries to the target were falling behind",
+			},
+			dropped,
+		),
+	)
+}
+
+// EnableJobExitsByUser registers the per-user job exit counter
+func (r *Registry) EnableJobExitsByUser() {
+	r.registerer.MustRegister(r.JobExitsByUser)
+}
+
+// DropUserMetrics unregisters every metric that exists to break data down by user
+func (r *Registry) DropUserMetrics() {
+	for _, c := range []prometheus.Collector{
 		r.RunningJobsByUser,
-		r.RunningJobsByQueue,
-		r.JobsInQueue,
-		r.TotalRunningJobs,
-		r.TotalRJobs,
-		r.TotalHJobs,
-		r.TotalFJobs,
-		r.TotalQJobs,
-		r.TotalEJobs,
-		r.TotalBJobs,
-		r.TotalAllJobs,
-		r.JobsByStatus,
-		r.NodeState,
-		r.NodeJobs,
-		r.NodeCpusAvailable,
-		r.NodeCpusUsed,
-		r.NodeCpusTotal,
-		r.NodeGpusAvailable,
-		r.NodeGpusUsed,
-		r.NodeGpusTotal,
-		r.NodeMemoryAvailable,
-		r.NodeMemoryUsed,
-		r.NodeMemoryTotal,
-		r.NodeCountFree,
-		r.NodeCountBusy,
-		r.NodeCountOffline,
-		r.NodeCountDown,
-		r.QueueSummaryRunning,
-		r.QueueSummaryQueued,
-		r.QueueQueuedByQueue,
+		r.QueuedJobsByUser,
+		r.HeldJobsByUser,
+		r.UserAllocatedCpus,
+		r.UserAllocatedGpus,
+		r.UserAllocatedMemory,
+		r.UserRequestedCpus,
+		r.UserRequestedGpus,
+		r.UserRequestedMemory,
+		r.JobExitsByUser,
+		// Array jobs are labelled with their owner
+		r.ArraySubjobs,
+		r.ArraySubjobsSubmitted,
+	} {
+		r.registerer.Unregister(c)
+	}
+
+	// The accounting counters are kept without their user label; they are
+	// registered later, by EnableAccountingMetrics
+	r.AccountingJobEvents, r.AccountingCPUHours, r.AccountingGPUHours = accountingCounters(false)
+	r.usersDropped = true
+}
+
+// UsersDropped reports whether DropUserMetrics was called. The accounting
+// counters then take their label values without the user.
+func (r *Registry) UsersDropped() bool {
+	return r.usersDropped
+}
+
+// accountingCounters creates the accounting job event and CPU/GPU-hour
+// counters, broken down by user when byUser is set
+func accountingCounters(byUser bool) (events, cpuHours, gpuHours *prometheus.CounterVec) {
+	labels, eventLabels := []string{"queue"}, []string{"queue", "event"}
+	if byUser {
+		labels, eventLabels = []string{"queue", "user"}, []string{"queue", "user", "event"}
+	}
+	events = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pbs_accounting_job_events_total",
+			Help: "Job events from the accounting log (queued, started, completed, killed, aborted, deleted)",
+		},
+		eventLabels,
+	)
+	cpuHours = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pbs_accounting_cpu_hours_total",
+			Help: "CPU-hours (ncpus x walltime) consumed by ended jobs",
+		},
+		labels,
+	)
+	gpuHours = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pbs_accounting_gpu_hours_total",
+			Help: "GPU-hours (ngpus x walltime) consumed by ended jobs",
+		},
+		labels,
 	)
+	return events, cpuHours, gpuHours
+}
+
+// Gatherer gathers the metrics of every cluster without observing a
+// collector's update halfway, e.g. between a reset and the refill
+func (r *Registry) Gatherer() prometheus.Gatherer {
+	return r.root
 }
 
-// GetRegistry returns the underlying Prometheus registry
-func (r *Registry) GetRegistry() *prometheus.Registry {
-	return r.registry
+// Update runs f, which resets and refills metrics, so that Gatherer sees
+// either the old or the new values. Updates of different collectors may run concurrently.
+func (r *Registry) Update(f func()) {
+	r.root.mu.RLock()
+	defer r.root.mu.RUnlock()
+	f()
 }
 
 // ResetJobMetrics resets all job-related metrics
@@ -336,6 +1151,21 @@ func (r *Registry) ResetJobMetrics() {
 	r.RunningJobsByQueue.Reset()
 	r.JobsInQueue.Reset()
 	r.JobsByStatus.Reset()
+	r.QueuedJobsByUser.Reset()
+	r.HeldJobsByUser.Reset()
+	r.UserAllocatedCpus.Reset()
+	r.UserAllocatedGpus.Reset()
+	r.UserAllocatedMemory.Reset()
+	r.UserRequestedCpus.Reset()
+	r.UserRequestedGpus.Reset()
+	r.UserRequestedMemory.Reset()
+	r.ProjectUsage.Reset()
+	r.AccountUsage.Reset()
+	r.GroupUsage.Reset()
+	r.DepartmentUsage.Reset()
+	r.ArraySubjobs.Reset()
+	r.ArraySubjobsSubmitted.Reset()
+	r.QueueOldestJobWait.Reset()
 }
 
 // ResetNodeMetrics resets all node-related metrics
@@ -352,3 +1182,23 @@ func (r *Registry) ResetNodeMetrics() {
 	r.NodeMemoryUsed.Reset()
 	r.NodeMemoryTotal.Reset()
 }
+
+// ResetHistoryWindowMetrics resets the gauges describing the current history window
+func (r *Registry) ResetHistoryWindowMetrics() {
+	r.HistoryWindowJobs.Reset()
+	r.HistoryWindowWait.Reset()
+	r.HistoryWindowRun.Reset()
+}
+
+// ResetReservationMetrics resets the reservation gauges so ended reservations disappear
+func (r *Registry) ResetReservationMetrics() {
+	r.Reservations.Reset()
+	r.ReservationInfo.Reset()
+	r.ReservationState.Reset()
+	r.ReservationStart.Reset()
+	r.ReservationEnd.Reset()
+	r.ReservationNodes.Reset()
+	r.ReservationCpus.Reset()
+	r.ReservationGpus.Reset()
+	r.NodeInReservation.Reset()
+}
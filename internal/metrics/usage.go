@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// UsageGauges holds the job count and allocation gauges for one aggregation
+// dimension (project, account, group...)
+type UsageGauges struct {
+	RunningJobs     *prometheus.GaugeVec
+	QueuedJobs      *prometheus.GaugeVec
+	AllocatedCpus   *prometheus.GaugeVec
+	AllocatedGpus   *prometheus.GaugeVec
+	AllocatedMemory *prometheus.GaugeVec
+}
+
+// newUsageGauges creates the gauges for a dimension, e.g. prefix "pbs_project" and label "project"
+func newUsageGauges(prefix, label, desc string) *UsageGauges {
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prefix + "_" + name,
+				Help: help + " per " + desc,
+			},
+			[]string{label},
+		)
+	}
+	return &UsageGauges{
+		RunningJobs:     gauge("running_jobs", "Number of running jobs"),
+		QueuedJobs:      gauge("queued_jobs", "Number of queued jobs"),
+		AllocatedCpus:   gauge("allocated_ncpus", "CPUs allocated to running jobs"),
+		AllocatedGpus:   gauge("allocated_ngpus", "GPUs allocated to running jobs"),
+		AllocatedMemory: gauge("allocated_memory_gb", "Memory allocated to running jobs in GB"),
+	}
+}
+
+// Set updates all gauges for one value of the dimension
+func (u *UsageGauges) Set(value string, running, queued, cpus, gpus int, memoryGB float64) {
+	u.RunningJobs.WithLabelValues(value).Set(float64(running))
+	u.QueuedJobs.WithLabelValues(value).Set(float64(queued))
+	u.AllocatedCpus.WithLabelValues(value).Set(float64(cpus))
+	u.AllocatedGpus.WithLabelValues(value).Set(float64(gpus))
+	u.AllocatedMemory.WithLabelValues(value).Set(memoryGB)
+}
+
+// Reset clears all gauges
+func (u *UsageGauges) Reset() {
+	u.RunningJobs.Reset()
+	u.QueuedJobs.Reset()
+	u.AllocatedCpus.Reset()
+	u.AllocatedGpus.Reset()
+	u.AllocatedMemory.Reset()
+}
+
+// collectors returns the gauges for registration
+func (u *UsageGauges) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		u.RunningJobs,
+		u.QueuedJobs,
+		u.AllocatedCpus,
+		u.AllocatedGpus,
+		u.AllocatedMemory,
+	}
+}
@@ -0,0 +1,288 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pbs-exporter/internal/events"
+)
+
+// Payload formats understood by webhook receivers
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+	FormatTeams = "teams"
+)
+
+// Target is a webhook endpoint and the payload format it expects
+type Target struct {
+	URL    string
+	Format string
+}
+
+// ParseTarget parses "format=url" or a bare URL (generic JSON)
+func ParseTarget(s string) (Target, error) {
+	t := Target{URL: s, Format: FormatJSON}
+	if idx := strings.Index(s, "="); idx > 0 && !strings.Contains(s[:idx], "://") {
+		t.Format = strings.ToLower(s[:idx])
+		t.URL = s[idx+1:]
+	}
+	switch t.Format {
+	case FormatJSON, FormatSlack, FormatTeams:
+	default:
+		return Target{}, fmt.Errorf("unknown webhook format %q (expected json, slack or teams)", t.Format)
+	}
+	if !strings.HasPrefix(t.URL, "http://") && !strings.HasPrefix(t.URL, "https://") {
+		return Target{}, fmt.Errorf("webhook URL %q must be http or https", t.URL)
+	}
+	return t, nil
+}
+
+// Notifiable reports whether an event should trigger a notification:
+// nodes going down/offline, the server stopping scheduling and queues whose
+// oldest job exceeded the wait threshold
+func Notifiable(ev events.Event) bool {
+	switch ev.Type {
+	case events.TypeNodeStateChanged:
+		return strings.Contains(ev.To, "down") || strings.Contains(ev.To, "offline")
+	case events.TypeSchedulingStopped, events.TypeQueueWaitExceeded:
+		return true
+	}
+	return false
+}
+
+// Notifier POSTs notifiable events to webhook targets with retry, deduplication and rate limiting
+type Notifier struct {
+	targets []Target
+	client  *http.Client
+
+	// DedupWindow suppresses repeats of the same event (type, node, queue, state) within the window
+	DedupWindow time.Duration
+	// RatePerMinute caps notifications across all targets (0 means unlimited)
+	RatePerMinute int
+	// MaxRetries is the number of retries after a failed delivery
+	MaxRetries int
+	// Backoff is the delay before the first retry; it doubles on every further retry
+	Backoff time.Duration
+	// QueueSize is the number of notifications waiting for delivery per target before further ones are dropped
+	QueueSize int
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	tokens   float64
+	refilled time.Time
+
+	sent       atomic.Uint64
+	failed     atomic.Uint64
+	suppressed atomic.Uint64
+	dropped    atomic.Uint64
+}
+
+// New creates a notifier for the targets
+func New(targets []Target) *Notifier {
+	return &Notifier{
+		targets:       targets,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		DedupWindow:   30 * time.Minute,
+		RatePerMinute: 20,
+		MaxRetries:    3,
+		Backoff:       time.Second,
+		QueueSize:     100,
+		lastSent:      make(map[string]time.Time),
+	}
+}
+
+// Run delivers events from the subscription until ctx is cancelled or the subscription closes.
+// Each target is delivered to by its own worker, so a slow or failing target
+// holds up neither the subscription nor the other targets.
+func (n *Notifier) Run(ctx context.Context, sub *events.Subscription) {
+	queues := make([]chan events.Event, len(n.targets))
+	var wg sync.WaitGroup
+	for i, t := range n.targets {
+		queues[i] = make(chan events.Event, n.QueueSize)
+		wg.Add(1)
+		go func(t Target, queue <-chan events.Event) {
+			defer wg.Done()
+			for ev := range queue {
+				n.send(ctx, t, ev)
+			}
+		}(t, queues[i])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !Notifiable(ev) {
+				continue
+			}
+			if !n.allow(ev, time.Now()) {
+				n.suppressed.Add(1)
+				continue
+			}
+			for i, queue := range queues {
+				select {
+				case queue <- ev:
+				default:
+					n.dropped.Add(1)
+					log.Printf("Dropping webhook notification to %s: %d notifications already waiting", n.targets[i].URL, n.QueueSize)
+				}
+			}
+		}
+	}
+}
+
+// send delivers one event to a target and counts the outcome
+func (n *Notifier) send(ctx context.Context, t Target, ev events.Event) {
+	if err := n.deliver(ctx, t, ev); err != nil {
+		n.failed.Add(1)
+		log.Printf("Error sending webhook to %s: %v", t.URL, err)
+		return
+	}
+	n.sent.Add(1)
+}
+
+// allow applies deduplication and the rate limit
+func (n *Notifier) allow(ev events.Event, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := strings.Join([]string{ev.Cluster, ev.Type, ev.Node, ev.Queue, ev.To}, "|")
+	if last, ok := n.lastSent[key]; ok && now.Sub(last) < n.DedupWindow {
+		return false
+	}
+
+	if n.RatePerMinute > 0 {
+		// Token bucket refilled continuously up to one minute's worth
+		if n.refilled.IsZero() {
+			n.tokens = float64(n.RatePerMinute)
+		} else {
+			n.tokens += now.Sub(n.refilled).Minutes() * float64(n.RatePerMinute)
+			if n.tokens > float64(n.RatePerMinute) {
+				n.tokens = float64(n.RatePerMinute)
+			}
+		}
+		n.refilled = now
+		if n.tokens < 1 {
+			return false
+		}
+		n.tokens--
+	}
+
+	n.lastSent[key] = now
+	for k, t := range n.lastSent {
+		if now.Sub(t) >= n.DedupWindow {
+			delete(n.lastSent, k)
+		}
+	}
+	return true
+}
+
+// deliver POSTs one event to a target, retrying with exponential backoff
+func (n *Notifier) deliver(ctx context.Context, t Target, ev events.Event) error {
+	body, err := payload(t.Format, ev)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.Backoff
+	for attempt := 0; ; attempt++ {
+		err = n.post(ctx, t.URL, body)
+		var perm permanentError
+		if err == nil || attempt >= n.MaxRetries || errors.As(err, &perm) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a single request; 4xx responses other than 429 are not retried
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook returned %s", resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// permanentError marks a delivery failure that retrying will not fix
+type permanentError struct {
+	error
+}
+
+// payload renders an event in the target's format
+func payload(format string, ev events.Event) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": ev.Message})
+	case FormatTeams:
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  ev.Message,
+			"title":    "PBS " + strings.ReplaceAll(ev.Type, "_", " "),
+			"text":     ev.Message,
+		})
+	default:
+		return json.Marshal(ev)
+	}
+}
+
+// Sent returns the number of successful deliveries
+func (n *Notifier) Sent() uint64 {
+	return n.sent.Load()
+}
+
+// Failed returns the number of deliveries that failed after all retries
+func (n *Notifier) Failed() uint64 {
+	return n.failed.Load()
+}
+
+// Dropped returns the number of notifications dropped because a target's queue was full
+func (n *Notifier) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// Suppressed returns the number of notifications dropped by deduplication or the rate limit
+func (n *Notifier) Suppressed() uint64 {
+	return n.suppressed.Load()
+}
@@ -0,0 +1,312 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pbs-exporter/internal/events"
+)
+
+// receiver is a local stand-in for a webhook endpoint
+type receiver struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	bodies [][]byte
+	// status returns the response status for the nth request (starting at 1)
+	status func(n int) int
+	calls  atomic.Int32
+}
+
+func newReceiver(t *testing.T, status func(n int) int) *receiver {
+	t.Helper()
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := int(r.calls.Add(1))
+		if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+		if r.status != nil {
+			w.WriteHeader(r.status(n))
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]byte(nil), r.bodies...)
+}
+
+// run starts n on a new broker and returns it with a function stopping the notifier
+func run(t *testing.T, n *Notifier) (*events.Broker, func()) {
+	t.Helper()
+	broker := events.NewBroker(16)
+	sub := broker.Subscribe(events.Filter{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx, sub)
+		close(done)
+	}()
+	return broker, func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func nodeDown(node string) events.Event {
+	return events.Event{
+		Type:    events.TypeNodeStateChanged,
+		Node:    node,
+		From:    "free",
+		To:      "down",
+		Message: "node " + node + " changed from free to down",
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Target
+		wantErr bool
+	}{
+		{in: "https://hooks.example.org/pbs", want: Target{URL: "https://hooks.example.org/pbs", Format: FormatJSON}},
+		{in: "slack=https://hooks.slack.com/services/T/B/X", want: Target{URL: "https://hooks.slack.com/services/T/B/X", Format: FormatSlack}},
+		{in: "Teams=https://example.webhook.office.com/x?a=b", want: Target{URL: "https://example.webhook.office.com/x?a=b", Format: FormatTeams}},
+		{in: "https://hooks.example.org/pbs?token=a=b", want: Target{URL: "https://hooks.example.org/pbs?token=a=b", Format: FormatJSON}},
+		{in: "irc=https://example.org", wantErr: true},
+		{in: "ftp://example.org", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTarget(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPayloadFormats(t *testing.T) {
+	ev := nodeDown("cpu01")
+	tests := []struct {
+		format string
+		check  func(t *testing.T, body map[string]any)
+	}{
+		{FormatJSON, func(t *testing.T, body map[string]any) {
+			if body["type"] != events.TypeNodeStateChanged || body["node"] != "cpu01" || body["to"] != "down" {
+				t.Errorf("json payload = %v", body)
+			}
+		}},
+		{FormatSlack, func(t *testing.T, body map[string]any) {
+			if len(body) != 1 || body["text"] != ev.Message {
+				t.Errorf("slack payload = %v", body)
+			}
+		}},
+		{FormatTeams, func(t *testing.T, body map[string]any) {
+			if body["@type"] != "MessageCard" || body["text"] != ev.Message || body["title"] != "PBS node state changed" {
+				t.Errorf("teams payload = %v", body)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			recv := newReceiver(t, nil)
+			n := New([]Target{{URL: recv.URL, Format: tt.format}})
+			broker, stop := run(t, n)
+			broker.Publish(ev)
+			waitFor(t, "delivery", func() bool { return n.Sent() == 1 })
+			stop()
+
+			bodies := recv.received()
+			if len(bodies) != 1 {
+				t.Fatalf("received %d requests, want 1", len(bodies))
+			}
+			var body map[string]any
+			if err := json.Unmarshal(bodies[0], &body); err != nil {
+				t.Fatalf("invalid JSON payload %q: %v", bodies[0], err)
+			}
+			tt.check(t, body)
+		})
+	}
+}
+
+func TestNotifiable(t *testing.T) {
+	tests := []struct {
+		ev   events.Event
+		want bool
+	}{
+		{nodeDown("cpu01"), true},
+		{events.Event{Type: events.TypeNodeStateChanged, From: "free", To: "offline"}, true},
+		{events.Event{Type: events.TypeNodeStateChanged, From: "down", To: "free"}, false},
+		{events.Event{Type: events.TypeSchedulingStopped}, true},
+		{events.Event{Type: events.TypeQueueWaitExceeded}, true},
+		{events.Event{Type: events.TypeJobStarted}, false},
+	}
+	for _, tt := range tests {
+		if got := Notifiable(tt.ev); got != tt.want {
+			t.Errorf("Notifiable(%s %s->%s) = %v, want %v", tt.ev.Type, tt.ev.From, tt.ev.To, got, tt.want)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     func(n int) int
+		maxRetries int
+		wantCalls  int32
+		wantSent   uint64
+		wantFailed uint64
+	}{
+		{
+			name: "recovers after server errors",
+			status: func(n int) int {
+				if n < 3 {
+					return http.StatusBadGateway
+				}
+				return http.StatusOK
+			},
+			maxRetries: 3, wantCalls: 3, wantSent: 1,
+		},
+		{
+			name:       "gives up after max retries",
+			status:     func(int) int { return http.StatusServiceUnavailable },
+			maxRetries: 2, wantCalls: 3, wantFailed: 1,
+		},
+		{
+			name: "retries rate limiting",
+			status: func(n int) int {
+				if n == 1 {
+					return http.StatusTooManyRequests
+				}
+				return http.StatusNoContent
+			},
+			maxRetries: 3, wantCalls: 2, wantSent: 1,
+		},
+		{
+			name:       "does not retry client errors",
+			status:     func(int) int { return http.StatusNotFound },
+			maxRetries: 3, wantCalls: 1, wantFailed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recv := newReceiver(t, tt.status)
+			n := New([]Target{{URL: recv.URL, Format: FormatJSON}})
+			n.MaxRetries = tt.maxRetries
+			n.Backoff = time.Millisecond
+			broker, stop := run(t, n)
+			broker.Publish(nodeDown("cpu01"))
+			waitFor(t, "delivery outcome", func() bool { return n.Sent()+n.Failed() == 1 })
+			stop()
+
+			if got := recv.calls.Load(); got != tt.wantCalls {
+				t.Errorf("requests = %d, want %d", got, tt.wantCalls)
+			}
+			if n.Sent() != tt.wantSent || n.Failed() != tt.wantFailed {
+				t.Errorf("sent/failed = %d/%d, want %d/%d", n.Sent(), n.Failed(), tt.wantSent, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	recv := newReceiver(t, nil)
+	n := New([]Target{{URL: recv.URL, Format: FormatJSON}})
+	broker, stop := run(t, n)
+
+	// The repeat of cpu01 is suppressed; cpu02 and the other cluster's cpu01 are not
+	other := nodeDown("cpu01")
+	other.Cluster = "hpc2"
+	broker.Publish(nodeDown("cpu01"), nodeDown("cpu01"), nodeDown("cpu02"), other)
+	waitFor(t, "deliveries", func() bool { return n.Sent() == 3 && n.Suppressed() == 1 })
+	stop()
+
+	if got := len(recv.received()); got != 3 {
+		t.Errorf("received %d requests, want 3", got)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	n := New(nil)
+	n.DedupWindow = 10 * time.Minute
+	n.RatePerMinute = 2
+
+	steps := []struct {
+		node  string
+		at    time.Duration
+		allow bool
+	}{
+		{"cpu01", 0, true},
+		{"cpu02", 0, true},
+		{"cpu03", 0, false}, // rate limit: both tokens used
+		{"cpu03", 30 * time.Second, true},
+		{"cpu01", 5 * time.Minute, false}, // duplicate within the window
+		{"cpu01", 11 * time.Minute, true}, // the window has passed
+	}
+	for _, step := range steps {
+		if got := n.allow(nodeDown(step.node), start.Add(step.at)); got != step.allow {
+			t.Errorf("allow(%s at +%s) = %v, want %v", step.node, step.at, got, step.allow)
+		}
+	}
+}
+
+func TestSlowTargetDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var slowCalls atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls.Add(1)
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := newReceiver(t, nil)
+
+	n := New([]Target{{URL: slow.URL, Format: FormatJSON}, {URL: fast.URL, Format: FormatJSON}})
+	n.QueueSize = 1
+	n.RatePerMinute = 0
+	broker, stop := run(t, n)
+	defer stop()
+
+	// The slow target holds one delivery and queues one; the third is dropped
+	broker.Publish(nodeDown("cpu01"))
+	waitFor(t, "slow delivery", func() bool { return slowCalls.Load() == 1 })
+	broker.Publish(nodeDown("cpu02"), nodeDown("cpu03"))
+	waitFor(t, "fast deliveries", func() bool { return len(fast.received()) == 3 })
+	if got := n.Dropped(); got != 1 {
+		t.Errorf("dropped = %d, want 1", got)
+	}
+	if got := broker.Dropped(); got != 0 {
+		t.Errorf("broker dropped = %d, want 0", got)
+	}
+}
@@ -0,0 +1,171 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultGraphiteTemplate names a series after the metric only; labels are appended
+const DefaultGraphiteTemplate = "{__name__}"
+
+// GraphiteTemplate builds metric paths for the metrics whose name matches Match
+// (a glob such as "pbs_node_*"). Template is a dot-separated path in which
+// {label} is replaced by the label's value and {__name__} by the series name;
+// labels not used by the template are appended as ".label.value".
+type GraphiteTemplate struct {
+	Match    string
+	Template string
+}
+
+// ParseGraphiteTemplate parses "[glob=]template", e.g. "pbs_node_*=nodes.{node}.{__name__}"
+func ParseGraphiteTemplate(s string) (GraphiteTemplate, error) {
+	t := GraphiteTemplate{Match: "*", Template: s}
+	if idx := strings.Index(s, "="); idx >= 0 {
+		t.Match, t.Template = s[:idx], s[idx+1:]
+	}
+	if _, err := path.Match(t.Match, ""); err != nil {
+		return GraphiteTemplate{}, fmt.Errorf("invalid graphite template match %q: %w", t.Match, err)
+	}
+	if strings.TrimSpace(t.Template) == "" {
+		return GraphiteTemplate{}, fmt.Errorf("empty graphite template in %q", s)
+	}
+	return t, nil
+}
+
+// Graphite sends the metrics in the Graphite plaintext protocol over TCP
+type Graphite struct {
+	address   string
+	prefix    string
+	templates []GraphiteTemplate
+	tags      bool
+	timeout   time.Duration
+}
+
+// NewGraphite creates a sink for a carbon plaintext listener (host:port).
+// prefix is prepended to every path; with tags, labels not used by the
+// template are sent as Graphite tags (";label=value") instead of path segments.
+func NewGraphite(address, prefix string, templates []GraphiteTemplate, tags bool) *Graphite {
+	return &Graphite{
+		address:   address,
+		prefix:    strings.Trim(prefix, "."),
+		templates: templates,
+		tags:      tags,
+		timeout:   30 * time.Second,
+	}
+}
+
+// Name implements Sink
+func (g *Graphite) Name() string {
+	return "graphite"
+}
+
+// Write implements Sink
+func (g *Graphite) Write(families []*dto.MetricFamily) error {
+	conn, err := net.DialTimeout("tcp", g.address, g.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(g.timeout))
+
+	w := bufio.NewWriter(conn)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, mf := range families {
+		tmpl := g.template(mf.GetName())
+		for _, m := range mf.GetMetric() {
+			for _, s := range flatten(mf.GetName(), mf.GetType(), m) {
+				if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+					continue
+				}
+				labels := make(map[string]string, len(m.GetLabel())+len(s.labels))
+				for _, lp := range m.GetLabel() {
+					labels[lp.GetName()] = lp.GetValue()
+				}
+				for _, l := range s.labels {
+					labels[l.name] = l.value
+				}
+				fmt.Fprintf(w, "%s %s %s\n", g.path(tmpl, s.name, labels), strconv.FormatFloat(s.value, 'g', -1, 64), ts)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// template returns the first template matching the metric name
+func (g *Graphite) template(name string) string {
+	for _, t := range g.templates {
+		if ok, _ := path.Match(t.Match, name); ok {
+			return t.Template
+		}
+	}
+	return DefaultGraphiteTemplate
+}
+
+// placeholder matches {label} in a template
+var placeholder = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// path renders a series path from the template
+func (g *Graphite) path(tmpl, name string, labels map[string]string) string {
+	used := map[string]bool{}
+	p := placeholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		key := m[1 : len(m)-1]
+		if key == "__name__" {
+			return graphiteSegment(name)
+		}
+		used[key] = true
+		return graphiteSegment(labels[key])
+	})
+	if g.prefix != "" {
+		p = g.prefix + "." + p
+	}
+
+	rest := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if !used[k] && v != "" {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		if g.tags {
+			p += ";" + k + "=" + graphiteTagValue(labels[k])
+		} else {
+			p += "." + graphiteSegment(k) + "." + graphiteSegment(labels[k])
+		}
+	}
+	return p
+}
+
+// graphiteSegment makes a value safe as one path segment: dots, spaces and
+// other special characters become underscores, empty values become "none"
+func graphiteSegment(v string) string {
+	if v == "" {
+		return "none"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// graphiteTagValue removes the characters Graphite does not allow in tag values
+func graphiteTagValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ';' || r == '~' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, v)
+}
@@ -0,0 +1,175 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Influx writes the metrics in InfluxDB line protocol, either to the HTTP
+// write API or appended to a file. Every series becomes one line: the metric
+// name is the measurement, labels are tags and the sample is the "value" field.
+// Histograms are written as their _bucket/_sum/_count series.
+type Influx struct {
+	url       string
+	file      string
+	tags      map[string]string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewInfluxHTTP creates a sink for an InfluxDB write URL, e.g.
+// http://influx:8086/write?db=pbs (1.x) or http://influx:8086/api/v2/write?org=hpc&bucket=pbs (2.x).
+// tokenFile, if set, holds an API token sent as "Authorization: Token ...".
+func NewInfluxHTTP(url string, tags map[string]string, tokenFile string) *Influx {
+	return &Influx{
+		url:       url,
+		tags:      tags,
+		tokenFile: tokenFile,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NewInfluxFile creates a sink appending every collection to a file
+func NewInfluxFile(path string, tags map[string]string) *Influx {
+	return &Influx{file: path, tags: tags}
+}
+
+// Name implements Sink
+func (i *Influx) Name() string {
+	return "influxdb"
+}
+
+// Write implements Sink
+func (i *Influx) Write(families []*dto.MetricFamily) error {
+	var buf bytes.Buffer
+	writeLineProtocol(&buf, families, i.tags, time.Now())
+
+	if i.file != "" {
+		f, err := os.OpenFile(i.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, i.withPrecision(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.tokenFile != "" {
+		token, err := os.ReadFile(i.tokenFile)
+		if err != nil {
+			return fmt.Errorf("reading InfluxDB token: %w", err)
+		}
+		req.Header.Set("Authorization", "Token "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// withPrecision adds precision=ns to the write URL unless it sets one already
+func (i *Influx) withPrecision() string {
+	if strings.Contains(i.url, "precision=") {
+		return i.url
+	}
+	sep := "?"
+	if strings.Contains(i.url, "?") {
+		sep = "&"
+	}
+	return i.url + sep + "precision=ns"
+}
+
+// writeLineProtocol serializes the families, one line per series.
+// Non-finite values are skipped because line protocol cannot represent them.
+func writeLineProtocol(w io.Writer, families []*dto.MetricFamily, extra map[string]string, now time.Time) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			tags := make(map[string]string, len(m.GetLabel())+len(extra))
+			for k, v := range extra {
+				tags[k] = v
+			}
+			for _, lp := range m.GetLabel() {
+				tags[lp.GetName()] = lp.GetValue()
+			}
+
+			for _, s := range flatten(mf.GetName(), mf.GetType(), m) {
+				if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+					continue
+				}
+				for _, l := range s.labels {
+					tags[l.name] = l.value
+				}
+
+				bw.WriteString(influxEscape(s.name, ", "))
+				keys := make([]string, 0, len(tags))
+				for k := range tags {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					// Empty tag values are not allowed in line protocol
+					if tags[k] == "" {
+						continue
+					}
+					bw.WriteByte(',')
+					bw.WriteString(influxEscape(k, ",= "))
+					bw.WriteByte('=')
+					bw.WriteString(influxEscape(tags[k], ",= "))
+				}
+				bw.WriteString(" value=")
+				bw.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+				bw.WriteByte(' ')
+				bw.WriteString(ts)
+				bw.WriteByte('\n')
+
+				for _, l := range s.labels {
+					delete(tags, l.name)
+				}
+			}
+		}
+	}
+}
+
+// influxEscape backslash-escapes the given special characters
+func influxEscape(s, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
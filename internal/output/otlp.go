@@ -0,0 +1,216 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLP transports
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+// otlpTimeout bounds a single export, including the exporter's own retries
+const otlpTimeout = 30 * time.Second
+
+// OTLPConfig configures the OTLP sink. Unset fields fall back to the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+type OTLPConfig struct {
+	Protocol string
+	// Endpoint is "host:port" (TLS unless Insecure) or a URL; http:// URLs are insecure
+	Endpoint string
+	Insecure bool
+	Headers  map[string]string
+	// Cluster is exported as the pbs.cluster resource attribute when set
+	Cluster string
+	// Server returns the PBS server name for the pbs.server resource attribute ("" omits it)
+	Server func() string
+}
+
+// OTLP exports the metrics over OTLP/gRPC or OTLP/HTTP. Every registry metric
+// becomes one OTLP metric of the same name: gauges as gauges, counters as
+// cumulative monotonic sums and histograms as explicit-bucket histograms.
+type OTLP struct {
+	exporter sdkmetric.Exporter
+	cfg      OTLPConfig
+	start    time.Time
+	host     string
+}
+
+// NewOTLP creates the OTLP sink
+func NewOTLP(cfg OTLPConfig) (*OTLP, error) {
+	if cfg.Endpoint != "" && strings.Contains(cfg.Endpoint, "://") {
+		if u, err := url.Parse(cfg.Endpoint); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid OTLP endpoint URL %q", cfg.Endpoint)
+		}
+	}
+
+	var exporter sdkmetric.Exporter
+	var err error
+	ctx := context.Background()
+	switch cfg.Protocol {
+	case OTLPProtocolGRPC, "":
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithTimeout(otlpTimeout)}
+		if strings.Contains(cfg.Endpoint, "://") {
+			opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, opts...)
+	case OTLPProtocolHTTP:
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithTimeout(otlpTimeout)}
+		if strings.Contains(cfg.Endpoint, "://") {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(cfg.Endpoint))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q (expected grpc or http)", cfg.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	return &OTLP{exporter: exporter, cfg: cfg, start: time.Now(), host: host}, nil
+}
+
+// Name implements Sink
+func (o *OTLP) Name() string {
+	return "otlp"
+}
+
+// Write implements Sink
+func (o *OTLP) Write(families []*dto.MetricFamily) error {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	return o.exporter.Export(ctx, o.resourceMetrics(families, time.Now()))
+}
+
+// resourceMetrics converts the gathered families to OTLP metric data
+func (o *OTLP) resourceMetrics(families []*dto.MetricFamily, now time.Time) *metricdata.ResourceMetrics {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", "pbs-exporter"),
+	}
+	if o.host != "" {
+		attrs = append(attrs, attribute.String("service.instance.id", o.host))
+	}
+	if o.cfg.Cluster != "" {
+		attrs = append(attrs, attribute.String("pbs.cluster", o.cfg.Cluster))
+	}
+	if o.cfg.Server != nil {
+		if server := o.cfg.Server(); server != "" {
+			attrs = append(attrs, attribute.String("pbs.server", server))
+		}
+	}
+
+	metrics := make([]metricdata.Metrics, 0, len(families))
+	for _, mf := range families {
+		if data, ok := otlpAggregation(mf, o.start, now); ok {
+			metrics = append(metrics, metricdata.Metrics{
+				Name:        mf.GetName(),
+				Description: mf.GetHelp(),
+				Data:        data,
+			})
+		}
+	}
+
+	return &metricdata.ResourceMetrics{
+		Resource: resource.NewSchemaless(attrs...),
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   instrumentation.Scope{Name: "pbs-exporter"},
+			Metrics: metrics,
+		}},
+	}
+}
+
+// otlpAggregation converts one metric family; summaries have no OTLP
+// counterpart in the exporter and are skipped (the registry defines none)
+func otlpAggregation(mf *dto.MetricFamily, start, now time.Time) (metricdata.Aggregation, bool) {
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		for _, m := range mf.GetMetric() {
+			sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+				Attributes: otlpAttributes(m), StartTime: start, Time: now, Value: m.GetCounter().GetValue(),
+			})
+		}
+		return sum, true
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		gauge := metricdata.Gauge[float64]{}
+		for _, m := range mf.GetMetric() {
+			v := m.GetGauge().GetValue()
+			if mf.GetType() == dto.MetricType_UNTYPED {
+				v = m.GetUntyped().GetValue()
+			}
+			gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+				Attributes: otlpAttributes(m), Time: now, Value: v,
+			})
+		}
+		return gauge, true
+	case dto.MetricType_HISTOGRAM:
+		hist := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+		for _, m := range mf.GetMetric() {
+			h := m.GetHistogram()
+			dp := metricdata.HistogramDataPoint[float64]{
+				Attributes: otlpAttributes(m),
+				StartTime:  start,
+				Time:       now,
+				Count:      h.GetSampleCount(),
+				Sum:        h.GetSampleSum(),
+			}
+			// Prometheus buckets are cumulative, OTLP bucket counts are per bucket
+			// with a final overflow bucket above the last bound
+			var prev uint64
+			for _, b := range h.GetBucket() {
+				if math.IsInf(b.GetUpperBound(), +1) {
+					continue
+				}
+				dp.Bounds = append(dp.Bounds, b.GetUpperBound())
+				dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-prev)
+				prev = b.GetCumulativeCount()
+			}
+			dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-prev)
+			hist.DataPoints = append(hist.DataPoints, dp)
+		}
+		return hist, true
+	}
+	return nil, false
+}
+
+// otlpAttributes converts a metric's labels to attributes
+func otlpAttributes(m *dto.Metric) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		kvs = append(kvs, attribute.String(lp.GetName(), lp.GetValue()))
+	}
+	return attribute.NewSet(kvs...)
+}
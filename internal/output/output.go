@@ -0,0 +1,51 @@
+package output
+
+import (
+	"io"
+	"log"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"pbs-exporter/internal/metrics"
+)
+
+// Sink receives the full metric set after every collection
+type Sink interface {
+	// Name identifies the sink in logs and the pbs_output_* metrics
+	Name() string
+	// Write delivers the gathered metric families
+	Write(families []*dto.MetricFamily) error
+}
+
+// WriteAll gathers the registry once and writes the result to every sink,
+// recording the outcome in the registry's output metrics
+func WriteAll(registry *metrics.Registry, sinks []Sink) {
+	families, err := registry.Gatherer().Gather()
+	if err != nil {
+		// Gather returns whatever it could collect alongside the error
+		log.Printf("Error gathering metrics: %v", err)
+	}
+
+	for _, sink := range sinks {
+		if err := sink.Write(families); err != nil {
+			log.Printf("Error writing metrics to %s: %v", sink.Name(), err)
+			registry.OutputWrites.WithLabelValues(sink.Name(), "error").Inc()
+			continue
+		}
+		registry.OutputWrites.WithLabelValues(sink.Name(), "success").Inc()
+		registry.OutputLastSuccess.WithLabelValues(sink.Name()).Set(float64(time.Now().Unix()))
+	}
+}
+
+// WriteText writes metric families in the Prometheus text exposition format
+func WriteText(w io.Writer, families []*dto.MetricFamily) error {
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testFamilies returns a small metric set with a gauge vector, a counter and a histogram
+func testFamilies(t *testing.T) []*dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+
+	nodes := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "pbs_node_cpus_total", Help: "Total CPUs on node"}, []string{"node", "cluster"})
+	nodes.WithLabelValues("cpu01", "hpc1").Set(128)
+	nodes.WithLabelValues("gpu01", "hpc1").Set(64)
+
+	errs := prometheus.NewCounter(prometheus.CounterOpts{Name: "pbs_command_errors_total", Help: "Failed PBS commands"})
+	errs.Add(2)
+
+	cycles := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "pbs_sched_cycle_duration_seconds", Help: "Cycle durations", Buckets: []float64{1, 10}})
+	cycles.Observe(0.5)
+	cycles.Observe(4)
+
+	reg.MustRegister(nodes, errs, cycles)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
@@ -0,0 +1,46 @@
+package output
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Pushgateway pushes the metrics to a Prometheus Pushgateway. Every push
+// replaces the group's previous metrics, so series that disappear from a
+// collection also disappear from the gateway.
+type Pushgateway struct {
+	url      string
+	job      string
+	grouping map[string]string
+	client   *http.Client
+}
+
+// NewPushgateway creates a sink pushing to url under the job name and extra grouping labels
+func NewPushgateway(url, job string, grouping map[string]string) *Pushgateway {
+	return &Pushgateway{
+		url:      url,
+		job:      job,
+		grouping: grouping,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name implements Sink
+func (p *Pushgateway) Name() string {
+	return "pushgateway"
+}
+
+// Write implements Sink
+func (p *Pushgateway) Write(families []*dto.MetricFamily) error {
+	pusher := push.New(p.url, p.job).
+		Client(p.client).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil }))
+	for name, value := range p.grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	return pusher.Push()
+}
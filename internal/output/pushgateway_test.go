@@ -0,0 +1,70 @@
+package output
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPushgateway(t *testing.T) {
+	var (
+		method, path string
+		pushed       map[string]*dto.MetricFamily
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.EscapedPath()
+		pushed = make(map[string]*dto.MetricFamily)
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			mf := &dto.MetricFamily{}
+			if err := dec.Decode(mf); err != nil {
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("decoding pushed metrics: %v", err)
+				}
+				break
+			}
+			pushed[mf.GetName()] = mf
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := NewPushgateway(srv.URL, "pbs_exporter", map[string]string{"instance": "pbs01"})
+	if err := sink.Write(testFamilies(t)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// PUT replaces the whole group, so vanished series disappear from the gateway
+	if method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", method)
+	}
+	if want := "/metrics/job/pbs_exporter/instance/pbs01"; path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	nodes, ok := pushed["pbs_node_cpus_total"]
+	if !ok {
+		t.Fatalf("pbs_node_cpus_total not pushed; got %v", pushed)
+	}
+	if n := len(nodes.GetMetric()); n != 2 {
+		t.Errorf("pushed %d pbs_node_cpus_total series, want 2", n)
+	}
+	if h := pushed["pbs_sched_cycle_duration_seconds"].GetMetric()[0].GetHistogram(); h.GetSampleCount() != 2 || h.GetSampleSum() != 4.5 {
+		t.Errorf("pushed histogram count/sum = %d/%v, want 2/4.5", h.GetSampleCount(), h.GetSampleSum())
+	}
+}
+
+func TestPushgatewayError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if err := NewPushgateway(srv.URL, "pbs_exporter", nil).Write(testFamilies(t)); err == nil {
+		t.Error("Write succeeded against a failing gateway")
+	}
+}
@@ -0,0 +1,253 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWrite sends the metrics to a Prometheus remote-write endpoint
+// (snappy-compressed protobuf WriteRequest, protocol version 0.1.0)
+type RemoteWrite struct {
+	url       string
+	labels    map[string]string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewRemoteWrite creates a sink for url. labels are added to every series
+// (like external_labels); tokenFile, if set, holds a bearer token re-read on
+// every write so it can be rotated. Basic auth can be given in the URL.
+func NewRemoteWrite(url string, labels map[string]string, tokenFile string) *RemoteWrite {
+	return &RemoteWrite{
+		url:       url,
+		labels:    labels,
+		tokenFile: tokenFile,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name implements Sink
+func (r *RemoteWrite) Name() string {
+	return "remote_write"
+}
+
+// Write implements Sink
+func (r *RemoteWrite) Write(families []*dto.MetricFamily) error {
+	body := snappy.Encode(nil, encodeWriteRequest(families, r.labels, time.Now()))
+
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "pbs-exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if r.tokenFile != "" {
+		token, err := os.ReadFile(r.tokenFile)
+		if err != nil {
+			return fmt.Errorf("reading bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Field numbers from prometheus/prompb (remote.proto, types.proto)
+const (
+	writeRequestTimeseries = 1
+	writeRequestMetadata   = 3
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+
+	metadataType             = 1
+	metadataMetricFamilyName = 2
+	metadataHelp             = 4
+)
+
+// MetricMetadata.MetricType values
+const (
+	metadataUnknown   = 0
+	metadataCounter   = 1
+	metadataGauge     = 2
+	metadataHistogram = 3
+	metadataSummary   = 5
+)
+
+// label is a name/value pair of a series
+type label struct {
+	name, value string
+}
+
+// encodeWriteRequest encodes families as a prompb.WriteRequest with one sample
+// per series; histograms and summaries are split into their classic series
+func encodeWriteRequest(families []*dto.MetricFamily, extra map[string]string, now time.Time) []byte {
+	var buf []byte
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			// Like external labels, the extra labels never override the metric's own
+			base := make([]label, 0, len(m.GetLabel())+len(extra))
+			own := make(map[string]bool, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				base = append(base, label{lp.GetName(), lp.GetValue()})
+				own[lp.GetName()] = true
+			}
+			for k, v := range extra {
+				if !own[k] {
+					base = append(base, label{k, v})
+				}
+			}
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			for _, s := range flatten(name, mf.GetType(), m) {
+				labels := append(append([]label{{"__name__", s.name}}, base...), s.labels...)
+				buf = protowire.AppendTag(buf, writeRequestTimeseries, protowire.BytesType)
+				buf = protowire.AppendBytes(buf, encodeTimeSeries(labels, s.value, ts))
+			}
+		}
+	}
+
+	for _, mf := range families {
+		var md []byte
+		md = protowire.AppendTag(md, metadataType, protowire.VarintType)
+		md = protowire.AppendVarint(md, metadataTypeOf(mf.GetType()))
+		md = protowire.AppendTag(md, metadataMetricFamilyName, protowire.BytesType)
+		md = protowire.AppendString(md, mf.GetName())
+		md = protowire.AppendTag(md, metadataHelp, protowire.BytesType)
+		md = protowire.AppendString(md, mf.GetHelp())
+		buf = protowire.AppendTag(buf, writeRequestMetadata, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, md)
+	}
+	return buf
+}
+
+// encodeTimeSeries encodes a prompb.TimeSeries with sorted labels and one sample
+func encodeTimeSeries(labels []label, value float64, ts int64) []byte {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	var buf []byte
+	for _, l := range labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, labelName, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.name)
+		lb = protowire.AppendTag(lb, labelValue, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.value)
+		buf = protowire.AppendTag(buf, timeSeriesLabels, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, lb)
+	}
+
+	var sb []byte
+	sb = protowire.AppendTag(sb, sampleValue, protowire.Fixed64Type)
+	sb = protowire.AppendFixed64(sb, math.Float64bits(value))
+	sb = protowire.AppendTag(sb, sampleTimestamp, protowire.VarintType)
+	sb = protowire.AppendVarint(sb, uint64(ts))
+	buf = protowire.AppendTag(buf, timeSeriesSamples, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, sb)
+	return buf
+}
+
+// metadataTypeOf maps a family type to MetricMetadata.MetricType
+func metadataTypeOf(t dto.MetricType) uint64 {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return metadataCounter
+	case dto.MetricType_GAUGE:
+		return metadataGauge
+	case dto.MetricType_HISTOGRAM:
+		return metadataHistogram
+	case dto.MetricType_SUMMARY:
+		return metadataSummary
+	}
+	return metadataUnknown
+}
+
+// flatSample is one series of a metric after splitting histograms and summaries
+type flatSample struct {
+	name   string
+	labels []label
+	value  float64
+}
+
+// flatten returns the classic exposition series of a metric: the value itself,
+// or _bucket/_sum/_count for histograms and quantiles/_sum/_count for summaries
+func flatten(name string, t dto.MetricType, m *dto.Metric) []flatSample {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return []flatSample{{name: name, value: m.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []flatSample{{name: name, value: m.GetGauge().GetValue()}}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		samples := make([]flatSample, 0, len(h.GetBucket())+3)
+		for _, b := range h.GetBucket() {
+			// The +Inf bucket is implicit and added below from the sample count
+			if math.IsInf(b.GetUpperBound(), +1) {
+				continue
+			}
+			samples = append(samples, flatSample{
+				name:   name + "_bucket",
+				labels: []label{{"le", formatFloat(b.GetUpperBound())}},
+				value:  float64(b.GetCumulativeCount()),
+			})
+		}
+		return append(samples,
+			flatSample{name: name + "_bucket", labels: []label{{"le", "+Inf"}}, value: float64(h.GetSampleCount())},
+			flatSample{name: name + "_sum", value: h.GetSampleSum()},
+			flatSample{name: name + "_count", value: float64(h.GetSampleCount())})
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		samples := make([]flatSample, 0, len(s.GetQuantile())+2)
+		for _, q := range s.GetQuantile() {
+			samples = append(samples, flatSample{
+				name:   name,
+				labels: []label{{"quantile", formatFloat(q.GetQuantile())}},
+				value:  q.GetValue(),
+			})
+		}
+		return append(samples,
+			flatSample{name: name + "_sum", value: s.GetSampleSum()},
+			flatSample{name: name + "_count", value: float64(s.GetSampleCount())})
+	}
+	return []flatSample{{name: name, value: m.GetUntyped().GetValue()}}
+}
+
+// formatFloat formats le and quantile label values the way the text exposition does
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
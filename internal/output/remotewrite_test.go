@@ -0,0 +1,237 @@
+package output
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// series is a decoded prompb.TimeSeries with its single sample
+type series struct {
+	labels    map[string]string
+	names     []string // label names in encoded order
+	value     float64
+	timestamp int64
+}
+
+// writeRequest is a decoded prompb.WriteRequest
+type writeRequest struct {
+	series   []series
+	metadata map[string]uint64 // family name -> MetricMetadata.MetricType
+}
+
+// decodeWriteRequest decodes a WriteRequest independently of the encoder
+func decodeWriteRequest(t *testing.T, b []byte) writeRequest {
+	t.Helper()
+	req := writeRequest{metadata: make(map[string]uint64)}
+	fields(t, b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		switch num {
+		case writeRequestTimeseries:
+			req.series = append(req.series, decodeTimeSeries(t, v))
+		case writeRequestMetadata:
+			var name string
+			var mtype uint64
+			fields(t, v, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) {
+				switch num {
+				case metadataType:
+					mtype = n
+				case metadataMetricFamilyName:
+					name = string(v)
+				}
+			})
+			req.metadata[name] = mtype
+		}
+	})
+	return req
+}
+
+func decodeTimeSeries(t *testing.T, b []byte) series {
+	s := series{labels: make(map[string]string)}
+	fields(t, b, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+		switch num {
+		case timeSeriesLabels:
+			var name, value string
+			fields(t, v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+				if num == labelName {
+					name = string(v)
+				} else if num == labelValue {
+					value = string(v)
+				}
+			})
+			s.labels[name] = value
+			s.names = append(s.names, name)
+		case timeSeriesSamples:
+			fields(t, v, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) {
+				if num == sampleValue {
+					s.value = math.Float64frombits(n)
+				} else if num == sampleTimestamp {
+					s.timestamp = int64(n)
+				}
+			})
+		}
+	})
+	return s
+}
+
+// fields calls fn for every field of a protobuf message; bytes fields are
+// passed in v, varint and fixed64 fields in n
+func fields(t *testing.T, b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("invalid bytes field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, v, 0)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("invalid varint field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, nil, v)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				t.Fatalf("invalid fixed64 field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d for field %d", typ, num)
+		}
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var (
+		headers http.Header
+		body    []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/write" {
+			t.Errorf("request %s %s, want POST /api/v1/write", r.Method, r.URL.Path)
+		}
+		headers = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// cluster is the metric's own label and must not be overridden
+	sink := NewRemoteWrite(srv.URL+"/api/v1/write", map[string]string{"site": "dc1", "cluster": "other"}, tokenFile)
+	before := time.Now().UnixMilli()
+	if err := sink.Write(testFamilies(t)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"Authorization":                     "Bearer s3cret",
+	} {
+		if got := headers.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("body is not snappy-compressed: %v", err)
+	}
+	req := decodeWriteRequest(t, raw)
+
+	got := make(map[string]series)
+	for _, s := range req.series {
+		if !sort.StringsAreSorted(s.names) {
+			t.Errorf("labels of %v are not sorted: %v", s.labels, s.names)
+		}
+		if s.timestamp < before || s.timestamp > time.Now().UnixMilli() {
+			t.Errorf("timestamp of %v = %d, not the time of the write", s.labels, s.timestamp)
+		}
+		if s.labels["site"] != "dc1" {
+			t.Errorf("series %v is missing the site label", s.labels)
+		}
+		key := s.labels["__name__"]
+		for _, l := range []string{"node", "cluster", "le"} {
+			if v, ok := s.labels[l]; ok {
+				key += "," + l + "=" + v
+			}
+		}
+		got[key] = s
+	}
+
+	want := map[string]float64{
+		"pbs_node_cpus_total,node=cpu01,cluster=hpc1":                   128,
+		"pbs_node_cpus_total,node=gpu01,cluster=hpc1":                   64,
+		"pbs_command_errors_total,cluster=other":                        2,
+		"pbs_sched_cycle_duration_seconds_bucket,cluster=other,le=1":    1,
+		"pbs_sched_cycle_duration_seconds_bucket,cluster=other,le=10":   2,
+		"pbs_sched_cycle_duration_seconds_bucket,cluster=other,le=+Inf": 2,
+		"pbs_sched_cycle_duration_seconds_sum,cluster=other":            4.5,
+		"pbs_sched_cycle_duration_seconds_count,cluster=other":          2,
+	}
+	if len(got) != len(want) {
+		keys := make([]string, 0, len(got))
+		for k := range got {
+			keys = append(keys, k)
+		}
+		t.Errorf("got %d series, want %d:\n%s", len(got), len(want), strings.Join(keys, "\n"))
+	}
+	for key, value := range want {
+		s, ok := got[key]
+		if !ok {
+			t.Errorf("missing series %s", key)
+			continue
+		}
+		if s.value != value {
+			t.Errorf("%s = %v, want %v", key, s.value, value)
+		}
+	}
+
+	for name, mtype := range map[string]uint64{
+		"pbs_node_cpus_total":              metadataGauge,
+		"pbs_command_errors_total":         metadataCounter,
+		"pbs_sched_cycle_duration_seconds": metadataHistogram,
+	} {
+		if req.metadata[name] != mtype {
+			t.Errorf("metadata type of %s = %d, want %d", name, req.metadata[name], mtype)
+		}
+	}
+}
+
+func TestRemoteWriteError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	err := NewRemoteWrite(srv.URL, nil, "").Write(testFamilies(t))
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("Write error = %v, want the status and response body", err)
+	}
+}
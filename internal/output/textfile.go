@@ -0,0 +1,69 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Textfile writes the metrics for the node_exporter textfile collector.
+// The file is written under a temporary name and renamed into place, so
+// node_exporter never reads a partially written file.
+type Textfile struct {
+	path string
+}
+
+// NewTextfile creates a sink writing dir/name; name must end in .prom for node_exporter to read it
+func NewTextfile(dir, name string) (*Textfile, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	if filepath.Ext(name) != ".prom" {
+		return nil, fmt.Errorf("textfile name %q must end in .prom", name)
+	}
+	return &Textfile{path: filepath.Join(dir, name)}, nil
+}
+
+// Name implements Sink
+func (t *Textfile) Name() string {
+	return "textfile"
+}
+
+// Write implements Sink
+func (t *Textfile) Write(families []*dto.MetricFamily) error {
+	// The temporary name does not end in .prom, so node_exporter ignores it
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), "."+filepath.Base(t.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := WriteText(w, families); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
@@ -0,0 +1,82 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestTextfile(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewTextfile(dir, "pbs.prom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewriting replaces the previous file
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(testFamilies(t)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "pbs.prom" {
+		t.Errorf("directory holds %v, want only pbs.prom", entries)
+	}
+
+	path := filepath.Join(dir, "pbs.prom")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o644 {
+		t.Errorf("file mode = %v, want 0644", perm)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		t.Fatalf("textfile does not parse: %v", err)
+	}
+	for _, name := range []string{"pbs_node_cpus_total", "pbs_command_errors_total", "pbs_sched_cycle_duration_seconds"} {
+		if _, ok := families[name]; !ok {
+			t.Errorf("textfile is missing %s", name)
+		}
+	}
+	if got := families["pbs_command_errors_total"].GetMetric()[0].GetCounter().GetValue(); got != 2 {
+		t.Errorf("pbs_command_errors_total = %v, want 2", got)
+	}
+}
+
+func TestNewTextfile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir, name string
+		wantErr   bool
+	}{
+		{dir, "pbs.prom", false},
+		{dir, "pbs.txt", true},
+		{filepath.Join(dir, "missing"), "pbs.prom", true},
+		{file, "pbs.prom", true},
+	}
+	for _, tt := range tests {
+		if _, err := NewTextfile(tt.dir, tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("NewTextfile(%s, %s) error = %v, wantErr %v", tt.dir, tt.name, err, tt.wantErr)
+		}
+	}
+}
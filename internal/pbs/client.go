@@ -2,51 +2,92 @@ package pbs
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"log"
-	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// DefaultQueues lists the queues that are always exported, even when empty
+var DefaultQueues = []string{"interactive", "medium", "long", "large", "small", "special", "AISG_debug", "AISG_large", "AISG_guest"}
+
 // Client handles PBS command execution and data parsing
-type Client struct{}
+type Client struct {
+	// ArrayMode selects how array jobs are counted (defaults to ArrayModeSubjobs)
+	ArrayMode ArrayMode
+	// MapUser, when set, rewrites job owners before any aggregation (see internal/privacy)
+	MapUser func(user string) string
+	// Department, when set, resolves the real job owner to an organizational unit
+	Department func(user string) string
+	// Target selects the PBS server to query (defaults to the local default server)
+	Target Target
+	// Timeout bounds each command so a hung server cannot stall collection (0 disables)
+	Timeout time.Duration
+}
 
 // NewClient creates a new PBS client
 func NewClient() *Client {
-	return &Client{}
+	return &Client{ArrayMode: ArrayModeSubjobs}
 }
 
-// GetQstatOutput executes qstat -t and returns the output
-func (c *Client) GetQstatOutput() (string, error) {
-	cmd := exec.Command("qstat", "-t")
+// UserLabel returns the published form of a username, applying MapUser when set
+func (c *Client) UserLabel(user string) string {
+	if c.MapUser != nil {
+		return c.MapUser(user)
+	}
+	return user
+}
+
+// run executes a PBS command and returns its combined output
+func (c *Client) run(name string, args ...string) (string, error) {
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	cmd := c.Target.command(ctx, name, args...)
+	// Don't wait on children (e.g. of ssh) still holding the output pipe after a timeout
+	cmd.WaitDelay = time.Second
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Printf("Error running qstat -t: %v", err)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", c.Timeout)
+		}
+		// The command's own message (e.g. "Connection refused") is more useful than the exit status
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		if t := c.Target.String(); t != "" {
+			log.Printf("Error running %s %s on %s: %v", name, strings.Join(args, " "), t, err)
+		} else {
+			log.Printf("Error running %s %s: %v", name, strings.Join(args, " "), err)
+		}
 		return "", err
 	}
 	return string(output), nil
 }
 
+// GetQstatOutput executes qstat -t and returns the output
+func (c *Client) GetQstatOutput() (string, error) {
+	return c.run("qstat", "-t")
+}
+
 // GetPbsnodesOutput executes pbsnodes -aSj and returns the output
 func (c *Client) GetPbsnodesOutput() (string, error) {
-	cmd := exec.Command("pbsnodes", "-aSj")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error running pbsnodes -aSj: %v", err)
-		return "", err
-	}
-	return string(output), nil
+	return c.run("pbsnodes", "-aSj")
 }
 
 // GetQstatQOutput executes qstat -q and returns the output
 func (c *Client) GetQstatQOutput() (string, error) {
-	cmd := exec.Command("qstat", "-q")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error running qstat -q: %v", err)
-		return "", err
-	}
-	return string(output), nil
+	return c.run("qstat", "-q")
+}
+
+// GetQstatBOutput executes qstat -B -f and returns the output
+func (c *Client) GetQstatBOutput() (string, error) {
+	return c.run("qstat", "-B", "-f")
 }
 
 // JobData represents parsed job information
@@ -63,6 +104,23 @@ type JobData struct {
 	TotalB         int
 	TotalAll       int
 	TotalRunning   int
+
+	// UserUsage holds per-user running/queued/held counts and resource totals
+	UserUsage map[string]*Usage
+	// QueueUsage holds per-queue running/queued/held counts and resource totals
+	QueueUsage map[string]*Usage
+	// ProjectUsage, AccountUsage and GroupUsage aggregate by project, Account_Name and egroup
+	ProjectUsage map[string]*Usage
+	AccountUsage map[string]*Usage
+	GroupUsage   map[string]*Usage
+	// DepartmentUsage aggregates by the owner's department (only when a mapping is configured)
+	DepartmentUsage map[string]*Usage
+	// OldestQueued holds the qtime of the longest-waiting queued job per queue
+	OldestQueued map[string]time.Time
+	// Arrays holds per-array subjob progress keyed by parent job ID
+	Arrays map[string]*ArrayJob
+	// Jobs holds every job the data was built from
+	Jobs []Job
 }
 
 // NodeData represents parsed node information
@@ -88,19 +146,7 @@ type NodeInfo struct {
 
 // ParseQstatOutput parses qstat output and returns structured job data
 func (c *Client) ParseQstatOutput(output string) *JobData {
-	data := &JobData{
-		UserJobCount:   make(map[string]int),
-		QueueJobCount:  make(map[string]int),
-		QueueTotalCount: make(map[string]int),
-		StatusCount:    make(map[string]int),
-	}
-
-	// Initialize all queues with 0
-	queues := []string{"interactive", "medium", "long", "large", "small", "special", "AISG_debug", "AISG_large", "AISG_guest"}
-	for _, queue := range queues {
-		data.QueueJobCount[queue] = 0
-		data.QueueTotalCount[queue] = 0
-	}
+	var jobs []Job
 
 	scanner := bufio.NewScanner(strings.NewReader(output))
 	lineCount := 0
@@ -122,46 +168,18 @@ func (c *Client) ParseQstatOutput(output string) *JobData {
 		// Parse job line
 		fields := strings.Fields(line)
 		if len(fields) >= 6 {
-			user := fields[2]
-			status := fields[4]
-			queue := fields[5]
-
-			// Map status to descriptive name
-			statusDesc := mapStatusToDescription(status)
-
-			// Count by descriptive status
-			data.StatusCount[statusDesc]++
-
-			// Count total jobs in each queue
-			data.QueueTotalCount[queue]++
-
-			// Count totals by original status code
-			data.TotalAll++
-			switch status {
-			case "R":
-				data.TotalR++
-			case "H":
-				data.TotalH++
-			case "F":
-				data.TotalF++
-			case "Q":
-				data.TotalQ++
-			case "E":
-				data.TotalE++
-			case "B":
-				data.TotalB++
-			}
-
-			// Count running jobs by user and queue (check for original "R" status)
-			if status == "R" {
-				data.UserJobCount[user]++
-				data.QueueJobCount[queue]++
-				data.TotalRunning++
+			job := Job{
+				ID:    fields[0],
+				Owner: fields[2],
+				State: fields[4],
+				Queue: fields[5],
 			}
+			job.ArrayParent, job.IsArray = parseArrayID(job.ID)
+			jobs = append(jobs, job)
 		}
 	}
 
-	return data
+	return c.newJobData(jobs)
 }
 
 // ParseQstatQSummary parses `qstat -q` output and returns totals for running and queued jobs
@@ -245,6 +263,69 @@ func (c *Client) ParseQstatQPerQueue(output string) (runningByQueue map[string]i
 	return
 }
 
+// QueueState represents the enabled/started state of a queue
+type QueueState struct {
+	Enabled bool
+	Started bool
+}
+
+// ParseQstatQStates parses `qstat -q` output and returns each queue's State column ("E R", "D S"...)
+func (c *Client) ParseQstatQStates(output string) map[string]QueueState {
+	states := make(map[string]QueueState)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "server:") || strings.HasPrefix(strings.ToLower(line), "queue ") || strings.HasPrefix(line, "---") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		// State is the last two columns: E(nabled)/D(isabled) and R(unning)/S(topped)
+		enabled, started := fields[len(fields)-2], fields[len(fields)-1]
+		if (enabled != "E" && enabled != "D") || (started != "R" && started != "S") {
+			continue
+		}
+		states[fields[0]] = QueueState{Enabled: enabled == "E", Started: started == "R"}
+	}
+	return states
+}
+
+// ServerStatus represents the PBS server attributes from `qstat -B -f`
+type ServerStatus struct {
+	Name       string
+	State      string
+	Scheduling bool
+}
+
+// ParseQstatBOutput parses `qstat -B -f` output and returns the server status
+func (c *Client) ParseQstatBOutput(output string) *ServerStatus {
+	status := &ServerStatus{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Server:") {
+			status.Name = strings.TrimSpace(strings.TrimPrefix(line, "Server:"))
+			continue
+		}
+		idx := strings.Index(line, " = ")
+		if idx < 0 {
+			continue
+		}
+		switch line[:idx] {
+		case "server_state":
+			status.State = line[idx+3:]
+		case "scheduling":
+			status.Scheduling = strings.EqualFold(line[idx+3:], "True")
+		}
+	}
+
+	return status
+}
+
 // ParsePbsnodesOutput parses pbsnodes output and returns structured node data
 func (c *Client) ParsePbsnodesOutput(output string) *NodeData {
 	data := &NodeData{
@@ -343,9 +424,11 @@ func mapStatusToDescription(status string) string {
 	case "Q":
 		return "Queuing"
 	case "E":
-		return "Error"
+		return "Exiting"
 	case "B":
 		return "ArrayJobRunning"
+	case "X":
+		return "Expired"
 	default:
 		return status // Return original if unknown
 	}
@@ -375,6 +458,9 @@ func parseMemoryToGB(memStr string) float64 {
 	} else if strings.HasSuffix(memStr, "kb") {
 		multiplier = 0.000001
 		numStr = strings.TrimSuffix(memStr, "kb")
+	} else if strings.HasSuffix(memStr, "b") {
+		multiplier = 0.000000001
+		numStr = strings.TrimSuffix(memStr, "b")
 	} else {
 		// Assume GB if no unit
 		multiplier = 1
@@ -0,0 +1,40 @@
+package pbs
+
+// Exit status classes used by the job exit metrics
+const (
+	ExitClassSuccess     = "success"
+	ExitClassNonZero     = "nonzero"
+	ExitClassSignal      = "signal"
+	ExitClassWalltime    = "walltime"
+	ExitClassMemory      = "memory"
+	ExitClassNodeFailure = "node_failure"
+)
+
+// PBS job execution codes (negative Exit_status values) that have their own class
+const (
+	jobExecRerunSisterFail = -14 // JOB_EXEC_RERUN_ON_SIS_FAIL
+	jobExecRerunMSFail     = -20 // JOB_EXEC_RERUN_MS_FAIL
+	jobExecKillVmem        = -26 // JOB_EXEC_KILL_VMEM
+	jobExecKillMem         = -27 // JOB_EXEC_KILL_MEM
+	jobExecKillWalltime    = -29 // JOB_EXEC_KILL_WALLTIME
+)
+
+// ExitClass maps a PBS Exit_status to a coarse class:
+// 0 is success, values of 256 and above are 256 + signal number,
+// negative values are PBS execution codes (limits exceeded, node failures...)
+func ExitClass(code int) string {
+	switch {
+	case code == 0:
+		return ExitClassSuccess
+	case code >= 256:
+		return ExitClassSignal
+	case code == jobExecKillWalltime:
+		return ExitClassWalltime
+	case code == jobExecKillMem || code == jobExecKillVmem:
+		return ExitClassMemory
+	case code == jobExecRerunMSFail || code == jobExecRerunSisterFail:
+		return ExitClassNodeFailure
+	default:
+		return ExitClassNonZero
+	}
+}
@@ -0,0 +1,29 @@
+package pbs
+
+import "testing"
+
+func TestExitClass(t *testing.T) {
+	tests := []struct {
+		code int
+		want string
+	}{
+		{0, ExitClassSuccess},
+		{1, ExitClassNonZero},
+		{255, ExitClassNonZero},
+		{256, ExitClassSignal},
+		{265, ExitClassSignal}, // SIGKILL
+		{271, ExitClassSignal}, // SIGTERM
+		{-29, ExitClassWalltime},
+		{-27, ExitClassMemory},
+		{-26, ExitClassMemory},
+		{-20, ExitClassNodeFailure},
+		{-14, ExitClassNodeFailure},
+		{-1, ExitClassNonZero}, // JOB_EXEC_FAIL1
+		{-11, ExitClassNonZero},
+	}
+	for _, tt := range tests {
+		if got := ExitClass(tt.code); got != tt.want {
+			t.Errorf("ExitClass(%d) = %s, want %s", tt.code, got, tt.want)
+		}
+	}
+}
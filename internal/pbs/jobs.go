@@ -0,0 +1,623 @@
+package pbs
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArrayMode controls how array jobs are counted in the job totals
+type ArrayMode string
+
+const (
+	// ArrayModeSubjobs counts every subjob as a job and ignores the array parent
+	ArrayModeSubjobs ArrayMode = "subjobs"
+	// ArrayModeArrays counts each array once (via its parent) and ignores the subjobs
+	ArrayModeArrays ArrayMode = "arrays"
+)
+
+// ParseArrayMode converts a flag value to an ArrayMode
+func ParseArrayMode(s string) (ArrayMode, bool) {
+	switch ArrayMode(strings.ToLower(strings.TrimSpace(s))) {
+	case ArrayModeSubjobs, "":
+		return ArrayModeSubjobs, true
+	case ArrayModeArrays:
+		return ArrayModeArrays, true
+	}
+	return "", false
+}
+
+// Job represents a single job as reported by qstat
+type Job struct {
+	ID    string
+	Owner string
+	State string
+	Queue string
+
+	// ArrayParent is the parent ID (e.g. "1234[].server") for subjobs
+	ArrayParent string
+	// IsArray is true for the array parent itself
+	IsArray bool
+
+	// Department is the owner's organizational unit (empty unless a mapping is configured)
+	Department string
+
+	// Attributes holds the raw `qstat -f` attributes (empty for `qstat -t` output)
+	Attributes map[string]string
+}
+
+// IsSubjob reports whether the job is a subjob of an array
+func (j Job) IsSubjob() bool {
+	return j.ArrayParent != ""
+}
+
+// Attr returns a raw `qstat -f` attribute, or "" if it is not set
+func (j Job) Attr(name string) string {
+	return j.Attributes[name]
+}
+
+// Resource returns a requested resource from Resource_List, or "" if it is not set
+func (j Job) Resource(name string) string {
+	return j.Attributes["Resource_List."+name]
+}
+
+// NCPUs returns the number of CPUs requested by the job
+func (j Job) NCPUs() int {
+	n, _ := strconv.Atoi(j.Resource("ncpus"))
+	return n
+}
+
+// NGPUs returns the number of GPUs requested by the job
+func (j Job) NGPUs() int {
+	n, _ := strconv.Atoi(j.Resource("ngpus"))
+	return n
+}
+
+// MemoryGB returns the memory requested by the job in GB
+func (j Job) MemoryGB() float64 {
+	return parseMemoryToGB(j.Resource("mem"))
+}
+
+// qstatTimeLayout is the format of time attributes such as qtime and stime
+const qstatTimeLayout = "Mon Jan _2 15:04:05 2006"
+
+// Time parses a time attribute (qtime, stime, mtime, obittime...)
+func (j Job) Time(name string) (time.Time, bool) {
+	v := strings.TrimSpace(j.Attributes[name])
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(qstatTimeLayout, v, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// ExitStatus returns the job's Exit_status and whether it is known
+func (j Job) ExitStatus() (int, bool) {
+	v, ok := j.Attributes["Exit_status"]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Usage holds job counts and resource totals for one user (or other grouping)
+type Usage struct {
+	Running int
+	Queued  int
+	Held    int
+
+	// Resources allocated to running jobs
+	RunningNCPUs int
+	RunningNGPUs int
+	RunningMemGB float64
+
+	// Resources requested by queued jobs
+	QueuedNCPUs int
+	QueuedNGPUs int
+	QueuedMemGB float64
+}
+
+// add accounts a job in the usage totals. An array parent counted in arrays
+// mode is passed with its progress, a, and accounts for the resources of its
+// running and queued subjobs, each requesting the parent's Resource_List.
+func (u *Usage) add(job Job, a *ArrayJob) {
+	running, queued := 0, 0
+	switch {
+	case arrayProgressKnown(job, a):
+		running, queued = a.Running, a.Queued
+	case job.State == "R" || (job.IsArray && job.State == "B"):
+		running = 1
+	case job.State == "Q":
+		queued = 1
+	case job.State == "H":
+		u.Held++
+	}
+	if running > 0 {
+		u.Running++
+		u.RunningNCPUs += running * job.NCPUs()
+		u.RunningNGPUs += running * job.NGPUs()
+		u.RunningMemGB += float64(running) * job.MemoryGB()
+	}
+	if queued > 0 {
+		u.Queued++
+		u.QueuedNCPUs += queued * job.NCPUs()
+		u.QueuedNGPUs += queued * job.NGPUs()
+		u.QueuedMemGB += float64(queued) * job.MemoryGB()
+	}
+}
+
+// arrayProgressKnown reports whether a is the progress of the queued or
+// begun array parent job, with running or queued subjobs to account for
+func arrayProgressKnown(job Job, a *ArrayJob) bool {
+	return a != nil && (job.State == "B" || job.State == "Q") && a.Running+a.Queued > 0
+}
+
+// isRunning reports whether a job counts as running: an array parent in B
+// state stands for its running subjobs, when there are any
+func isRunning(job Job, a *ArrayJob) bool {
+	if arrayProgressKnown(job, a) {
+		return a.Running > 0
+	}
+	return job.State == "R" || (job.IsArray && job.State == "B")
+}
+
+// usageFor returns the usage entry for key, creating it if needed
+func usageFor(m map[string]*Usage, key string) *Usage {
+	u, ok := m[key]
+	if !ok {
+		u = &Usage{}
+		m[key] = u
+	}
+	return u
+}
+
+// ArrayJob represents the progress of an array job across its subjobs
+type ArrayJob struct {
+	ID       string
+	Owner    string
+	Queue    string
+	State    string
+	Total    int
+	Queued   int
+	Running  int
+	Held     int
+	Exiting  int
+	Finished int
+	Failed   int
+}
+
+// GetQstatFullOutput executes qstat -t -f and returns the output
+func (c *Client) GetQstatFullOutput() (string, error) {
+	return c.run("qstat", "-t", "-f")
+}
+
+// ParseQstatFullOutput parses `qstat -t -f` output and returns structured job data
+func (c *Client) ParseQstatFullOutput(output string) *JobData {
+	return c.newJobData(parseQstatFull(output))
+}
+
+// qselectTimeLayout is the [[CC]YY]MMDDhhmm[.SS] time format of qselect -t
+const qselectTimeLayout = "200601021504.05"
+
+// historyBatchSize bounds the job IDs passed to one qstat -x -f command
+const historyBatchSize = 500
+
+// GetFinishedJobIDs executes qselect -x -s FM -tm.gt.<since> and returns the
+// IDs of the finished and moved jobs last modified after since, so only those
+// have to be fetched in full. A job's mtime is at or after its end, so no job
+// that ended after since is missed; callers filter on the end time itself.
+// The time is given in the exporter's local time zone, which must match the server's.
+func (c *Client) GetFinishedJobIDs(since time.Time) ([]string, error) {
+	output, err := c.run("qselect", "-x", "-s", "FM", "-tm.gt."+since.Local().Format(qselectTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// GetQstatHistoryOutput executes qstat -x -f for the given jobs, in batches,
+// and returns the combined output, including finished and moved jobs
+func (c *Client) GetQstatHistoryOutput(ids []string) (string, error) {
+	var output strings.Builder
+	for start := 0; start < len(ids); start += historyBatchSize {
+		end := min(start+historyBatchSize, len(ids))
+		batch, err := c.run("qstat", append([]string{"-x", "-f"}, ids[start:end]...)...)
+		if err != nil {
+			return "", err
+		}
+		output.WriteString(batch)
+		output.WriteString("\n")
+	}
+	return output.String(), nil
+}
+
+// ParseQstatHistoryOutput parses `qstat -x -f` output into jobs
+func (c *Client) ParseQstatHistoryOutput(output string) []Job {
+	jobs := parseQstatFull(output)
+	c.prepareJobs(jobs)
+	return jobs
+}
+
+// parseQstatFull parses the `qstat -f` attribute listing into jobs
+func parseQstatFull(output string) []Job {
+	var jobs []Job
+	parseAttributeBlocks(output, "Job Id:", func(id string, attrs map[string]string) {
+		job := Job{ID: id, Attributes: attrs}
+		finishJob(&job)
+		jobs = append(jobs, job)
+	})
+	return jobs
+}
+
+// parseAttributeBlocks parses "name = value" listings such as `qstat -f` and
+// `pbs_rstat -f`, calling fn for each block started by a header line
+func parseAttributeBlocks(output, header string, fn func(id string, attrs map[string]string)) {
+	var id string
+	var attrs map[string]string
+	var lastKey string
+
+	flush := func() {
+		if attrs != nil {
+			fn(id, attrs)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if strings.HasPrefix(line, header) {
+			flush()
+			id = strings.TrimSpace(strings.TrimPrefix(line, header))
+			attrs = make(map[string]string)
+			lastKey = ""
+			continue
+		}
+		if attrs == nil || line == "" {
+			continue
+		}
+
+		// Long values are wrapped onto tab-indented continuation lines
+		if strings.HasPrefix(raw, "\t") && lastKey != "" {
+			attrs[lastKey] += line
+			continue
+		}
+
+		if idx := strings.Index(line, " = "); idx > 0 {
+			lastKey = line[:idx]
+			attrs[lastKey] = line[idx+3:]
+		}
+	}
+	flush()
+}
+
+// finishJob fills the typed job fields from the raw attributes
+func finishJob(j *Job) {
+	j.Owner = ownerName(j.Attributes["Job_Owner"])
+	j.State = j.Attributes["job_state"]
+	j.Queue = j.Attributes["queue"]
+	j.ArrayParent, j.IsArray = parseArrayID(j.ID)
+	if strings.EqualFold(j.Attributes["array"], "True") && !strings.Contains(j.ID, "[") {
+		j.IsArray = true
+	}
+}
+
+// ownerName strips the submit host from a Job_Owner value ("alice@login01" -> "alice")
+func ownerName(owner string) string {
+	if i := strings.Index(owner, "@"); i >= 0 {
+		return owner[:i]
+	}
+	return owner
+}
+
+// parseArrayID classifies a job ID: "1234[].srv" is an array parent,
+// "1234[7].srv" is a subjob whose parent is "1234[].srv"
+func parseArrayID(id string) (parent string, isArray bool) {
+	open := strings.Index(id, "[")
+	if open < 0 {
+		return "", false
+	}
+	end := strings.Index(id[open:], "]")
+	if end < 0 {
+		return "", false
+	}
+	end += open
+	if end == open+1 {
+		return "", true
+	}
+	return id[:open] + "[]" + id[end+1:], false
+}
+
+// countArrayIndices counts the indices in an array_indices_submitted value such as "1-100:2" or "0-9,20"
+func countArrayIndices(spec string) int {
+	total := 0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		step := 1
+		if i := strings.Index(part, ":"); i >= 0 {
+			if s, err := strconv.Atoi(part[i+1:]); err == nil && s > 0 {
+				step = s
+			}
+			part = part[:i]
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		if hi >= lo {
+			total += (hi-lo)/step + 1
+		}
+	}
+	return total
+}
+
+// applyArrayStateCount fills array progress from the parent's array_state_count,
+// e.g. "Queued:0 Running:2 Exiting:0 Expired:8"
+func applyArrayStateCount(a *ArrayJob, value string) {
+	for _, field := range strings.Fields(value) {
+		kv := strings.SplitN(field, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil {
+			continue
+		}
+		switch kv[0] {
+		case "Queued":
+			a.Queued = n
+		case "Running":
+			a.Running = n
+		case "Exiting":
+			a.Exiting = n
+		case "Expired":
+			a.Finished = n
+		}
+	}
+}
+
+// safeAttributes are the `qstat -f` attributes kept when usernames are mapped.
+// Everything else is dropped: owners, groups, paths, the environment and the
+// submit arguments can all name the real user.
+var safeAttributes = map[string]bool{
+	"job_state":               true,
+	"queue":                   true,
+	"server":                  true,
+	"project":                 true,
+	"Account_Name":            true,
+	"egroup":                  true,
+	"ctime":                   true,
+	"qtime":                   true,
+	"etime":                   true,
+	"stime":                   true,
+	"mtime":                   true,
+	"obittime":                true,
+	"Exit_status":             true,
+	"exec_host":               true,
+	"exec_vnode":              true,
+	"comment":                 true,
+	"Priority":                true,
+	"run_count":               true,
+	"substate":                true,
+	"Rerunable":               true,
+	"array":                   true,
+	"array_id":                true,
+	"array_index":             true,
+	"array_indices_submitted": true,
+	"array_indices_remaining": true,
+	"array_state_count":       true,
+}
+
+// safeAttributePrefixes select the resource attributes kept when usernames are mapped
+var safeAttributePrefixes = []string{"Resource_List.", "resources_used.", "estimated."}
+
+// isSafeAttribute reports whether an attribute may be published when usernames are mapped
+func isSafeAttribute(name string) bool {
+	if safeAttributes[name] {
+		return true
+	}
+	for _, prefix := range safeAttributePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareJobs resolves departments and applies the username mapping
+func (c *Client) prepareJobs(jobs []Job) {
+	// Departments are resolved from the real username, before any pseudonymization
+	if c.Department != nil {
+		for i := range jobs {
+			jobs[i].Department = c.Department(jobs[i].Owner)
+		}
+	}
+	if c.MapUser != nil {
+		for i := range jobs {
+			jobs[i].Owner = c.UserLabel(jobs[i].Owner)
+			for attr := range jobs[i].Attributes {
+				if !isSafeAttribute(attr) {
+					delete(jobs[i].Attributes, attr)
+				}
+			}
+		}
+	}
+}
+
+// newJobData aggregates jobs into JobData, honouring the client's array mode
+func (c *Client) newJobData(jobs []Job) *JobData {
+	c.prepareJobs(jobs)
+
+	data := &JobData{
+		UserJobCount:    make(map[string]int),
+		QueueJobCount:   make(map[string]int),
+		QueueTotalCount: make(map[string]int),
+		StatusCount:     make(map[string]int),
+		UserUsage:       make(map[string]*Usage),
+		QueueUsage:      make(map[string]*Usage),
+		ProjectUsage:    make(map[string]*Usage),
+		AccountUsage:    make(map[string]*Usage),
+		GroupUsage:      make(map[string]*Usage),
+		DepartmentUsage: make(map[string]*Usage),
+		OldestQueued:    make(map[string]time.Time),
+		Arrays:          make(map[string]*ArrayJob),
+		Jobs:            jobs,
+	}
+
+	// Initialize all queues with 0
+	for _, queue := range DefaultQueues {
+		data.QueueJobCount[queue] = 0
+		data.QueueTotalCount[queue] = 0
+	}
+
+	mode := c.ArrayMode
+	if mode == "" {
+		mode = ArrayModeSubjobs
+	}
+
+	// First pass: collect array parents so subjobs can be attributed to them
+	stateCounts := make(map[string]string)
+	for _, job := range jobs {
+		if job.IsArray {
+			a := &ArrayJob{ID: job.ID, Owner: job.Owner, Queue: job.Queue, State: job.State}
+			a.Total = countArrayIndices(job.Attr("array_indices_submitted"))
+			data.Arrays[job.ID] = a
+			stateCounts[job.ID] = job.Attr("array_state_count")
+		}
+	}
+
+	seenSubjobs := make(map[string]bool)
+	var parents []Job
+	for _, job := range jobs {
+		if job.IsSubjob() {
+			a, ok := data.Arrays[job.ArrayParent]
+			if !ok {
+				a = &ArrayJob{ID: job.ArrayParent, Owner: job.Owner, Queue: job.Queue}
+				data.Arrays[job.ArrayParent] = a
+			}
+			seenSubjobs[job.ArrayParent] = true
+			switch job.State {
+			case "Q", "W", "T":
+				a.Queued++
+			case "R", "S", "U":
+				a.Running++
+			case "H":
+				a.Held++
+			case "E":
+				a.Exiting++
+			case "X", "F":
+				if code, ok := job.ExitStatus(); ok && code != 0 {
+					a.Failed++
+				} else {
+					a.Finished++
+				}
+			}
+		}
+
+		// The B parent and its subjobs describe the same work; count only one side
+		if job.IsArray {
+			if job.State == "B" {
+				data.TotalB++
+			}
+			// Parents are counted once their subjobs have been tallied
+			if mode == ArrayModeArrays {
+				parents = append(parents, job)
+			}
+			continue
+		}
+		if job.IsSubjob() && mode == ArrayModeArrays {
+			continue
+		}
+
+		countJob(data, job, nil)
+	}
+
+	// Parents listed without their subjobs (no -t) still carry the per-state counts
+	for id, a := range data.Arrays {
+		if !seenSubjobs[id] {
+			applyArrayStateCount(a, stateCounts[id])
+		}
+		if seen := a.Queued + a.Running + a.Held + a.Exiting + a.Finished + a.Failed; a.Total < seen {
+			a.Total = seen
+		}
+	}
+
+	for _, job := range parents {
+		countJob(data, job, data.Arrays[job.ID])
+	}
+
+	return data
+}
+
+// countJob adds a single job to the status, queue and user totals. a is the
+// progress of an array parent counted in arrays mode, nil for other jobs.
+func countJob(data *JobData, job Job, a *ArrayJob) {
+	// Map status to descriptive name
+	data.StatusCount[mapStatusToDescription(job.State)]++
+
+	// Count total jobs in each queue
+	data.QueueTotalCount[job.Queue]++
+
+	// Count totals by original status code
+	data.TotalAll++
+	switch job.State {
+	case "R":
+		data.TotalR++
+	case "H":
+		data.TotalH++
+	case "F":
+		data.TotalF++
+	case "Q":
+		data.TotalQ++
+		if qtime, ok := job.Time("qtime"); ok {
+			if oldest, seen := data.OldestQueued[job.Queue]; !seen || qtime.Before(oldest) {
+				data.OldestQueued[job.Queue] = qtime
+			}
+		}
+	case "E":
+		data.TotalE++
+	}
+
+	if isRunning(job, a) {
+		data.UserJobCount[job.Owner]++
+		data.QueueJobCount[job.Queue]++
+		data.TotalRunning++
+	}
+
+	usageFor(data.UserUsage, job.Owner).add(job, a)
+	usageFor(data.QueueUsage, job.Queue).add(job, a)
+	usageFor(data.ProjectUsage, attrOrNone(job, "project")).add(job, a)
+	usageFor(data.AccountUsage, attrOrNone(job, "Account_Name")).add(job, a)
+	usageFor(data.GroupUsage, attrOrNone(job, "egroup")).add(job, a)
+	if job.Department != "" {
+		usageFor(data.DepartmentUsage, job.Department).add(job, a)
+	}
+}
+
+// attrOrNone returns an attribute used as an aggregation key, or "none" when it is unset
+func attrOrNone(job Job, name string) string {
+	if v := strings.TrimSpace(job.Attr(name)); v != "" {
+		return v
+	}
+	return "none"
+}
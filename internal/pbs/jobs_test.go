@@ -0,0 +1,321 @@
+package pbs
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// arrayQstat is `qstat -t -f` output with an array of four 8-CPU subjobs, two
+// running, one queued and one finished, and a plain 4-CPU running job
+const arrayQstat = `Job Id: 100[].pbs01
+    Job_Owner = alice@login01
+    job_state = B
+    queue = long
+    array = True
+    array_indices_submitted = 1-4
+    array_state_count = Queued:1 Running:2 Exiting:0 Expired:1
+    Resource_List.ncpus = 8
+    Resource_List.mem = 16gb
+
+Job Id: 100[1].pbs01
+    Job_Owner = alice@login01
+    job_state = X
+    queue = long
+    Exit_status = 0
+    Resource_List.ncpus = 8
+    Resource_List.mem = 16gb
+
+Job Id: 100[2].pbs01
+    Job_Owner = alice@login01
+    job_state = R
+    queue = long
+    Resource_List.ncpus = 8
+    Resource_List.mem = 16gb
+
+Job Id: 100[3].pbs01
+    Job_Owner = alice@login01
+    job_state = R
+    queue = long
+    Resource_List.ncpus = 8
+    Resource_List.mem = 16gb
+
+Job Id: 100[4].pbs01
+    Job_Owner = alice@login01
+    job_state = Q
+    queue = long
+    Resource_List.ncpus = 8
+    Resource_List.mem = 16gb
+
+Job Id: 101.pbs01
+    Job_Owner = alice@login01
+    job_state = R
+    queue = long
+    Resource_List.ncpus = 4
+    Resource_List.mem = 4gb
+`
+
+// arrayParentsOnly is `qstat -f` output without -t, where only the parent's
+// array_state_count tells the subjobs apart
+const arrayParentsOnly = `Job Id: 200[].pbs01
+    Job_Owner = bob@login01
+    job_state = B
+    queue = small
+    array = True
+    array_indices_submitted = 0-9
+    array_state_count = Queued:6 Running:3 Exiting:0 Expired:1
+    Resource_List.ncpus = 2
+    Resource_List.ngpus = 1
+
+Job Id: 201[].pbs01
+    Job_Owner = bob@login01
+    job_state = Q
+    queue = small
+    array = True
+    array_indices_submitted = 1-5
+    array_state_count = Queued:5 Running:0 Exiting:0 Expired:0
+    Resource_List.ncpus = 2
+    Resource_List.ngpus = 1
+`
+
+func TestArrayModes(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		mode         ArrayMode
+		user         string
+		want         Usage
+		totalRunning int
+	}{
+		{
+			name:   "subjobs mode counts every subjob",
+			output: arrayQstat,
+			mode:   ArrayModeSubjobs,
+			user:   "alice",
+			want: Usage{
+				Running: 3, Queued: 1,
+				RunningNCPUs: 20, RunningMemGB: 36,
+				QueuedNCPUs: 8, QueuedMemGB: 16,
+			},
+			totalRunning: 3,
+		},
+		{
+			name:   "arrays mode takes the progress from the subjobs",
+			output: arrayQstat,
+			mode:   ArrayModeArrays,
+			user:   "alice",
+			want: Usage{
+				Running: 2, Queued: 1,
+				RunningNCPUs: 20, RunningMemGB: 36,
+				QueuedNCPUs: 8, QueuedMemGB: 16,
+			},
+			totalRunning: 2,
+		},
+		{
+			name:   "arrays mode takes the progress from array_state_count",
+			output: arrayParentsOnly,
+			mode:   ArrayModeArrays,
+			user:   "bob",
+			want: Usage{
+				Running: 1, Queued: 2,
+				RunningNCPUs: 6, RunningNGPUs: 3,
+				QueuedNCPUs: 22, QueuedNGPUs: 11,
+			},
+			totalRunning: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient()
+			c.ArrayMode = tt.mode
+			data := c.ParseQstatFullOutput(tt.output)
+
+			got := data.UserUsage[tt.user]
+			if got == nil {
+				t.Fatalf("no usage for %s", tt.user)
+			}
+			if *got != tt.want {
+				t.Errorf("usage = %+v, want %+v", *got, tt.want)
+			}
+			if data.TotalRunning != tt.totalRunning {
+				t.Errorf("TotalRunning = %d, want %d", data.TotalRunning, tt.totalRunning)
+			}
+		})
+	}
+}
+
+func TestParseQstatFull(t *testing.T) {
+	output := "Job Id: 1234.pbs01\n" +
+		"    Job_Name = analysis\n" +
+		"    Job_Owner = alice@login01.example.org\n" +
+		"    job_state = R\n" +
+		"    queue = long\n" +
+		"    project = climate\n" +
+		"    qtime = Sun Oct 18 08:00:00 2026\n" +
+		"    stime = Sun Oct  4 09:30:00 2026\n" +
+		"    exec_vnode = (cpu01:ncpus=32:mem=64gb)+(cpu02:ncpus=32:mem=6\n" +
+		"\t4gb)\n" +
+		"    Resource_List.ncpus = 64\n" +
+		"    Resource_List.ngpus = 2\n" +
+		"    Resource_List.mem = 128gb\n" +
+		"    Variable_List = PBS_O_HOME=/home/alice,PBS_O_LOGNAME=alice,\n" +
+		"\tPBS_O_WORKDIR=/home/alice/run\n" +
+		"    Exit_status = 0\n" +
+		"\n" +
+		"Job Id: 1235[3].pbs01\n" +
+		"    Job_Owner = bob@login01\n" +
+		"    job_state = Q\n" +
+		"    queue = small\n"
+
+	jobs := parseQstatFull(output)
+	if len(jobs) != 2 {
+		t.Fatalf("parsed %d jobs, want 2", len(jobs))
+	}
+
+	job := jobs[0]
+	if job.ID != "1234.pbs01" || job.Owner != "alice" || job.State != "R" || job.Queue != "long" {
+		t.Errorf("job = %s %s %s %s", job.ID, job.Owner, job.State, job.Queue)
+	}
+	if got, want := job.Attr("exec_vnode"), "(cpu01:ncpus=32:mem=64gb)+(cpu02:ncpus=32:mem=64gb)"; got != want {
+		t.Errorf("wrapped exec_vnode = %q, want %q", got, want)
+	}
+	if got, want := job.Attr("Variable_List"), "PBS_O_HOME=/home/alice,PBS_O_LOGNAME=alice,PBS_O_WORKDIR=/home/alice/run"; got != want {
+		t.Errorf("wrapped Variable_List = %q, want %q", got, want)
+	}
+	if job.NCPUs() != 64 || job.NGPUs() != 2 || job.MemoryGB() != 128 {
+		t.Errorf("resources = %d CPUs, %d GPUs, %v GB", job.NCPUs(), job.NGPUs(), job.MemoryGB())
+	}
+	if qtime, ok := job.Time("qtime"); !ok || !qtime.Equal(time.Date(2026, 10, 18, 8, 0, 0, 0, time.Local)) {
+		t.Errorf("qtime = %s, %v", qtime, ok)
+	}
+	if stime, ok := job.Time("stime"); !ok || !stime.Equal(time.Date(2026, 10, 4, 9, 30, 0, 0, time.Local)) {
+		t.Errorf("stime with a padded day = %s, %v", stime, ok)
+	}
+	if _, ok := job.Time("obittime"); ok {
+		t.Error("unset obittime parsed")
+	}
+	if code, ok := job.ExitStatus(); !ok || code != 0 {
+		t.Errorf("ExitStatus() = %d, %v", code, ok)
+	}
+
+	sub := jobs[1]
+	if !sub.IsSubjob() || sub.ArrayParent != "1235[].pbs01" || sub.IsArray {
+		t.Errorf("subjob parent = %q, array = %v", sub.ArrayParent, sub.IsArray)
+	}
+	if _, ok := sub.ExitStatus(); ok {
+		t.Error("unset Exit_status reported")
+	}
+}
+
+func TestParseArrayID(t *testing.T) {
+	tests := []struct {
+		id      string
+		parent  string
+		isArray bool
+	}{
+		{"1234.pbs01", "", false},
+		{"1234[].pbs01", "", true},
+		{"1234[7].pbs01", "1234[].pbs01", false},
+		{"1234[7", "", false},
+	}
+	for _, tt := range tests {
+		parent, isArray := parseArrayID(tt.id)
+		if parent != tt.parent || isArray != tt.isArray {
+			t.Errorf("parseArrayID(%q) = %q, %v, want %q, %v", tt.id, parent, isArray, tt.parent, tt.isArray)
+		}
+	}
+}
+
+func TestCountArrayIndices(t *testing.T) {
+	tests := map[string]int{
+		"1-100":      100,
+		"0-9,20":     11,
+		"1-100:2":    50,
+		"1-10:3,15":  5,
+		"5":          1,
+		"":           0,
+		"a-b,3":      1,
+		"10-1":       0,
+		" 1-3 , 7 ,": 4,
+	}
+	for spec, want := range tests {
+		if got := countArrayIndices(spec); got != want {
+			t.Errorf("countArrayIndices(%q) = %d, want %d", spec, got, want)
+		}
+	}
+}
+
+func TestMappedUsersKeepOnlySafeAttributes(t *testing.T) {
+	output := `Job Id: 1.pbs01
+    Job_Owner = alice@login01
+    job_state = R
+    queue = long
+    egroup = physics
+    euser = alice
+    User_List = alice
+    group_list = alice
+    Submit_arguments = -N alice-run job.sh
+    Shell_Path_List = /home/alice/bin/bash
+    Output_Path = login01:/home/alice/out
+    Variable_List = PBS_O_HOME=/home/alice
+    Resource_List.ncpus = 4
+    resources_used.cput = 00:10:00
+    estimated.start_time = Sun Oct 18 09:00:00 2026
+`
+	c := NewClient()
+	c.MapUser = func(string) string { return "user-1" }
+	data := c.ParseQstatFullOutput(output)
+	if len(data.Jobs) != 1 {
+		t.Fatalf("parsed %d jobs, want 1", len(data.Jobs))
+	}
+
+	job := data.Jobs[0]
+	if job.Owner != "user-1" {
+		t.Errorf("owner = %q, want user-1", job.Owner)
+	}
+	var kept []string
+	for name := range job.Attributes {
+		kept = append(kept, name)
+	}
+	sort.Strings(kept)
+	want := []string{"Resource_List.ncpus", "egroup", "estimated.start_time", "job_state", "queue", "resources_used.cput"}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept attributes = %v, want %v", kept, want)
+	}
+}
+
+func TestUsageByState(t *testing.T) {
+	job := func(state string) Job {
+		return Job{ID: "1.pbs01", State: state, Attributes: map[string]string{
+			"Resource_List.ncpus": "4",
+			"Resource_List.ngpus": "1",
+			"Resource_List.mem":   "8gb",
+		}}
+	}
+	tests := []struct {
+		states []string
+		want   Usage
+	}{
+		{[]string{"R", "R"}, Usage{Running: 2, RunningNCPUs: 8, RunningNGPUs: 2, RunningMemGB: 16}},
+		{[]string{"Q"}, Usage{Queued: 1, QueuedNCPUs: 4, QueuedNGPUs: 1, QueuedMemGB: 8}},
+		// Held jobs are counted but their resources are not demand
+		{[]string{"H", "H"}, Usage{Held: 2}},
+		{[]string{"R", "Q", "H"}, Usage{
+			Running: 1, Queued: 1, Held: 1,
+			RunningNCPUs: 4, RunningNGPUs: 1, RunningMemGB: 8,
+			QueuedNCPUs: 4, QueuedNGPUs: 1, QueuedMemGB: 8,
+		}},
+		// Waiting, exiting and suspended jobs are neither running nor queued
+		{[]string{"W", "E", "S"}, Usage{}},
+	}
+	for _, tt := range tests {
+		var u Usage
+		for _, state := range tt.states {
+			u.add(job(state), nil)
+		}
+		if u != tt.want {
+			t.Errorf("usage of %v = %+v, want %+v", tt.states, u, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package pbs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reservation types
+const (
+	ReservationAdvance     = "advance"
+	ReservationStanding    = "standing"
+	ReservationMaintenance = "maintenance"
+)
+
+// reservationStates names the numeric reserve_state values some PBS versions print
+var reservationStates = map[string]string{
+	"0":  "none",
+	"1":  "unconfirmed",
+	"2":  "confirmed",
+	"3":  "wait",
+	"4":  "time_to_run",
+	"5":  "running",
+	"6":  "finished",
+	"7":  "being_deleted",
+	"8":  "deleted",
+	"9":  "deleting_jobs",
+	"10": "degraded",
+	"11": "being_altered",
+	"12": "in_conflict",
+}
+
+// Reservation represents an advance, standing or maintenance reservation as reported by `pbs_rstat -f`
+type Reservation struct {
+	ID    string
+	Name  string
+	Owner string
+	Queue string
+	Type  string
+	// State is reserve_state without the RESV_ prefix, in lower case (e.g. "confirmed", "running")
+	State string
+	Start time.Time
+	End   time.Time
+	// Nodes are the vnodes assigned to the reservation, from resv_nodes
+	Nodes []string
+	NCPUs int
+	NGPUs int
+
+	// Attributes holds the raw `pbs_rstat -f` attributes
+	Attributes map[string]string
+}
+
+// Active reports whether the reservation currently holds its nodes
+func (r Reservation) Active() bool {
+	switch r.State {
+	case "running", "time_to_run", "deleting_jobs", "degraded":
+		return true
+	}
+	return false
+}
+
+// GetRstatOutput executes pbs_rstat -f and returns the output
+func (c *Client) GetRstatOutput() (string, error) {
+	return c.run("pbs_rstat", "-f")
+}
+
+// ParseRstatOutput parses `pbs_rstat -f` output into reservations
+func (c *Client) ParseRstatOutput(output string) []Reservation {
+	var resvs []Reservation
+	parseAttributeBlocks(output, "Resv ID:", func(id string, attrs map[string]string) {
+		resvs = append(resvs, c.newReservation(id, attrs))
+	})
+	return resvs
+}
+
+// newReservation fills the typed reservation fields from the raw attributes
+func (c *Client) newReservation(id string, attrs map[string]string) Reservation {
+	r := Reservation{
+		ID:         id,
+		Name:       attrs["Reserve_Name"],
+		Owner:      c.UserLabel(ownerName(attrs["Reserve_Owner"])),
+		Queue:      attrs["queue"],
+		State:      reservationState(attrs["reserve_state"]),
+		Attributes: attrs,
+	}
+
+	// IDs are R123 (advance), S123 (standing) and M123 (maintenance)
+	switch {
+	case strings.HasPrefix(id, "M"):
+		r.Type = ReservationMaintenance
+	case strings.HasPrefix(id, "S") || attrs["reserve_rrule"] != "":
+		r.Type = ReservationStanding
+	default:
+		r.Type = ReservationAdvance
+	}
+
+	if t, err := time.ParseInLocation(qstatTimeLayout, strings.TrimSpace(attrs["reserve_start"]), time.Local); err == nil {
+		r.Start = t
+	}
+	if t, err := time.ParseInLocation(qstatTimeLayout, strings.TrimSpace(attrs["reserve_end"]), time.Local); err == nil {
+		r.End = t
+	}
+
+	// resv_nodes is "(cpu01:ncpus=64:ngpus=4)+(cpu02:ncpus=64)"
+	seen := make(map[string]bool)
+	var chunkCPUs, chunkGPUs int
+	for _, chunk := range strings.Split(attrs["resv_nodes"], "+") {
+		parts := strings.Split(strings.Trim(strings.TrimSpace(chunk), "()"), ":")
+		if parts[0] == "" {
+			continue
+		}
+		if !seen[parts[0]] {
+			seen[parts[0]] = true
+			r.Nodes = append(r.Nodes, parts[0])
+		}
+		for _, res := range parts[1:] {
+			name, value, _ := strings.Cut(res, "=")
+			n, _ := strconv.Atoi(value)
+			switch name {
+			case "ncpus":
+				chunkCPUs += n
+			case "ngpus":
+				chunkGPUs += n
+			}
+		}
+	}
+
+	// Resource_List holds the request; the chunks are the fallback when it is missing
+	var err error
+	if r.NCPUs, err = strconv.Atoi(attrs["Resource_List.ncpus"]); err != nil {
+		r.NCPUs = chunkCPUs
+	}
+	if r.NGPUs, err = strconv.Atoi(attrs["Resource_List.ngpus"]); err != nil {
+		r.NGPUs = chunkGPUs
+	}
+
+	return r
+}
+
+// reservationState normalizes reserve_state ("RESV_CONFIRMED" or "2") to "confirmed"
+func reservationState(v string) string {
+	if fields := strings.Fields(v); len(fields) > 0 {
+		v = fields[0]
+	}
+	if name, ok := reservationStates[v]; ok {
+		return name
+	}
+	return strings.ToLower(strings.TrimPrefix(v, "RESV_"))
+}
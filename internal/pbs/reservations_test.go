@@ -0,0 +1,129 @@
+package pbs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rstatOutput = `Resv ID: R123.pbs01
+Reserve_Name = maint-gpu
+Reserve_Owner = alice@login01
+reserve_state = RESV_CONFIRMED
+reserve_start = Mon Oct 19 08:00:00 2026
+reserve_end = Mon Oct 19 20:00:00 2026
+reserve_duration = 43200
+queue = R123
+Resource_List.ncpus = 128
+Resource_List.ngpus = 8
+resv_nodes = (gpu01:ncpus=64:ngpus=4)+(gpu02:ncpus=64:ngpus=4)
+
+Resv ID: S200.pbs01
+Reserve_Name = weekly
+Reserve_Owner = bob@login01
+reserve_state = 5
+reserve_rrule = FREQ=WEEKLY;COUNT=10
+reserve_start = Sun Oct 18 06:00:00 2026
+reserve_end = Sun Oct 18 18:00:00 2026
+queue = S200
+resv_nodes = (cpu01:ncpus=32)+(cpu01:ncpus=32)+(cpu02:ncpus=
+	64)
+
+Resv ID: M7.pbs01
+Reserve_Owner = root@pbs01
+reserve_state = RESV_DEGRADED 10
+queue = M7
+resv_nodes = (cpu03:ncpus=64)
+`
+
+func TestParseRstatOutput(t *testing.T) {
+	local := func(s string) time.Time {
+		ts, err := time.ParseInLocation(qstatTimeLayout, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	tests := []struct {
+		id     string
+		name   string
+		owner  string
+		queue  string
+		typ    string
+		state  string
+		active bool
+		start  time.Time
+		end    time.Time
+		nodes  []string
+		ncpus  int
+		ngpus  int
+	}{
+		{
+			id: "R123.pbs01", name: "maint-gpu", owner: "alice", queue: "R123",
+			typ: ReservationAdvance, state: "confirmed",
+			start: local("Mon Oct 19 08:00:00 2026"), end: local("Mon Oct 19 20:00:00 2026"),
+			nodes: []string{"gpu01", "gpu02"}, ncpus: 128, ngpus: 8,
+		},
+		{
+			// Numeric state, continuation lines, nodes repeated across chunks, resources from the chunks
+			id: "S200.pbs01", name: "weekly", owner: "bob", queue: "S200",
+			typ: ReservationStanding, state: "running", active: true,
+			start: local("Sun Oct 18 06:00:00 2026"), end: local("Sun Oct 18 18:00:00 2026"),
+			nodes: []string{"cpu01", "cpu02"}, ncpus: 128,
+		},
+		{
+			id: "M7.pbs01", owner: "root", queue: "M7",
+			typ: ReservationMaintenance, state: "degraded", active: true,
+			nodes: []string{"cpu03"}, ncpus: 64,
+		},
+	}
+
+	resvs := NewClient().ParseRstatOutput(rstatOutput)
+	if len(resvs) != len(tests) {
+		t.Fatalf("parsed %d reservations, want %d", len(resvs), len(tests))
+	}
+	for i, tt := range tests {
+		r := resvs[i]
+		t.Run(tt.id, func(t *testing.T) {
+			if r.ID != tt.id || r.Name != tt.name || r.Owner != tt.owner || r.Queue != tt.queue {
+				t.Errorf("id/name/owner/queue = %s/%s/%s/%s, want %s/%s/%s/%s",
+					r.ID, r.Name, r.Owner, r.Queue, tt.id, tt.name, tt.owner, tt.queue)
+			}
+			if r.Type != tt.typ || r.State != tt.state || r.Active() != tt.active {
+				t.Errorf("type/state/active = %s/%s/%v, want %s/%s/%v", r.Type, r.State, r.Active(), tt.typ, tt.state, tt.active)
+			}
+			if !r.Start.Equal(tt.start) || !r.End.Equal(tt.end) {
+				t.Errorf("window = %s - %s, want %s - %s", r.Start, r.End, tt.start, tt.end)
+			}
+			if !reflect.DeepEqual(r.Nodes, tt.nodes) || r.NCPUs != tt.ncpus || r.NGPUs != tt.ngpus {
+				t.Errorf("nodes/cpus/gpus = %v/%d/%d, want %v/%d/%d", r.Nodes, r.NCPUs, r.NGPUs, tt.nodes, tt.ncpus, tt.ngpus)
+			}
+		})
+	}
+}
+
+func TestParseRstatOutputMapsOwners(t *testing.T) {
+	c := NewClient()
+	c.MapUser = strings.ToUpper
+	resvs := c.ParseRstatOutput(rstatOutput)
+	if len(resvs) == 0 || resvs[0].Owner != "ALICE" {
+		t.Errorf("owner = %+v, want the mapped name ALICE", resvs)
+	}
+}
+
+func TestReservationState(t *testing.T) {
+	tests := map[string]string{
+		"RESV_CONFIRMED":   "confirmed",
+		"RESV_RUNNING":     "running",
+		"RESV_DEGRADED 10": "degraded",
+		"2":                "confirmed",
+		"9":                "deleting_jobs",
+		"":                 "",
+	}
+	for in, want := range tests {
+		if got := reservationState(in); got != want {
+			t.Errorf("reservationState(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package pbs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Target selects the PBS server the commands are run against
+type Target struct {
+	// Server is the PBS server name passed in PBS_SERVER (empty uses the default server)
+	Server string
+	// SSHHost, when set, runs the commands on this [user@]host over ssh
+	SSHHost string
+}
+
+// ParseTarget parses a target given as a server name, or as
+// ssh://[user@]host[:port][/server] to run the commands on a remote host
+func ParseTarget(s string) (Target, error) {
+	if !strings.Contains(s, "://") {
+		if strings.ContainsAny(s, " /") || strings.HasPrefix(s, "-") {
+			return Target{}, fmt.Errorf("invalid PBS server name %q", s)
+		}
+		return Target{Server: s}, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return Target{}, err
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return Target{}, fmt.Errorf("expected a server name or ssh://[user@]host[/server], got %q", s)
+	}
+	host := u.Host
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	// ssh would read a destination starting with "-" as an option
+	if strings.HasPrefix(host, "-") {
+		return Target{}, fmt.Errorf("invalid ssh destination %q", host)
+	}
+	return Target{Server: strings.Trim(u.Path, "/"), SSHHost: host}, nil
+}
+
+// String returns the target in the form accepted by ParseTarget
+func (t Target) String() string {
+	if t.SSHHost == "" {
+		return t.Server
+	}
+	s := "ssh://" + t.SSHHost
+	if t.Server != "" {
+		s += "/" + t.Server
+	}
+	return s
+}
+
+// command builds the command running name against the target
+func (t Target) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if t.SSHHost == "" {
+		cmd := exec.CommandContext(ctx, name, args...)
+		if t.Server != "" {
+			cmd.Env = append(os.Environ(), "PBS_SERVER="+t.Server)
+		}
+		return cmd
+	}
+
+	// ssh joins its arguments into one remote shell command line, so quote each word
+	words := make([]string, 0, len(args)+2)
+	if t.Server != "" {
+		words = append(words, "PBS_SERVER="+shellQuote(t.Server))
+	}
+	words = append(words, shellQuote(name))
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+
+	host, port := t.SSHHost, ""
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		host, port = host[:i], host[i+1:]
+	}
+	sshArgs := []string{"-o", "BatchMode=yes"}
+	if port != "" {
+		sshArgs = append(sshArgs, "-p", port)
+	}
+	sshArgs = append(sshArgs, strings.Trim(host, "[]"), "--", strings.Join(words, " "))
+	return exec.CommandContext(ctx, "ssh", sshArgs...)
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:@,+", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,93 @@
+package pbs
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Target
+		wantErr string
+	}{
+		{in: "", want: Target{}},
+		{in: "pbs01", want: Target{Server: "pbs01"}},
+		{in: "ssh://login01", want: Target{SSHHost: "login01"}},
+		{in: "ssh://admin@login01:2222/pbs01", want: Target{Server: "pbs01", SSHHost: "admin@login01:2222"}},
+		{in: "ssh://[::1]/pbs01", want: Target{Server: "pbs01", SSHHost: "[::1]"}},
+		{in: "pbs 01", wantErr: "invalid PBS server name"},
+		{in: "-oProxyCommand=x", wantErr: "invalid PBS server name"},
+		{in: "http://login01", wantErr: "expected a server name or ssh://"},
+		{in: "ssh:///pbs01", wantErr: "expected a server name or ssh://"},
+		{in: "ssh://-oProxyCommand=x@login01", wantErr: "invalid ssh destination"},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseTarget(%q) error = %v, want it to contain %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+			continue
+		}
+		// String gives back an equivalent target
+		if again, err := ParseTarget(got.String()); err != nil || again != got {
+			t.Errorf("ParseTarget(%q) = %+v, %v; want %+v", got.String(), again, err, got)
+		}
+	}
+}
+
+func TestTargetCommand(t *testing.T) {
+	tests := []struct {
+		target Target
+		args   []string
+		env    string
+	}{
+		{Target{}, []string{"qstat", "-f", "1.pbs01"}, ""},
+		{Target{Server: "pbs01"}, []string{"qstat", "-f", "1.pbs01"}, "PBS_SERVER=pbs01"},
+		{Target{SSHHost: "admin@login01"}, []string{"ssh", "-o", "BatchMode=yes", "admin@login01", "--", "qstat -f 1.pbs01"}, ""},
+		{
+			Target{Server: "pbs 01", SSHHost: "login01:2222"},
+			[]string{"ssh", "-o", "BatchMode=yes", "-p", "2222", "login01", "--", "PBS_SERVER='pbs 01' qstat -f 1.pbs01"},
+			"",
+		},
+		{Target{SSHHost: "[::1]:22"}, []string{"ssh", "-o", "BatchMode=yes", "-p", "22", "::1", "--", "qstat -f 1.pbs01"}, ""},
+	}
+	for _, tt := range tests {
+		cmd := tt.target.command(context.Background(), "qstat", "-f", "1.pbs01")
+		if !reflect.DeepEqual(cmd.Args, tt.args) {
+			t.Errorf("%+v: args = %q, want %q", tt.target, cmd.Args, tt.args)
+		}
+		hasEnv := false
+		for _, kv := range cmd.Env {
+			hasEnv = hasEnv || kv == tt.env
+		}
+		if tt.env != "" && !hasEnv {
+			t.Errorf("%+v: environment lacks %s", tt.target, tt.env)
+		}
+		if tt.env == "" && cmd.Env != nil {
+			t.Errorf("%+v: environment is set", tt.target)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"":                    "''",
+		"-tm.gt.202610180900": "-tm.gt.202610180900",
+		"a b":                 "'a b'",
+		"it's":                `'it'\''s'`,
+		"$(reboot)":           "'$(reboot)'",
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package privacy
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Mode selects how usernames are published
+type Mode string
+
+const (
+	// ModeKeep publishes usernames unchanged
+	ModeKeep Mode = "keep"
+	// ModeHMAC replaces usernames with a keyed HMAC-SHA256 pseudonym
+	ModeHMAC Mode = "hmac"
+	// ModeAlias maps usernames through an allowlisted alias file; other users become "other"
+	ModeAlias Mode = "alias"
+	// ModeDrop removes the user dimension entirely
+	ModeDrop Mode = "drop"
+)
+
+// OtherUser is the alias used for users missing from the alias file
+const OtherUser = "other"
+
+// UserMapper converts real usernames into the value published in metrics
+type UserMapper struct {
+	mode    Mode
+	key     []byte
+	aliases map[string]string
+}
+
+// NewUserMapper creates a mapper for the given mode. keyFile is required for
+// ModeHMAC and aliasFile for ModeAlias.
+func NewUserMapper(mode string, keyFile string, aliasFile string) (*UserMapper, error) {
+	m := &UserMapper{mode: Mode(strings.ToLower(strings.TrimSpace(mode)))}
+	if m.mode == "" {
+		m.mode = ModeKeep
+	}
+
+	switch m.mode {
+	case ModeKeep, ModeDrop:
+	case ModeHMAC:
+		if keyFile == "" {
+			return nil, fmt.Errorf("user privacy mode %q requires a key file", m.mode)
+		}
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading HMAC key: %w", err)
+		}
+		m.key = []byte(strings.TrimSpace(string(key)))
+		if len(m.key) == 0 {
+			return nil, fmt.Errorf("HMAC key file %s is empty", keyFile)
+		}
+	case ModeAlias:
+		if aliasFile == "" {
+			return nil, fmt.Errorf("user privacy mode %q requires an alias file", m.mode)
+		}
+		aliases, err := loadAliases(aliasFile)
+		if err != nil {
+			return nil, err
+		}
+		m.aliases = aliases
+	default:
+		return nil, fmt.Errorf("unknown user privacy mode %q (expected keep, hmac, alias or drop)", mode)
+	}
+
+	return m, nil
+}
+
+// Mode returns the configured mode
+func (m *UserMapper) Mode() Mode {
+	return m.mode
+}
+
+// Map returns the published form of a username ("" in ModeDrop)
+func (m *UserMapper) Map(user string) string {
+	switch m.mode {
+	case ModeHMAC:
+		mac := hmac.New(sha256.New, m.key)
+		mac.Write([]byte(user))
+		return "u_" + hex.EncodeToString(mac.Sum(nil))[:12]
+	case ModeAlias:
+		if alias, ok := m.aliases[user]; ok {
+			return alias
+		}
+		return OtherUser
+	case ModeDrop:
+		return ""
+	default:
+		return user
+	}
+}
+
+// loadAliases reads "user alias" pairs, one per line; blank lines and # comments are ignored
+func loadAliases(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading alias file: %w", err)
+	}
+	defer f.Close()
+
+	aliases := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.ReplaceAll(line, ",", " "))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"user alias\"", path, lineNo)
+		}
+		aliases[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading alias file: %w", err)
+	}
+	return aliases, nil
+}
@@ -0,0 +1,127 @@
+package privacy
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewUserMapper(t *testing.T) {
+	key := writeFile(t, "key", "secret\n")
+	aliases := writeFile(t, "aliases", "alice a1\n")
+	tests := []struct {
+		name      string
+		mode      string
+		keyFile   string
+		aliasFile string
+		want      Mode
+		wantErr   string
+	}{
+		{name: "empty mode keeps usernames", mode: "", want: ModeKeep},
+		{name: "mode is case-insensitive", mode: " HMAC ", keyFile: key, want: ModeHMAC},
+		{name: "drop", mode: "drop", want: ModeDrop},
+		{name: "alias", mode: "alias", aliasFile: aliases, want: ModeAlias},
+		{name: "unknown mode", mode: "hash", wantErr: "unknown user privacy mode"},
+		{name: "hmac without a key", mode: "hmac", wantErr: "requires a key file"},
+		{name: "missing key file", mode: "hmac", keyFile: filepath.Join(t.TempDir(), "missing"), wantErr: "reading HMAC key"},
+		{name: "blank key", mode: "hmac", keyFile: writeFile(t, "blank", " \n"), wantErr: "is empty"},
+		{name: "alias without a file", mode: "alias", wantErr: "requires an alias file"},
+		{name: "malformed alias line", mode: "alias", aliasFile: writeFile(t, "bad", "alice\n"), wantErr: ":1: expected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewUserMapper(tt.mode, tt.keyFile, tt.aliasFile)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewUserMapper() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Mode() != tt.want {
+				t.Errorf("Mode() = %q, want %q", m.Mode(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	mapper := func(key string) *UserMapper {
+		t.Helper()
+		m, err := NewUserMapper("hmac", writeFile(t, "key", key), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	m := mapper("secret")
+
+	alice := m.Map("alice")
+	if !regexp.MustCompile(`^u_[0-9a-f]{12}$`).MatchString(alice) {
+		t.Errorf("Map(alice) = %q, want u_ and 12 hex digits", alice)
+	}
+	// HMAC-SHA256("secret", "alice"), so pseudonyms survive restarts and upgrades
+	if want := "u_4360c67bc810"; alice != want {
+		t.Errorf("Map(alice) = %q, want %q", alice, want)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		same bool
+	}{
+		{"same user again", m.Map("alice"), true},
+		{"same key in another mapper, surrounding whitespace ignored", mapper("  secret\n").Map("alice"), true},
+		{"another user", m.Map("bob"), false},
+		{"another key", mapper("other secret").Map("alice"), false},
+	}
+	for _, tt := range tests {
+		if (tt.got == alice) != tt.same {
+			t.Errorf("%s: %q, Map(alice) %q, want same %v", tt.name, tt.got, alice, tt.same)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	aliases := writeFile(t, "aliases", `# user alias
+alice  a1
+
+bob,b2
+`)
+	tests := []struct {
+		mode string
+		user string
+		want string
+	}{
+		{"keep", "alice", "alice"},
+		{"alias", "alice", "a1"},
+		{"alias", "bob", "b2"},
+		{"alias", "carol", OtherUser},
+		{"alias", "", OtherUser},
+		{"drop", "alice", ""},
+		{"drop", "", ""},
+	}
+	for _, tt := range tests {
+		m, err := NewUserMapper(tt.mode, "", aliases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Map(tt.user); got != tt.want {
+			t.Errorf("%s: Map(%q) = %q, want %q", tt.mode, tt.user, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats
+const (
+	FormatTable    = "table"
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// dateLayout is how report ranges are printed
+const dateLayout = "2006-01-02 15:04"
+
+// Write renders the report in the given format
+func Write(w io.Writer, r *Report, format string) error {
+	switch format {
+	case FormatTable:
+		return writeTable(w, r)
+	case FormatCSV:
+		return writeCSV(w, r)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case FormatMarkdown:
+		return writeMarkdown(w, r)
+	}
+	return fmt.Errorf("unknown format %q (expected table, csv, json or markdown)", format)
+}
+
+// header returns the column names for a grouping
+func (r *Report) header(by string) []string {
+	cols := []string{by}
+	if r.HasJobs {
+		cols = append(cols, "jobs")
+	}
+	return append(cols, "cpu_hours", "cpu_share", "gpu_hours", "gpu_share")
+}
+
+// cells formats a row; shares are relative to the group total
+func (r *Report) cells(row, total Row) []string {
+	cells := []string{row.Name}
+	if r.HasJobs {
+		cells = append(cells, strconv.Itoa(row.Jobs))
+	}
+	return append(cells,
+		strconv.FormatFloat(row.CPUHours, 'f', 1, 64), share(row.CPUHours, total.CPUHours),
+		strconv.FormatFloat(row.GPUHours, 'f', 1, 64), share(row.GPUHours, total.GPUHours))
+}
+
+// share formats part/total as a percentage
+func share(part, total float64) string {
+	if total <= 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(100*part/total, 'f', 1, 64) + "%"
+}
+
+// rangeLine describes the report's time range and source
+func (r *Report) rangeLine() string {
+	return fmt.Sprintf("Usage from %s to %s (source: %s)", r.From.Format(dateLayout), r.To.Format(dateLayout), r.Source)
+}
+
+func writeTable(w io.Writer, r *Report) error {
+	fmt.Fprintln(w, r.rangeLine())
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, g := range r.Groups {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(r.header(g.By), "\t"))+"\t")
+		for _, row := range g.Rows {
+			fmt.Fprintln(tw, strings.Join(r.cells(row, g.Total), "\t")+"\t")
+		}
+		fmt.Fprintln(tw, strings.Join(r.cells(g.Total, g.Total), "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+	for i, g := range r.Groups {
+		header := append([]string{"group", "name"}, r.header(g.By)[1:]...)
+		if i == 0 {
+			if err := cw.Write(header); err != nil {
+				return err
+			}
+		}
+		for _, row := range g.Rows {
+			if err := cw.Write(append([]string{g.By}, r.cells(row, g.Total)...)); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(append([]string{g.By}, r.cells(g.Total, g.Total)...)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeMarkdown(w io.Writer, r *Report) error {
+	fmt.Fprintf(w, "%s\n", r.rangeLine())
+	for _, g := range r.Groups {
+		header := r.header(g.By)
+		fmt.Fprintf(w, "\n## By %s\n\n", g.By)
+		fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+		align := make([]string, len(header))
+		align[0] = "---"
+		for i := 1; i < len(align); i++ {
+			align[i] = "---:"
+		}
+		fmt.Fprintf(w, "|%s|\n", strings.Join(align, "|"))
+		for _, row := range g.Rows {
+			fmt.Fprintf(w, "| %s |\n", strings.Join(escapeCells(r.cells(row, g.Total)), " | "))
+		}
+		total := r.cells(g.Total, g.Total)
+		total[0] = "**total**"
+		fmt.Fprintf(w, "| %s |\n", strings.Join(total, " | "))
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// escapeCells escapes characters that would break a Markdown table
+func escapeCells(cells []string) []string {
+	for i, c := range cells {
+		cells[i] = strings.ReplaceAll(c, "|", `\|`)
+	}
+	return cells
+}
@@ -0,0 +1,222 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pbs-exporter/internal/accounting"
+	"pbs-exporter/internal/store"
+)
+
+// Groupings a report can be broken down by
+const (
+	ByUser    = "user"
+	ByProject = "project"
+	ByQueue   = "queue"
+)
+
+// Report sources
+const (
+	SourceAccounting = "accounting"
+	SourceStore      = "store"
+)
+
+// Row is the usage of one user, project or queue
+type Row struct {
+	Name     string  `json:"name"`
+	Jobs     int     `json:"jobs,omitempty"`
+	CPUHours float64 `json:"cpu_hours"`
+	GPUHours float64 `json:"gpu_hours"`
+}
+
+// Group is the usage broken down by one grouping, largest CPU-hours first
+type Group struct {
+	By    string `json:"by"`
+	Rows  []Row  `json:"rows"`
+	Total Row    `json:"total"`
+}
+
+// Report is CPU-hour/GPU-hour usage over a time range
+type Report struct {
+	Source string    `json:"source"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// HasJobs is false when the source cannot count jobs (the history store)
+	HasJobs bool    `json:"-"`
+	Groups  []Group `json:"groups"`
+}
+
+// ParseGroupings parses a comma-separated list such as "user,queue"
+func ParseGroupings(s string) ([]string, error) {
+	var by []string
+	for _, g := range strings.Split(s, ",") {
+		g = strings.ToLower(strings.TrimSpace(g))
+		switch g {
+		case "":
+			continue
+		case ByUser, ByProject, ByQueue:
+			by = append(by, g)
+		default:
+			return nil, fmt.Errorf("unknown grouping %q (expected user, project or queue)", g)
+		}
+	}
+	if len(by) == 0 {
+		return nil, fmt.Errorf("no grouping given")
+	}
+	return by, nil
+}
+
+// FromAccounting builds a report from accounting records. A job's usage is
+// counted on its E (end) record, so jobs still running at the end of the range
+// are counted in the range they finish in.
+func FromAccounting(records []accounting.Record, from, to time.Time, by []string) *Report {
+	rep := &Report{Source: SourceAccounting, From: from, To: to, HasJobs: true}
+	for _, g := range by {
+		rows := make(map[string]*Row)
+		for _, rec := range records {
+			if rec.Type != accounting.TypeEnded {
+				continue
+			}
+			name := recordKey(rec, g)
+			row, ok := rows[name]
+			if !ok {
+				row = &Row{Name: name}
+				rows[name] = row
+			}
+			row.Jobs++
+			row.CPUHours += rec.CPUHours()
+			row.GPUHours += rec.GPUHours()
+		}
+		rep.Groups = append(rep.Groups, newGroup(g, rows))
+	}
+	return rep
+}
+
+// recordKey returns the name a record is grouped under, "none" when unset
+func recordKey(rec accounting.Record, by string) string {
+	var v string
+	switch by {
+	case ByUser:
+		v = rec.User
+	case ByProject:
+		v = rec.Attrs["project"]
+	case ByQueue:
+		v = rec.Queue
+	}
+	if v = strings.TrimSpace(v); v == "" {
+		return "none"
+	}
+	return v
+}
+
+// storeKinds maps groupings to the history store's sample kinds
+var storeKinds = map[string]string{
+	ByUser:    store.KindUser,
+	ByProject: store.KindProject,
+	ByQueue:   store.KindQueue,
+}
+
+// FromStore builds a report for cluster from the history store by integrating
+// the CPUs and GPUs allocated to running jobs at every recorded jobs collection
+func FromStore(st *store.Store, cluster string, from, to time.Time, by []string) (*Report, error) {
+	rep := &Report{Source: SourceStore, From: from, To: to}
+
+	// Weigh each sample by the time until the next jobs collection. Node and
+	// queue collections are recorded on their own timestamps, and every jobs
+	// collection records the cluster's running jobs, even when no job runs.
+	times, err := st.Timestamps(store.Query{Cluster: cluster, Kind: store.KindCluster, Metric: "jobs_running", From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	weights := sampleWeights(times)
+
+	for _, g := range by {
+		rows := make(map[string]*Row)
+		for metric, hours := range map[string]func(*Row) *float64{
+			"cpus": func(r *Row) *float64 { return &r.CPUHours },
+			"gpus": func(r *Row) *float64 { return &r.GPUHours },
+		} {
+			series, err := st.Query(store.Query{Cluster: cluster, Kind: storeKinds[g], Metric: metric, From: from, To: to})
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range series {
+				row, ok := rows[s.Name]
+				if !ok {
+					row = &Row{Name: s.Name}
+					rows[s.Name] = row
+				}
+				for _, p := range s.Points {
+					*hours(row) += p.Value * weights[p.Time.Unix()]
+				}
+			}
+		}
+		rep.Groups = append(rep.Groups, newGroup(g, rows))
+	}
+	return rep, nil
+}
+
+// sampleWeights returns the hours each collection stands for: the time until
+// the next collection, or the usual interval across gaps where the exporter was not running
+func sampleWeights(times []time.Time) map[int64]float64 {
+	weights := make(map[int64]float64, len(times))
+	if len(times) == 0 {
+		return weights
+	}
+
+	gaps := make([]time.Duration, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i].Sub(times[i-1]))
+	}
+	usual := time.Minute
+	if len(gaps) > 0 {
+		sorted := append([]time.Duration(nil), gaps...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		usual = sorted[len(sorted)/2]
+	}
+
+	for i, t := range times {
+		w := usual
+		if i < len(gaps) && gaps[i] <= 2*usual {
+			w = gaps[i]
+		}
+		weights[t.Unix()] = w.Hours()
+	}
+	return weights
+}
+
+// newGroup sorts the rows and adds the total
+func newGroup(by string, rows map[string]*Row) Group {
+	g := Group{By: by, Rows: make([]Row, 0, len(rows)), Total: Row{Name: "total"}}
+	for _, r := range rows {
+		g.Rows = append(g.Rows, *r)
+		g.Total.Jobs += r.Jobs
+		g.Total.CPUHours += r.CPUHours
+		g.Total.GPUHours += r.GPUHours
+	}
+	sort.Slice(g.Rows, func(i, j int) bool {
+		a, b := g.Rows[i], g.Rows[j]
+		if a.CPUHours != b.CPUHours {
+			return a.CPUHours > b.CPUHours
+		}
+		if a.GPUHours != b.GPUHours {
+			return a.GPUHours > b.GPUHours
+		}
+		return a.Name < b.Name
+	})
+	return g
+}
+
+// Top keeps the n largest rows of every group (0 keeps all); totals still cover every row
+func (r *Report) Top(n int) {
+	if n <= 0 {
+		return
+	}
+	for i := range r.Groups {
+		if len(r.Groups[i].Rows) > n {
+			r.Groups[i].Rows = r.Groups[i].Rows[:n]
+		}
+	}
+}
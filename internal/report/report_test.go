@@ -0,0 +1,176 @@
+package report
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"pbs-exporter/internal/accounting"
+	"pbs-exporter/internal/store"
+)
+
+func TestFromAccounting(t *testing.T) {
+	var records []accounting.Record
+	for _, line := range []string{
+		`10/18/2026 09:00:00;Q;1.pbs01;user=alice queue=long project=climate`,
+		`10/18/2026 10:00:00;E;1.pbs01;user=alice queue=long project=climate Resource_List.ncpus=8 Resource_List.ngpus=1 resources_used.walltime=02:00:00`,
+		`10/18/2026 11:00:00;E;2.pbs01;user=alice queue=gpu project=climate Resource_List.ncpus=4 Resource_List.ngpus=2 resources_used.walltime=01:30:00`,
+		`10/18/2026 12:00:00;E;3.pbs01;user=bob queue=long Resource_List.ncpus=32 resources_used.walltime=01:00:00`,
+		`10/18/2026 12:30:00;D;4.pbs01;requestor=bob@login01`,
+	} {
+		rec, err := accounting.ParseRecord(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	tests := []struct {
+		by    string
+		rows  []Row
+		total Row
+	}{
+		{
+			by:    ByUser,
+			rows:  []Row{{Name: "bob", Jobs: 1, CPUHours: 32}, {Name: "alice", Jobs: 2, CPUHours: 22, GPUHours: 5}},
+			total: Row{Name: "total", Jobs: 3, CPUHours: 54, GPUHours: 5},
+		},
+		{
+			// Jobs without a project are grouped under "none"
+			by:    ByProject,
+			rows:  []Row{{Name: "none", Jobs: 1, CPUHours: 32}, {Name: "climate", Jobs: 2, CPUHours: 22, GPUHours: 5}},
+			total: Row{Name: "total", Jobs: 3, CPUHours: 54, GPUHours: 5},
+		},
+		{
+			by:    ByQueue,
+			rows:  []Row{{Name: "long", Jobs: 2, CPUHours: 48, GPUHours: 2}, {Name: "gpu", Jobs: 1, CPUHours: 6, GPUHours: 3}},
+			total: Row{Name: "total", Jobs: 3, CPUHours: 54, GPUHours: 5},
+		},
+	}
+
+	from := time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	rep := FromAccounting(records, from, from.AddDate(0, 0, 1), []string{ByUser, ByProject, ByQueue})
+	if !rep.HasJobs || len(rep.Groups) != len(tests) {
+		t.Fatalf("report has jobs %v and %d groups", rep.HasJobs, len(rep.Groups))
+	}
+	for i, tt := range tests {
+		g := rep.Groups[i]
+		if g.By != tt.by || !reflect.DeepEqual(g.Rows, tt.rows) || g.Total != tt.total {
+			t.Errorf("group %s = %+v, want %s %+v total %+v", g.By, g, tt.by, tt.rows, tt.total)
+		}
+	}
+
+	// Top keeps the largest rows but not their totals
+	rep.Top(1)
+	if g := rep.Groups[0]; len(g.Rows) != 1 || g.Rows[0].Name != "bob" || g.Total.CPUHours != 54 {
+		t.Errorf("group after Top(1) = %+v", g)
+	}
+}
+
+func TestFromStore(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "history.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// Jobs are collected every 10 minutes, nodes every minute, and the jobs
+	// collection at 09:30 failed. alice runs 8 CPUs and 1 GPU throughout,
+	// bob 4 CPUs from 09:40.
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	for m := 0; m < 60; m++ {
+		ts := start.Add(time.Duration(m) * time.Minute)
+		samples := []store.Sample{{Kind: store.KindCluster, Metric: "nodes", Value: 10}}
+		if m%10 == 0 && m != 30 {
+			samples = append(samples,
+				store.Sample{Kind: store.KindCluster, Metric: "jobs_running", Value: 1},
+				store.Sample{Kind: store.KindUser, Name: "alice", Metric: "cpus", Value: 8},
+				store.Sample{Kind: store.KindUser, Name: "alice", Metric: "gpus", Value: 1},
+				store.Sample{Kind: store.KindQueue, Name: "gpu", Metric: "cpus", Value: 8},
+				store.Sample{Kind: store.KindQueue, Name: "gpu", Metric: "gpus", Value: 1},
+			)
+			if m >= 40 {
+				samples = append(samples,
+					store.Sample{Kind: store.KindUser, Name: "bob", Metric: "cpus", Value: 4},
+					store.Sample{Kind: store.KindQueue, Name: "long", Metric: "cpus", Value: 4},
+				)
+			}
+		}
+		if err := st.Record(ts, "", samples); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another cluster's collections do not change the weights
+	if err := st.Record(start.Add(5*time.Minute), "other", []store.Sample{{Kind: store.KindCluster, Metric: "jobs_running", Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := FromStore(st, "", start, start.Add(time.Hour), []string{ByUser, ByQueue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.HasJobs || len(rep.Groups) != 2 {
+		t.Fatalf("report has jobs %v and %d groups", rep.HasJobs, len(rep.Groups))
+	}
+
+	// Each jobs sample stands for the time until the next jobs collection;
+	// the one before the failed collection covers its gap too, and the last
+	// one the usual interval
+	tests := []struct {
+		group int
+		rows  []Row
+	}{
+		{0, []Row{{Name: "alice", CPUHours: 8, GPUHours: 1}, {Name: "bob", CPUHours: 4 * 20.0 / 60}}},
+		{1, []Row{{Name: "gpu", CPUHours: 8, GPUHours: 1}, {Name: "long", CPUHours: 4 * 20.0 / 60}}},
+	}
+	for _, tt := range tests {
+		g := rep.Groups[tt.group]
+		if len(g.Rows) != len(tt.rows) {
+			t.Errorf("group %s rows = %+v, want %+v", g.By, g.Rows, tt.rows)
+			continue
+		}
+		for i, want := range tt.rows {
+			got := g.Rows[i]
+			if got.Name != want.Name || math.Abs(got.CPUHours-want.CPUHours) > 1e-9 || math.Abs(got.GPUHours-want.GPUHours) > 1e-9 {
+				t.Errorf("group %s row %d = %+v, want %+v", g.By, i, got, want)
+			}
+		}
+	}
+}
+
+func TestSampleWeights(t *testing.T) {
+	at := func(minutes ...int) []time.Time {
+		start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+		var times []time.Time
+		for _, m := range minutes {
+			times = append(times, start.Add(time.Duration(m)*time.Minute))
+		}
+		return times
+	}
+	tests := []struct {
+		name  string
+		times []time.Time
+		want  []float64 // minutes
+	}{
+		{"none", nil, nil},
+		{"a single collection stands for a minute", at(0), []float64{1}},
+		{"regular collections", at(0, 5, 10), []float64{5, 5, 5}},
+		{"a missed collection", at(0, 5, 15, 20, 25), []float64{5, 10, 5, 5, 5}},
+		{"the exporter was stopped", at(0, 5, 10, 100, 105), []float64{5, 5, 5, 5, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weights := sampleWeights(tt.times)
+			if len(weights) != len(tt.want) {
+				t.Fatalf("got %d weights, want %d", len(weights), len(tt.want))
+			}
+			for i, ts := range tt.times {
+				if got := weights[ts.Unix()] * 60; math.Abs(got-tt.want[i]) > 1e-9 {
+					t.Errorf("weight at %s = %v minutes, want %v", ts.Format("15:04"), got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package schedlog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pbs-exporter/internal/logtail"
+)
+
+// Reasons a job was not run, as counted by the exporter
+const (
+	ReasonInsufficientResources = "insufficient_resources"
+	ReasonQueueLimit            = "queue_limit"
+	ReasonServerLimit           = "server_limit"
+	ReasonReservationConflict   = "reservation_conflict"
+	ReasonOther                 = "other"
+)
+
+const timeLayout = "01/02/2006 15:04:05"
+
+// Messages marking the scheduler cycle and its decisions
+const (
+	msgCycleStart  = "Starting Scheduling Cycle"
+	msgCycleEnd    = "Leaving Scheduling Cycle"
+	msgConsidering = "Considering job to run"
+	msgJobRun      = "Job run"
+	msgNotRunning  = "Not Running:"
+)
+
+// Entry is a single scheduler log line:
+// "10/18/2026 09:00:00;0080;pbs_sched;Req;;Starting Scheduling Cycle"
+type Entry struct {
+	Time    time.Time
+	Class   string
+	ID      string
+	Message string
+}
+
+// ParseEntry parses one scheduler log line. Fractional seconds, written by
+// PBS when high resolution timestamps are enabled, are accepted.
+func ParseEntry(line string) (Entry, error) {
+	parts := strings.SplitN(strings.TrimSpace(line), ";", 6)
+	if len(parts) < 6 {
+		return Entry{}, fmt.Errorf("malformed scheduler log line %q", line)
+	}
+
+	ts, err := time.ParseInLocation(timeLayout, parts[0], time.Local)
+	if err != nil {
+		return Entry{}, fmt.Errorf("malformed scheduler log timestamp %q: %w", parts[0], err)
+	}
+
+	return Entry{
+		Time:    ts,
+		Class:   parts[3],
+		ID:      parts[4],
+		Message: parts[5],
+	}, nil
+}
+
+// NotRunningReason classifies a "Not Running: ..." message, e.g.
+// "Not Running: Insufficient amount of resource: ncpus (R: 64 A: 32 T: 2048)"
+func NotRunningReason(message string) string {
+	m := strings.ToLower(message)
+	switch {
+	case strings.Contains(m, "reservation"):
+		return ReasonReservationConflict
+	case strings.Contains(m, "insufficient amount of"):
+		return ReasonInsufficientResources
+	case strings.Contains(m, "queue") && (strings.Contains(m, "limit") || strings.Contains(m, "max_run")):
+		return ReasonQueueLimit
+	case strings.Contains(m, "server") && (strings.Contains(m, "limit") || strings.Contains(m, "max_run")):
+		return ReasonServerLimit
+	}
+	return ReasonOther
+}
+
+// Cycle is one completed scheduling cycle
+type Cycle struct {
+	Start time.Time
+	End   time.Time
+	// Considered counts "Considering job to run" lines, which PBS only
+	// writes when the scheduler's log_filter includes debug events
+	Considered int
+	// ConsideredLogged is set once such a line has been seen, in this or an
+	// earlier cycle; Considered is meaningless until then
+	ConsideredLogged bool
+	Run              int
+}
+
+// Duration returns how long the cycle took
+func (c Cycle) Duration() time.Duration {
+	return c.End.Sub(c.Start)
+}
+
+// Stats is what the scheduler logged since the previous read
+type Stats struct {
+	// Cycles are the cycles that ended since the previous read
+	Cycles []Cycle
+	// NotRunning counts "Not Running" messages by reason. The scheduler
+	// logs one for every job it leaves queued in a cycle, so a job waiting
+	// through several cycles is counted once per cycle.
+	NotRunning  map[string]int
+	ParseErrors int
+}
+
+// Reader tails the sched_logs directory and follows the scheduling cycles
+type Reader struct {
+	tailer *logtail.Tailer
+	// cycle is the cycle in progress, nil between cycles
+	cycle *Cycle
+	// considering is set once a "Considering job to run" line has been seen
+	considering bool
+}
+
+// NewReader creates a reader for sched_logs
+func NewReader(dir string) *Reader {
+	return &Reader{tailer: logtail.New(dir)}
+}
+
+// Read returns the cycles completed and the jobs not run since the last call.
+// A cycle spanning two calls is returned by the call that sees it end.
+func (r *Reader) Read() (Stats, error) {
+	lines, err := r.tailer.ReadLines()
+
+	stats := Stats{NotRunning: make(map[string]int)}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, perr := ParseEntry(line)
+		if perr != nil {
+			stats.ParseErrors++
+			continue
+		}
+		r.handle(entry, &stats)
+	}
+
+	return stats, err
+}
+
+// handle updates the cycle in progress and stats with one entry
+func (r *Reader) handle(e Entry, stats *Stats) {
+	switch {
+	case e.Message == msgCycleStart:
+		// A cycle that never ended was cut short by a scheduler restart; drop it
+		r.cycle = &Cycle{Start: e.Time}
+	case e.Message == msgCycleEnd:
+		if r.cycle != nil {
+			r.cycle.End = e.Time
+			r.cycle.ConsideredLogged = r.considering
+			stats.Cycles = append(stats.Cycles, *r.cycle)
+			r.cycle = nil
+		}
+	case e.Class != "Job":
+	case e.Message == msgConsidering:
+		r.considering = true
+		if r.cycle != nil {
+			r.cycle.Considered++
+		}
+	case e.Message == msgJobRun:
+		if r.cycle != nil {
+			r.cycle.Run++
+		}
+	case strings.HasPrefix(e.Message, msgNotRunning):
+		stats.NotRunning[NotRunningReason(strings.TrimPrefix(e.Message, msgNotRunning))]++
+	}
+}
+
+// Close releases the underlying log file
+func (r *Reader) Close() error {
+	return r.tailer.Close()
+}
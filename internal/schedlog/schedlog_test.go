@@ -0,0 +1,193 @@
+package schedlog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"pbs-exporter/internal/logtail"
+)
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    Entry
+		wantErr bool
+	}{
+		{
+			line: "10/18/2026 09:00:00;0080;pbs_sched;Req;;Starting Scheduling Cycle",
+			want: Entry{Time: time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local), Class: "Req", Message: "Starting Scheduling Cycle"},
+		},
+		{
+			line: "10/18/2026 09:00:01.250417;0040;pbs_sched;Job;1234.pbs01;Not Running: Insufficient amount of resource: ncpus",
+			want: Entry{
+				Time:    time.Date(2026, 10, 18, 9, 0, 1, 250417000, time.Local),
+				Class:   "Job",
+				ID:      "1234.pbs01",
+				Message: "Not Running: Insufficient amount of resource: ncpus",
+			},
+		},
+		{
+			// Semicolons in the message are kept
+			line: "10/18/2026 09:00:02;0040;pbs_sched;Job;1235.pbs01;Not Running: a; b\n",
+			want: Entry{Time: time.Date(2026, 10, 18, 9, 0, 2, 0, time.Local), Class: "Job", ID: "1235.pbs01", Message: "Not Running: a; b"},
+		},
+		{line: "10/18/2026 09:00:00;0080;pbs_sched;Req", wantErr: true},
+		{line: "2026-10-18 09:00:00;0080;pbs_sched;Req;;Starting Scheduling Cycle", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseEntry(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEntry(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseEntry(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestNotRunningReason(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{" Insufficient amount of resource: ncpus (R: 64 A: 32 T: 2048)", ReasonInsufficientResources},
+		{" Insufficient amount of queue resource: ngpus", ReasonInsufficientResources},
+		{" Queue long job limit has been reached.", ReasonQueueLimit},
+		{" Maximum number of jobs for queue small reached (max_run)", ReasonQueueLimit},
+		{" Server per-user job limit reached", ReasonServerLimit},
+		{" Job would conflict with reservation or top job", ReasonReservationConflict},
+		{" Not enough free nodes available", ReasonOther},
+	}
+	for _, tt := range tests {
+		if got := NotRunningReason(tt.message); got != tt.want {
+			t.Errorf("NotRunningReason(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	// cycle starts a scheduling cycle at start, followed by lines
+	cycle := func(start string, lines ...string) string {
+		s := "10/18/2026 " + start + ";0080;pbs_sched;Req;;Starting Scheduling Cycle\n"
+		for _, line := range lines {
+			s += line + "\n"
+		}
+		return s
+	}
+	const (
+		considering = "10/18/2026 09:00:00;0400;pbs_sched;Job;1.pbs01;Considering job to run"
+		jobRun      = "10/18/2026 09:00:01;0040;pbs_sched;Job;1.pbs01;Job run"
+		notRunning  = "10/18/2026 09:00:01;0040;pbs_sched;Job;2.pbs01;Not Running: Insufficient amount of resource: ncpus"
+		queueLimit  = "10/18/2026 09:00:01;0040;pbs_sched;Job;3.pbs01;Not Running: Queue long job limit has been reached."
+	)
+
+	tests := []struct {
+		name       string
+		reads      []string // appended before each read
+		wantCycles []Cycle  // from all reads
+		wantNot    map[string]int
+		wantErrors int
+	}{
+		{
+			name: "cycle with debug logging",
+			reads: []string{
+				cycle("09:00:00", considering, jobRun, notRunning, queueLimit) +
+					"10/18/2026 09:00:03;0080;pbs_sched;Req;;Leaving Scheduling Cycle\n",
+			},
+			wantCycles: []Cycle{{
+				Start:            now,
+				End:              now.Add(3 * time.Second),
+				Considered:       1,
+				ConsideredLogged: true,
+				Run:              1,
+			}},
+			wantNot: map[string]int{ReasonInsufficientResources: 1, ReasonQueueLimit: 1},
+		},
+		{
+			name: "cycle without debug logging",
+			reads: []string{
+				cycle("09:00:00", jobRun) + "10/18/2026 09:00:02;0080;pbs_sched;Req;;Leaving Scheduling Cycle\n",
+			},
+			wantCycles: []Cycle{{Start: now, End: now.Add(2 * time.Second), Run: 1}},
+			wantNot:    map[string]int{},
+		},
+		{
+			name: "cycle spanning two reads",
+			reads: []string{
+				cycle("09:00:00", considering),
+				"10/18/2026 09:00:05;0080;pbs_sched;Req;;Leaving Scheduling Cycle\n",
+			},
+			wantCycles: []Cycle{{Start: now, End: now.Add(5 * time.Second), Considered: 1, ConsideredLogged: true}},
+			wantNot:    map[string]int{},
+		},
+		{
+			name: "cycle cut short by a restart is dropped",
+			reads: []string{
+				cycle("09:00:00", considering) +
+					cycle("09:00:10") +
+					"10/18/2026 09:00:11;0080;pbs_sched;Req;;Leaving Scheduling Cycle\n",
+			},
+			// The restarted cycle considered no job, but debug logging was seen before
+			wantCycles: []Cycle{{Start: now.Add(10 * time.Second), End: now.Add(11 * time.Second), ConsideredLogged: true}},
+			wantNot:    map[string]int{},
+		},
+		{
+			name:       "malformed lines and an end without a start",
+			reads:      []string{"garbage\n\n10/18/2026 09:00:11;0080;pbs_sched;Req;;Leaving Scheduling Cycle\n"},
+			wantNot:    map[string]int{},
+			wantErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, logtail.FileName(now))
+			if err := os.WriteFile(path, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			r := NewReader(dir)
+			defer r.Close()
+			r.tailer.Now = func() time.Time { return now }
+			if _, err := r.Read(); err != nil {
+				t.Fatal(err)
+			}
+
+			var cycles []Cycle
+			notRunning := make(map[string]int)
+			parseErrors := 0
+			for _, data := range tt.reads {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString(data)
+				f.Close()
+
+				stats, err := r.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				cycles = append(cycles, stats.Cycles...)
+				for reason, n := range stats.NotRunning {
+					notRunning[reason] += n
+				}
+				parseErrors += stats.ParseErrors
+			}
+
+			if !reflect.DeepEqual(cycles, tt.wantCycles) {
+				t.Errorf("cycles = %+v, want %+v", cycles, tt.wantCycles)
+			}
+			if !reflect.DeepEqual(notRunning, tt.wantNot) {
+				t.Errorf("not running = %v, want %v", notRunning, tt.wantNot)
+			}
+			if parseErrors != tt.wantErrors {
+				t.Errorf("parse errors = %d, want %d", parseErrors, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"pbs-exporter/internal/accounting"
+	"pbs-exporter/internal/pbs"
+)
+
+// historyTracker remembers which finished jobs were already counted, so a job
+// that stays in `qstat -x` across several collections is only counted once
+type historyTracker struct {
+	window time.Duration
+	seen   map[string]time.Time
+	// stateFile, when set, keeps lastEnd across restarts
+	stateFile string
+	// lastEnd is the latest end time counted so far
+	lastEnd time.Time
+	// resumeAfter is lastEnd as loaded from stateFile: jobs that ended by
+	// then were counted before the restart
+	resumeAfter time.Time
+}
+
+// historyStats accumulates per-queue samples for the current window
+type historyStats struct {
+	finished int
+	moved    int
+	waits    []float64
+	runs     []float64
+}
+
+// SetHistoryWindow enables the `qstat -x -f` history collector for jobs that ended within window
+func (s *Server) SetHistoryWindow(window time.Duration) {
+	s.history = &historyTracker{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// SetHistoryStateFile keeps the end time of the last counted job in path, so
+// the history counters do not count jobs again after a restart
+func (s *Server) SetHistoryStateFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.history.stateFile = path
+	if len(data) == 0 {
+		return nil
+	}
+	last, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid history state in %s: %w", path, err)
+	}
+	s.history.lastEnd, s.history.resumeAfter = last, last
+	return nil
+}
+
+// saveState writes lastEnd to the state file, replacing it atomically
+func (h *historyTracker) saveState() error {
+	tmp := h.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(h.lastEnd.Format(time.RFC3339Nano)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.stateFile)
+}
+
+// updateHistoryMetrics derives throughput and wait/run times from recently finished jobs
+func (s *Server) updateHistoryMetrics() error {
+	now := time.Now()
+	cutoff := now.Add(-s.history.window)
+
+	// Let the server select the jobs that ended within the window
+	ids, err := s.pbsClient.GetFinishedJobIDs(cutoff)
+	if err != nil {
+		return err
+	}
+	var jobs []pbs.Job
+	if len(ids) > 0 {
+		output, err := s.pbsClient.GetQstatHistoryOutput(ids)
+		if err != nil {
+			return err
+		}
+		jobs = s.pbsClient.ParseQstatHistoryOutput(output)
+	}
+
+	lastEnd := s.history.lastEnd
+	stats := make(map[string]*historyStats)
+
+	for _, job := range jobs {
+		if job.State != "F" && job.State != "M" {
+			continue
+		}
+		end, ok := jobEndTime(job)
+		if !ok || end.Before(cutoff) {
+			continue
+		}
+
+		st, ok := stats[job.Queue]
+		if !ok {
+			st = &historyStats{}
+			stats[job.Queue] = st
+		}
+
+		state := "finished"
+		if job.State == "M" {
+			state = "moved"
+			st.moved++
+		} else {
+			st.finished++
+		}
+
+		wait, hasWait := jobWaitSeconds(job)
+		run, hasRun := jobRunSeconds(job, end)
+		if hasWait {
+			st.waits = append(st.waits, wait)
+		}
+		if hasRun && state == "finished" {
+			st.runs = append(st.runs, run)
+		}
+
+		// Counters and histograms only see each job once
+		if _, counted := s.history.seen[job.ID]; counted {
+			continue
+		}
+		s.history.seen[job.ID] = end
+		if !end.After(s.history.resumeAfter) {
+			continue
+		}
+		if end.After(lastEnd) {
+			lastEnd = end
+		}
+
+		s.registry.HistoryJobs.WithLabelValues(job.Queue, state).Inc()
+		if hasWait {
+			s.registry.HistoryWaitSeconds.WithLabelValues(job.Queue).Observe(wait)
+		}
+		if hasRun && state == "finished" {
+			s.registry.HistoryRunSeconds.WithLabelValues(job.Queue).Observe(run)
+		}
+
+		// The accounting log is the preferred exit source; avoid counting twice
+		if s.accounting == nil && state == "finished" {
+			if code, ok := job.ExitStatus(); ok {
+				s.recordJobExit(job.Queue, job.Owner, pbs.ExitClass(code))
+			}
+		}
+	}
+
+	// Forget jobs that have left the window
+	for id, end := range s.history.seen {
+		if end.Before(cutoff) {
+			delete(s.history.seen, id)
+		}
+	}
+
+	if lastEnd.After(s.history.lastEnd) {
+		s.history.lastEnd = lastEnd
+		if s.history.stateFile != "" {
+			if err := s.history.saveState(); err != nil {
+				log.Printf("Error saving history state: %v", err)
+			}
+		}
+	}
+
+	s.registry.Update(func() {
+		s.registry.ResetHistoryWindowMetrics()
+		for queue, st := range stats {
+			s.registry.HistoryWindowJobs.WithLabelValues(queue, "finished").Set(float64(st.finished))
+			s.registry.HistoryWindowJobs.WithLabelValues(queue, "moved").Set(float64(st.moved))
+			for stat, v := range summarize(st.waits) {
+				s.registry.HistoryWindowWait.WithLabelValues(queue, stat).Set(v)
+			}
+			for stat, v := range summarize(st.runs) {
+				s.registry.HistoryWindowRun.WithLabelValues(queue, stat).Set(v)
+			}
+		}
+	})
+	return nil
+}
+
+// jobEndTime returns when a finished job left the system
+func jobEndTime(job pbs.Job) (time.Time, bool) {
+	if t, ok := job.Time("obittime"); ok {
+		return t, true
+	}
+	return job.Time("mtime")
+}
+
+// jobWaitSeconds returns the time a job spent queued before starting
+func jobWaitSeconds(job pbs.Job) (float64, bool) {
+	queued, ok := job.Time("qtime")
+	if !ok {
+		return 0, false
+	}
+	started, ok := job.Time("stime")
+	if !ok || started.Before(queued) {
+		return 0, false
+	}
+	return started.Sub(queued).Seconds(), true
+}
+
+// jobRunSeconds returns the job's run time, preferring resources_used.walltime
+func jobRunSeconds(job pbs.Job, end time.Time) (float64, bool) {
+	if wt := job.Attr("resources_used.walltime"); wt != "" {
+		return accounting.ParseDuration(wt).Seconds(), true
+	}
+	started, ok := job.Time("stime")
+	if !ok || end.Before(started) {
+		return 0, false
+	}
+	return end.Sub(started).Seconds(), true
+}
+
+// summarize returns the mean and nearest-rank percentiles of the samples
+func summarize(samples []float64) map[string]float64 {
+	if len(samples) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	percentile := func(p float64) float64 {
+		idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return sorted[idx]
+	}
+
+	return map[string]float64{
+		"mean": sum / float64(len(sorted)),
+		"p50":  percentile(0.50),
+		"p90":  percentile(0.90),
+		"p99":  percentile(0.99),
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"pbs-exporter/internal/metrics"
+	"pbs-exporter/internal/pbs"
+)
+
+// metricValue returns the value of a counter or gauge, or the sample count of a histogram
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	var d dto.Metric
+	if err := m.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case d.Counter != nil:
+		return d.Counter.GetValue()
+	case d.Gauge != nil:
+		return d.Gauge.GetValue()
+	case d.Histogram != nil:
+		return float64(d.Histogram.GetSampleCount())
+	}
+	t.Fatalf("unsupported metric %v", &d)
+	return 0
+}
+
+// installCommands writes shell scripts named after the keys of scripts to a
+// directory put first on PATH. DIR in a script is replaced by that directory.
+func installCommands(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as PBS commands")
+	}
+	dir := t.TempDir()
+	for name, script := range scripts {
+		script = strings.ReplaceAll(script, "DIR", dir)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// fakeQselect answers qselect -x [-s states] -t{e,m}.gt.<time> from DIR/jobs,
+// whose lines hold a job ID, its state, etime and mtime in qselect's format
+const fakeQselect = `#!/bin/sh
+states=; field=; since=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-x) ;;
+	-s) shift; states=$1 ;;
+	-te.gt.*) field=3; since=${1#-te.gt.} ;;
+	-tm.gt.*) field=4; since=${1#-tm.gt.} ;;
+	*) echo "qselect: unexpected argument $1" >&2; exit 2 ;;
+	esac
+	shift
+done
+awk -v states="$states" -v field="$field" -v since="$since" \
+	'(states == "" || index(states, $2)) && $field > since { print $1 }' DIR/jobs
+`
+
+// fakeQstatHistory answers qstat -x -f <ids> from the DIR/job.<id> files and
+// logs the requested IDs to DIR/qstat.log
+const fakeQstatHistory = `#!/bin/sh
+[ "$1" = -x ] && [ "$2" = -f ] || exit 2
+shift 2
+echo "$@" >> DIR/qstat.log
+for id in "$@"; do cat "DIR/job.$id"; echo; done
+`
+
+func TestHistoryCountsJobsEndingInWindow(t *testing.T) {
+	dir := installCommands(t, map[string]string{"qselect": fakeQselect, "qstat": fakeQstatHistory})
+
+	now := time.Now()
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	const qstatTime = "Mon Jan _2 15:04:05 2006"
+	const qselectTime = "200601021504.05"
+
+	jobs := []struct {
+		id, state, queue string
+		etime, mtime     time.Time
+		stime, obittime  time.Time
+	}{
+		// Queued and eligible long before the window, finished inside it
+		{id: "10.pbs01", state: "F", queue: "long", etime: ago(3 * time.Hour), stime: ago(2 * time.Hour), obittime: ago(10 * time.Minute), mtime: ago(10 * time.Minute)},
+		// Still running, modified inside the window
+		{id: "11.pbs01", state: "R", queue: "long", etime: ago(30 * time.Minute), stime: ago(20 * time.Minute), mtime: ago(5 * time.Minute)},
+		// Finished before the window
+		{id: "12.pbs01", state: "F", queue: "long", etime: ago(5 * time.Hour), stime: ago(4 * time.Hour), obittime: ago(3 * time.Hour), mtime: ago(3 * time.Hour)},
+		// Moved to another server inside the window
+		{id: "13.pbs01", state: "M", queue: "small", etime: ago(40 * time.Minute), mtime: ago(20 * time.Minute)},
+	}
+	var table strings.Builder
+	for _, j := range jobs {
+		fmt.Fprintf(&table, "%s %s %s %s\n", j.id, j.state, j.etime.Format(qselectTime), j.mtime.Format(qselectTime))
+
+		attrs := fmt.Sprintf("Job Id: %s\n    Job_Owner = alice@login01\n    job_state = %s\n    queue = %s\n    qtime = %s\n    mtime = %s\n",
+			j.id, j.state, j.queue, j.etime.Format(qstatTime), j.mtime.Format(qstatTime))
+		if !j.stime.IsZero() {
+			attrs += "    stime = " + j.stime.Format(qstatTime) + "\n"
+		}
+		if !j.obittime.IsZero() {
+			attrs += "    obittime = " + j.obittime.Format(qstatTime) + "\n    Exit_status = 0\n"
+		}
+		if err := os.WriteFile(filepath.Join(dir, "job."+j.id), []byte(attrs), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "jobs"), []byte(table.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := metrics.NewRegistry()
+	s := New(registry, pbs.NewClient())
+	s.SetHistoryWindow(time.Hour)
+
+	// A second collection sees the same jobs without counting them again
+	for i := 0; i < 2; i++ {
+		if err := s.updateHistoryMetrics(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		metric prometheus.Metric
+		want   float64
+	}{
+		{"finished jobs", registry.HistoryJobs.WithLabelValues("long", "finished"), 1},
+		{"moved jobs", registry.HistoryJobs.WithLabelValues("small", "moved"), 1},
+		{"finished jobs in the window", registry.HistoryWindowJobs.WithLabelValues("long", "finished"), 1},
+		{"wait samples", registry.HistoryWaitSeconds.WithLabelValues("long").(prometheus.Metric), 1},
+		{"run samples", registry.HistoryRunSeconds.WithLabelValues("long").(prometheus.Metric), 1},
+		{"exits", registry.JobExits.WithLabelValues("long", pbs.ExitClassSuccess), 1},
+	}
+	for _, tt := range tests {
+		if got := metricValue(t, tt.metric); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Only finished and moved jobs modified within the window are fetched in full
+	requested, err := os.ReadFile(filepath.Join(dir, "qstat.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(requested)), "\n") {
+		if line != "10.pbs01 13.pbs01" {
+			t.Errorf("qstat -x -f asked for %q, want 10.pbs01 13.pbs01", line)
+		}
+	}
+}
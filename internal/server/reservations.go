@@ -0,0 +1,69 @@
+package server
+
+import "pbs-exporter/internal/pbs"
+
+// updateReservationMetrics exports the `pbs_rstat -f` reservations and flags
+// the nodes held by active ones
+func (s *Server) updateReservationMetrics() error {
+	output, err := s.pbsClient.GetRstatOutput()
+	if err != nil {
+		s.registry.Update(s.registry.ResetReservationMetrics)
+		return err
+	}
+	resvs := s.pbsClient.ParseRstatOutput(output)
+
+	// Reset so finished and deleted reservations disappear
+	s.registry.Update(func() {
+		s.registry.ResetReservationMetrics()
+		s.setReservationMetrics(resvs)
+	})
+	s.storeReservations(resvs)
+	return nil
+}
+
+// setReservationMetrics sets the reservation gauges and the node flags
+func (s *Server) setReservationMetrics(resvs []pbs.Reservation) {
+	held := make(map[string]bool)
+	states := make(map[string]int)
+	for _, r := range resvs {
+		states[r.State]++
+		s.registry.ReservationInfo.WithLabelValues(r.ID, r.Name, r.Owner, r.Queue, r.Type).Set(1)
+		s.registry.ReservationState.WithLabelValues(r.ID, r.State).Set(1)
+		if !r.Start.IsZero() {
+			s.registry.ReservationStart.WithLabelValues(r.ID).Set(float64(r.Start.Unix()))
+		}
+		if !r.End.IsZero() {
+			s.registry.ReservationEnd.WithLabelValues(r.ID).Set(float64(r.End.Unix()))
+		}
+		s.registry.ReservationNodes.WithLabelValues(r.ID).Set(float64(len(r.Nodes)))
+		s.registry.ReservationCpus.WithLabelValues(r.ID).Set(float64(r.NCPUs))
+		s.registry.ReservationGpus.WithLabelValues(r.ID).Set(float64(r.NGPUs))
+
+		if r.Active() {
+			for _, node := range r.Nodes {
+				held[node] = true
+			}
+		}
+	}
+	for state, n := range states {
+		s.registry.Reservations.WithLabelValues(state).Set(float64(n))
+	}
+
+	// Flag every known node, not only reserved ones, so dashboards can join on it
+	if nodes := s.Snapshot().Nodes; nodes != nil {
+		for name := range nodes.Nodes {
+			s.registry.NodeInReservation.WithLabelValues(name).Set(boolValue(held[name]))
+		}
+	}
+	for name := range held {
+		s.registry.NodeInReservation.WithLabelValues(name).Set(1)
+	}
+}
+
+// boolValue converts a flag to a gauge value
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
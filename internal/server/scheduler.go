@@ -0,0 +1,35 @@
+package server
+
+import (
+	"log"
+
+	"pbs-exporter/internal/schedlog"
+)
+
+// SetSchedulerLogReader enables scheduler log ingestion
+func (s *Server) SetSchedulerLogReader(r *schedlog.Reader) {
+	s.schedLog = r
+}
+
+// updateSchedulerMetrics feeds the scheduling cycles logged since the last run into the histograms
+func (s *Server) updateSchedulerMetrics() error {
+	// Cycles read before an error are still counted
+	stats, err := s.schedLog.Read()
+	if err != nil {
+		log.Printf("Error reading scheduler log: %v", err)
+	}
+	s.registry.SchedLogParseErrors.Add(float64(stats.ParseErrors))
+
+	for _, c := range stats.Cycles {
+		s.registry.SchedCycleDuration.Observe(c.Duration().Seconds())
+		// Without debug logging no job is ever logged as considered
+		if c.ConsideredLogged {
+			s.registry.SchedJobsConsidered.Observe(float64(c.Considered))
+		}
+		s.registry.SchedJobsRun.Observe(float64(c.Run))
+	}
+	for reason, n := range stats.NotRunning {
+		s.registry.SchedJobsNotRunning.WithLabelValues(reason).Add(float64(n))
+	}
+	return err
+}
@@ -1,14 +1,46 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"pbs-exporter/internal/accounting"
+	"pbs-exporter/internal/events"
 	"pbs-exporter/internal/metrics"
 	"pbs-exporter/internal/pbs"
+	"pbs-exporter/internal/schedlog"
+	"pbs-exporter/internal/store"
 )
 
+// DefaultInterval is how often collectors run unless configured otherwise
+const DefaultInterval = 60 * time.Second
+
 // Server handles the HTTP server and metrics coordination
 type Server struct {
 	registry *metrics.Registry
 	pbsClient *pbs.Client
+	accounting *accounting.Reader
+	schedLog *schedlog.Reader
+	history *historyTracker
+	tracker *events.Tracker
+	broker *events.Broker
+	store *store.Store
+	cluster string
+	collectors map[string]bool
+	interval time.Duration
+	intervals map[string]time.Duration
+
+	mu       sync.RWMutex
+	snapshot Snapshot
+	// failed holds the collectors whose last run failed
+	failed map[string]bool
+	// recordedAt is when the snapshot was last passed to the store
+	recordedAt time.Time
 }
 
 // New creates a new server instance
@@ -16,72 +48,384 @@ func New(registry *metrics.Registry, pbsClient *pbs.Client) *Server {
 	return &Server{
 		registry:  registry,
 		pbsClient: pbsClient,
+		tracker:   events.NewTracker(),
+		interval:  DefaultInterval,
+	}
+}
+
+// collector is one independently scheduled part of the collection. name is
+// the metrics group it fills; command is the PBS command it runs, empty for
+// collectors that only read local files.
+type collector struct {
+	name    string
+	command string
+	update  func() error
+}
+
+// enabledCollectors returns the collectors configured on this server
+func (s *Server) enabledCollectors() []collector {
+	all := []collector{
+		{metrics.GroupJobs, "qstat -f", s.updateJobMetrics},
+		{metrics.GroupNodes, "pbsnodes", s.updateNodeMetrics},
+		{metrics.GroupQueues, "qstat -q", s.updateQueueSummaryMetrics},
+		{metrics.GroupServer, "qstat -B", s.updateServerMetrics},
+	}
+	if s.history != nil {
+		all = append(all, collector{metrics.GroupHistory, "qstat -x", s.updateHistoryMetrics})
+	}
+	if s.accounting != nil {
+		all = append(all, collector{metrics.GroupAccounting, "", s.updateAccountingMetrics})
+	}
+	if s.schedLog != nil {
+		all = append(all, collector{metrics.GroupScheduler, "", s.updateSchedulerMetrics})
+	}
+	// Reservations are opt-in
+	if s.collectors[metrics.GroupReservations] {
+		all = append(all, collector{metrics.GroupReservations, "pbs_rstat", s.updateReservationMetrics})
+	}
+
+	if s.collectors == nil {
+		return all
+	}
+	enabled := all[:0]
+	for _, c := range all {
+		if s.collectors[c.name] {
+			enabled = append(enabled, c)
+		}
 	}
+	return enabled
 }
 
-// UpdateMetrics updates all metrics by fetching and parsing PBS data
-func (s *Server) UpdateMetrics() {
-	// Update job metrics
-	s.updateJobMetrics()
-	
-	// Update node metrics
-	s.updateNodeMetrics()
+// UpdateMetrics runs every enabled collector once, concurrently, and records
+// the collection summary when they have all finished. It returns the errors
+// of the collectors that failed.
+func (s *Server) UpdateMetrics() error {
+	collectors := s.enabledCollectors()
+	errs := make([]error, len(collectors))
+	var wg sync.WaitGroup
+	for i, c := range collectors {
+		wg.Add(1)
+		go func(i int, c collector) {
+			defer wg.Done()
+			if err := s.runCollector(c); err != nil {
+				errs[i] = fmt.Errorf("%s collector: %w", c.name, err)
+			}
+		}(i, c)
+	}
+	wg.Wait()
 
-	// Update qstat -q summary metrics
-	s.updateQueueSummaryMetrics()
+	// Persist the collection summary
+	if s.store != nil {
+		s.recordSummary()
+	}
+	return errors.Join(errs...)
 }
 
-// updateJobMetrics updates job-related metrics
-func (s *Server) updateJobMetrics() {
-	// Reset job metrics
-	s.registry.ResetJobMetrics()
+// Run collects until ctx is done, each collector on its own interval. The
+// summary is recorded on the default interval. Call UpdateMetrics first for
+// the initial collection; Run waits one interval before each collector's first run.
+func (s *Server) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	every := func(interval time.Duration, f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					f()
+				}
+			}
+		}()
+	}
+
+	for _, c := range s.enabledCollectors() {
+		c := c
+		every(s.collectorInterval(c.name), func() { s.runCollector(c) })
+	}
+	if s.store != nil {
+		every(s.interval, s.recordSummary)
+	}
+	wg.Wait()
+}
+
+// runCollector runs one collector and records its outcome
+func (s *Server) runCollector(c collector) error {
+	start := time.Now()
+	err := c.update()
+	s.registry.CollectorDuration.WithLabelValues(c.name).Set(time.Since(start).Seconds())
+
+	if err != nil {
+		s.registry.CollectorSuccess.WithLabelValues(c.name).Set(0)
+		if c.command != "" {
+			s.registry.CommandErrors.WithLabelValues(c.command).Inc()
+		}
+	} else {
+		s.registry.CollectorSuccess.WithLabelValues(c.name).Set(1)
+	}
+
+	// The server is up while the last run of every collector querying it succeeded
+	if c.command != "" {
+		if s.storeStatus(c.name, err == nil) {
+			s.registry.Up.Set(1)
+		} else {
+			s.registry.Up.Set(0)
+		}
+	}
+	return err
+}
+
+// SetInterval sets how often Run runs collectors without an interval of their own
+func (s *Server) SetInterval(interval time.Duration) {
+	s.interval = interval
+}
+
+// SetCollectorInterval makes Run run the named collector every interval
+func (s *Server) SetCollectorInterval(name string, interval time.Duration) {
+	if s.intervals == nil {
+		s.intervals = make(map[string]time.Duration)
+	}
+	s.intervals[name] = interval
+}
+
+// collectorInterval returns how often Run runs the named collector
+func (s *Server) collectorInterval(name string) time.Duration {
+	if interval := s.intervals[name]; interval > 0 {
+		return interval
+	}
+	return s.interval
+}
+
+// SetCollectors limits collection to the collectors filling the given metrics
+// groups (see metrics.DefaultGroups and the other metrics.Group constants)
+func (s *Server) SetCollectors(groups []string) {
+	s.collectors = make(map[string]bool, len(groups))
+	for _, g := range groups {
+		s.collectors[g] = true
+	}
+}
+
+// SetCluster names the cluster this server collects from, for events and stored history
+func (s *Server) SetCluster(name string) {
+	s.cluster = name
+}
+
+// Cluster returns the cluster name, empty when only one PBS server is monitored
+func (s *Server) Cluster() string {
+	return s.cluster
+}
+
+// SetEventBroker publishes snapshot diff events to the broker
+func (s *Server) SetEventBroker(b *events.Broker) {
+	s.broker = b
+}
+
+// SetAccountingReader enables accounting log ingestion
+func (s *Server) SetAccountingReader(r *accounting.Reader) {
+	s.accounting = r
+}
+
+// updateAccountingMetrics feeds new accounting records into the counters
+func (s *Server) updateAccountingMetrics() error {
+	// Records read before an error are still counted
+	records, parseErrors, err := s.accounting.Read()
+	if err != nil {
+		log.Printf("Error reading accounting log: %v", err)
+	}
+	s.registry.AccountingParseErrors.Add(float64(parseErrors))
+
+	for _, rec := range records {
+		s.registry.AccountingRecords.WithLabelValues(rec.Type).Inc()
+
+		queue := rec.Queue
+		if queue == "" {
+			queue = "unknown"
+		}
+		user := s.pbsClient.UserLabel(rec.User)
+		// With -user-privacy=drop the accounting counters have no user label
+		labels := []string{queue, user}
+		if s.registry.UsersDropped() {
+			labels = []string{queue}
+		}
+
+		var event string
+		switch rec.Type {
+		case accounting.TypeQueued:
+			event = "queued"
+		case accounting.TypeStarted:
+			event = "started"
+		case accounting.TypeDeleted:
+			event = "deleted"
+		case accounting.TypeAborted:
+			event = "aborted"
+		case accounting.TypeEnded:
+			event = "completed"
+			if code, ok := rec.ExitStatus(); ok {
+				class := pbs.ExitClass(code)
+				// Jobs killed by a signal or by PBS for exceeding their walltime or memory
+				switch class {
+				case pbs.ExitClassSignal, pbs.ExitClassWalltime, pbs.ExitClassMemory:
+					event = "killed"
+				}
+				s.recordJobExit(queue, user, class)
+				s.registry.AccountingExitStatus.WithLabelValues(queue, strconv.Itoa(code)).Inc()
+			}
+			s.registry.AccountingCPUHours.WithLabelValues(labels...).Add(rec.CPUHours())
+			s.registry.AccountingGPUHours.WithLabelValues(labels...).Add(rec.GPUHours())
+		}
+		if event != "" {
+			s.registry.AccountingJobEvents.WithLabelValues(append(labels, event)...).Inc()
+		}
+	}
+	return err
+}
 
-	// Get qstat output
-	output, err := s.pbsClient.GetQstatOutput()
+// updateJobMetrics updates job-related metrics
+func (s *Server) updateJobMetrics() error {
+	// Get qstat -f output (full attributes are needed for array progress)
+	output, err := s.pbsClient.GetQstatFullOutput()
 	if err != nil {
-		return
+		s.registry.Update(s.registry.ResetJobMetrics)
+		return err
 	}
 
 	// Parse job data
-	jobData := s.pbsClient.ParseQstatOutput(output)
+	jobData := s.pbsClient.ParseQstatFullOutput(output)
+	now := time.Now()
+	oldest := make(map[string]time.Duration, len(jobData.OldestQueued))
+	for queue, qtime := range jobData.OldestQueued {
+		oldest[queue] = now.Sub(qtime)
+	}
+
+	// Replace the job metrics with the parsed data
+	s.registry.Update(func() {
+		s.registry.ResetJobMetrics()
+		s.updateJobMetricsFromData(jobData)
+		// Track how long the oldest job in each queue has been waiting
+		for queue, wait := range oldest {
+			s.registry.QueueOldestJobWait.WithLabelValues(queue).Set(wait.Seconds())
+		}
+	})
+	s.storeJobs(jobData)
 
-	// Update metrics with parsed data
-	s.updateJobMetricsFromData(jobData)
+	// Compare with the previous snapshot
+	s.handleEvents(s.tracker.ObserveJobs(jobData.Jobs, now))
+	s.handleEvents(s.tracker.ObserveQueueWaits(oldest, now))
+	return nil
 }
 
-// updateNodeMetrics updates node-related metrics
-func (s *Server) updateNodeMetrics() {
-	// Reset node metrics
-	s.registry.ResetNodeMetrics()
+// SetQueueWaitThreshold raises queue_wait_exceeded events when a queue's oldest job waits longer than threshold
+func (s *Server) SetQueueWaitThreshold(threshold time.Duration) {
+	s.tracker.QueueWaitThreshold = threshold
+}
+
+// updateServerMetrics updates server-level metrics from `qstat -B -f`
+func (s *Server) updateServerMetrics() error {
+	output, err := s.pbsClient.GetQstatBOutput()
+	if err != nil {
+		return err
+	}
+	status := s.pbsClient.ParseQstatBOutput(output)
+
+	scheduling := 0.0
+	if status.Scheduling {
+		scheduling = 1
+	}
+	s.registry.ServerScheduling.Set(scheduling)
+	s.storeServer(status)
+
+	s.handleEvents(s.tracker.ObserveServer(status, time.Now()))
+	return nil
+}
 
+// updateNodeMetrics updates node-related metrics
+func (s *Server) updateNodeMetrics() error {
 	// Get pbsnodes output
 	output, err := s.pbsClient.GetPbsnodesOutput()
 	if err != nil {
-		return
+		s.registry.Update(s.registry.ResetNodeMetrics)
+		return err
 	}
 
 	// Parse node data
 	nodeData := s.pbsClient.ParsePbsnodesOutput(output)
 
-	// Update metrics with parsed data
-	s.updateNodeMetricsFromData(nodeData)
+	// Replace the node metrics with the parsed data
+	s.registry.Update(func() {
+		s.registry.ResetNodeMetrics()
+		s.updateNodeMetricsFromData(nodeData)
+	})
+	s.storeNodes(nodeData)
+
+	// Compare with the previous snapshot
+	s.handleEvents(s.tracker.ObserveNodes(nodeData.Nodes, time.Now()))
+	return nil
+}
+
+// handleEvents updates the transition counters from snapshot diffs and publishes the events
+func (s *Server) handleEvents(evs []events.Event) {
+	if s.cluster != "" {
+		for i := range evs {
+			evs[i].Cluster = s.cluster
+			evs[i].Message = "[" + s.cluster + "] " + evs[i].Message
+		}
+	}
+	if s.broker != nil && len(evs) > 0 {
+		s.broker.Publish(evs...)
+	}
+
+	for _, ev := range evs {
+		switch ev.Type {
+		case events.TypeJobStarted:
+			s.registry.JobsStarted.WithLabelValues(ev.Queue).Inc()
+		case events.TypeJobCompleted:
+			s.registry.JobsCompleted.WithLabelValues(ev.Queue).Inc()
+		case events.TypeJobRequeued:
+			s.registry.JobsRequeued.WithLabelValues(ev.Queue).Inc()
+		case events.TypeNodeStateChanged:
+			s.registry.NodeStateTransitions.WithLabelValues(ev.Node, ev.From, ev.To).Inc()
+		case events.TypeNodeFlap:
+			s.registry.NodeFlaps.WithLabelValues(ev.Node).Inc()
+		}
+	}
 }
 
 // updateQueueSummaryMetrics updates totals from `qstat -q`
-func (s *Server) updateQueueSummaryMetrics() {
+func (s *Server) updateQueueSummaryMetrics() error {
 	output, err := s.pbsClient.GetQstatQOutput()
 	if err != nil {
-		return
+		return err
 	}
 	running, queued := s.pbsClient.ParseQstatQSummary(output)
 	s.registry.QueueSummaryRunning.Set(float64(running))
 	s.registry.QueueSummaryQueued.Set(float64(queued))
 
 	// Per-queue values (only queued)
-	_, queByQ := s.pbsClient.ParseQstatQPerQueue(output)
+	runByQ, queByQ := s.pbsClient.ParseQstatQPerQueue(output)
 	for q, v := range queByQ {
 		s.registry.QueueQueuedByQueue.WithLabelValues(q).Set(float64(v))
 	}
+
+	states := s.pbsClient.ParseQstatQStates(output)
+	queues := make(map[string]QueueInfo, len(queByQ))
+	for q := range queByQ {
+		queues[q] = QueueInfo{Running: runByQ[q], Queued: queByQ[q], State: states[q]}
+	}
+	s.storeQueues(queues)
+
+	// Compare queue enabled/started states with the previous snapshot
+	s.handleEvents(s.tracker.ObserveQueues(states, time.Now()))
+	return nil
+}
+
+// recordJobExit counts a finished job in the exit class counters
+func (s *Server) recordJobExit(queue, user, class string) {
+	s.registry.JobExits.WithLabelValues(queue, class).Inc()
+	s.registry.JobExitsByUser.WithLabelValues(queue, user, class).Inc()
 }
 
 // updateJobMetricsFromData updates job metrics from parsed data
@@ -91,9 +435,26 @@ func (s *Server) updateJobMetricsFromData(data *pbs.JobData) {
 		s.registry.RunningJobsByUser.WithLabelValues(user).Set(float64(count))
 	}
 
+	// Update per-user demand metrics
+	for user, u := range data.UserUsage {
+		s.registry.QueuedJobsByUser.WithLabelValues(user).Set(float64(u.Queued))
+		s.registry.HeldJobsByUser.WithLabelValues(user).Set(float64(u.Held))
+		s.registry.UserAllocatedCpus.WithLabelValues(user).Set(float64(u.RunningNCPUs))
+		s.registry.UserAllocatedGpus.WithLabelValues(user).Set(float64(u.RunningNGPUs))
+		s.registry.UserAllocatedMemory.WithLabelValues(user).Set(u.RunningMemGB)
+		s.registry.UserRequestedCpus.WithLabelValues(user).Set(float64(u.QueuedNCPUs))
+		s.registry.UserRequestedGpus.WithLabelValues(user).Set(float64(u.QueuedNGPUs))
+		s.registry.UserRequestedMemory.WithLabelValues(user).Set(u.QueuedMemGB)
+	}
+
+	// Update per-project, per-account, per-group and per-department aggregations
+	setUsage(s.registry.ProjectUsage, data.ProjectUsage)
+	setUsage(s.registry.AccountUsage, data.AccountUsage)
+	setUsage(s.registry.GroupUsage, data.GroupUsage)
+	setUsage(s.registry.DepartmentUsage, data.DepartmentUsage)
+
 	// Update queue metrics
-	queues := []string{"interactive", "medium", "long", "large", "small", "special", "AISG_debug", "AISG_large", "AISG_guest"}
-	for _, queue := range queues {
+	for _, queue := range pbs.DefaultQueues {
 		s.registry.RunningJobsByQueue.WithLabelValues(queue).Set(float64(data.QueueJobCount[queue]))
 		s.registry.JobsInQueue.WithLabelValues(queue).Set(float64(data.QueueTotalCount[queue]))
 	}
@@ -112,6 +473,29 @@ func (s *Server) updateJobMetricsFromData(data *pbs.JobData) {
 	s.registry.TotalEJobs.Set(float64(data.TotalE))
 	s.registry.TotalBJobs.Set(float64(data.TotalB))
 	s.registry.TotalAllJobs.Set(float64(data.TotalAll))
+
+	// Update array job progress
+	for id, a := range data.Arrays {
+		states := map[string]int{
+			"queued":   a.Queued,
+			"running":  a.Running,
+			"held":     a.Held,
+			"exiting":  a.Exiting,
+			"finished": a.Finished,
+			"failed":   a.Failed,
+		}
+		for state, count := range states {
+			s.registry.ArraySubjobs.WithLabelValues(id, a.Owner, a.Queue, state).Set(float64(count))
+		}
+		s.registry.ArraySubjobsSubmitted.WithLabelValues(id, a.Owner, a.Queue).Set(float64(a.Total))
+	}
+}
+
+// setUsage copies aggregated usage into a set of usage gauges
+func setUsage(g *metrics.UsageGauges, usage map[string]*pbs.Usage) {
+	for key, u := range usage {
+		g.Set(key, u.Running, u.Queued, u.RunningNCPUs, u.RunningNGPUs, u.RunningMemGB)
+	}
 }
 
 // updateNodeMetricsFromData updates node metrics from parsed data
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"pbs-exporter/internal/accounting"
+	"pbs-exporter/internal/logtail"
+	"pbs-exporter/internal/metrics"
+	"pbs-exporter/internal/pbs"
+	"pbs-exporter/internal/privacy"
+)
+
+// accountingServer returns a server tailing an accounting log in a temporary
+// directory, and a function appending records to it
+func accountingServer(t *testing.T, registry *metrics.Registry, client *pbs.Client) (*Server, func(records string)) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, logtail.FileName(time.Now()))
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry.EnableAccountingMetrics()
+	s := New(registry, client)
+	reader := accounting.NewReader(dir)
+	t.Cleanup(func() { reader.Close() })
+	s.SetAccountingReader(reader)
+	// The first read starts at the end of the log
+	if err := s.updateAccountingMetrics(); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func(records string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(records); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// labelNames returns the sorted label names of every series of the named family
+func labelNames(t *testing.T, registry *metrics.Registry, name string) []string {
+	t.Helper()
+	families, err := registry.Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var series []string
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName())
+			}
+			sort.Strings(labels)
+			series = append(series, strings.Join(labels, ","))
+		}
+	}
+	return series
+}
+
+func TestAccountingUserLabel(t *testing.T) {
+	const records = `10/18/2026 09:00:00;Q;1.pbs01;user=alice queue=long
+10/18/2026 10:00:00;E;1.pbs01;user=alice queue=long Resource_List.ncpus=8 resources_used.walltime=01:00:00 Exit_status=0
+`
+	tests := []struct {
+		mode       privacy.Mode
+		wantLabels map[string]string
+	}{
+		{privacy.ModeKeep, map[string]string{
+			"pbs_accounting_job_events_total": "event,queue,user",
+			"pbs_accounting_cpu_hours_total":  "queue,user",
+			"pbs_accounting_gpu_hours_total":  "queue,user",
+		}},
+		{privacy.ModeDrop, map[string]string{
+			"pbs_accounting_job_events_total": "event,queue",
+			"pbs_accounting_cpu_hours_total":  "queue",
+			"pbs_accounting_gpu_hours_total":  "queue",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			mapper, err := privacy.NewUserMapper(string(tt.mode), "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := metrics.NewRegistry()
+			client := pbs.NewClient()
+			if tt.mode == privacy.ModeDrop {
+				registry.DropUserMetrics()
+				client.MapUser = mapper.Map
+			}
+
+			s, appendLog := accountingServer(t, registry, client)
+			appendLog(records)
+			if err := s.updateAccountingMetrics(); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.wantLabels {
+				for _, got := range labelNames(t, registry, name) {
+					if got != want {
+						t.Errorf("%s labels = %s, want %s", name, got, want)
+					}
+				}
+			}
+			events := labelNames(t, registry, "pbs_accounting_job_events_total")
+			if len(events) != 2 {
+				t.Errorf("got %d job event series, want queued and completed", len(events))
+			}
+		})
+	}
+}
+
+func TestAccountingEndedEvents(t *testing.T) {
+	tests := []struct {
+		exit  string
+		event string
+		class string
+	}{
+		{"Exit_status=0", "completed", pbs.ExitClassSuccess},
+		{"Exit_status=1", "completed", pbs.ExitClassNonZero},
+		{"Exit_status=271", "killed", pbs.ExitClassSignal},
+		{"Exit_status=-29", "killed", pbs.ExitClassWalltime},
+		{"Exit_status=-27", "killed", pbs.ExitClassMemory},
+		{"Exit_status=-26", "killed", pbs.ExitClassMemory},
+		{"Exit_status=-20", "completed", pbs.ExitClassNodeFailure},
+		{"", "completed", ""},
+	}
+	for _, tt := range tests {
+		name := tt.exit
+		if name == "" {
+			name = "no Exit_status"
+		}
+		t.Run(name, func(t *testing.T) {
+			registry := metrics.NewRegistry()
+			s, appendLog := accountingServer(t, registry, pbs.NewClient())
+			appendLog("10/18/2026 10:00:00;E;1.pbs01;user=alice queue=long " + tt.exit + "\n")
+			if err := s.updateAccountingMetrics(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := metricValue(t, registry.AccountingJobEvents.WithLabelValues("long", "alice", tt.event)); got != 1 {
+				t.Errorf("%s events = %v, want 1", tt.event, got)
+			}
+			if tt.class != "" {
+				if got := metricValue(t, registry.JobExits.WithLabelValues("long", tt.class)); got != 1 {
+					t.Errorf("%s exits = %v, want 1", tt.class, got)
+				}
+			}
+		})
+	}
+}
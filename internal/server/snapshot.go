@@ -0,0 +1,97 @@
+package server
+
+import (
+	"time"
+
+	"pbs-exporter/internal/pbs"
+)
+
+// QueueInfo combines a queue's `qstat -q` counts and state
+type QueueInfo struct {
+	Running int
+	Queued  int
+	State   pbs.QueueState
+}
+
+// Snapshot is the most recently collected cluster state. Parts that have not
+// been collected yet (or whose command failed on the first run) are nil.
+type Snapshot struct {
+	Jobs     *pbs.JobData
+	Nodes    *pbs.NodeData
+	Queues   map[string]QueueInfo
+	Server   *pbs.ServerStatus
+	JobsAt   time.Time
+	NodesAt  time.Time
+	QueuesAt time.Time
+	ServerAt time.Time
+	// Reservations are only collected when the reservations collector is enabled
+	Reservations   []pbs.Reservation
+	ReservationsAt time.Time
+	// Up is whether the last run of every collector querying the server succeeded
+	Up          bool
+	CollectedAt time.Time
+}
+
+// Snapshot returns the last collected cluster state. The returned data must not be modified.
+func (s *Server) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot
+}
+
+// storeJobs records the latest job data
+func (s *Server) storeJobs(data *pbs.JobData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.Jobs = data
+	s.snapshot.JobsAt = time.Now()
+}
+
+// storeNodes records the latest node data
+func (s *Server) storeNodes(data *pbs.NodeData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.Nodes = data
+	s.snapshot.NodesAt = time.Now()
+}
+
+// storeQueues records the latest `qstat -q` data
+func (s *Server) storeQueues(queues map[string]QueueInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.Queues = queues
+	s.snapshot.QueuesAt = time.Now()
+}
+
+// storeServer records the latest server status
+func (s *Server) storeServer(status *pbs.ServerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.Server = status
+	s.snapshot.ServerAt = time.Now()
+}
+
+// storeReservations records the latest `pbs_rstat -f` data
+func (s *Server) storeReservations(resvs []pbs.Reservation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.Reservations = resvs
+	s.snapshot.ReservationsAt = time.Now()
+}
+
+// storeStatus records the outcome of a collector run and returns whether the server is up
+func (s *Server) storeStatus(collector string, ok bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed == nil {
+		s.failed = make(map[string]bool)
+	}
+	if ok {
+		delete(s.failed, collector)
+	} else {
+		s.failed[collector] = true
+	}
+	s.snapshot.Up = len(s.failed) == 0
+	s.snapshot.CollectedAt = time.Now()
+	return s.snapshot.Up
+}
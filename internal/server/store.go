@@ -0,0 +1,132 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"pbs-exporter/internal/store"
+)
+
+// SetStore persists a summary of every collection to the store
+func (s *Server) SetStore(st *store.Store) {
+	s.store = st
+}
+
+// Store returns the history store, or nil when it is disabled
+func (s *Server) Store() *store.Store {
+	return s.store
+}
+
+// recordSummary stores per-queue, per-user and per-node-state counts and the
+// cluster utilization from the snapshot just collected. Parts whose collector
+// failed still hold the previous data; they are left out rather than stored
+// again under a new timestamp, and nothing is stored when no part is new.
+func (s *Server) recordSummary() {
+	samples := summarySamples(s.unrecordedSnapshot())
+	if len(samples) == 0 {
+		return
+	}
+	if err := s.store.Record(time.Now(), s.cluster, samples); err != nil {
+		log.Printf("Error recording history: %v", err)
+	}
+}
+
+// unrecordedSnapshot returns the parts of the snapshot collected since the previous call
+func (s *Server) unrecordedSnapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := s.recordedAt
+	s.recordedAt = time.Now()
+
+	var snap Snapshot
+	if s.snapshot.JobsAt.After(since) {
+		snap.Jobs = s.snapshot.Jobs
+	}
+	if s.snapshot.NodesAt.After(since) {
+		snap.Nodes = s.snapshot.Nodes
+	}
+	if s.snapshot.QueuesAt.After(since) {
+		snap.Queues = s.snapshot.Queues
+	}
+	return snap
+}
+
+// summarySamples flattens a snapshot into store samples
+func summarySamples(snap Snapshot) []store.Sample {
+	var samples []store.Sample
+	add := func(kind, name, metric string, v float64) {
+		samples = append(samples, store.Sample{Kind: kind, Name: name, Metric: metric, Value: v})
+	}
+
+	if jobs := snap.Jobs; jobs != nil {
+		add(store.KindCluster, "", "jobs_running", float64(jobs.TotalR))
+		add(store.KindCluster, "", "jobs_queued", float64(jobs.TotalQ))
+		add(store.KindCluster, "", "jobs_held", float64(jobs.TotalH))
+
+		for user, u := range jobs.UserUsage {
+			// Usernames dropped by -user-privacy=drop are not stored
+			if user == "" {
+				continue
+			}
+			add(store.KindUser, user, "running", float64(u.Running))
+			add(store.KindUser, user, "queued", float64(u.Queued))
+			add(store.KindUser, user, "held", float64(u.Held))
+			add(store.KindUser, user, "cpus", float64(u.RunningNCPUs))
+			add(store.KindUser, user, "gpus", float64(u.RunningNGPUs))
+			add(store.KindUser, user, "memory_gb", u.RunningMemGB)
+		}
+		for project, u := range jobs.ProjectUsage {
+			add(store.KindProject, project, "running", float64(u.Running))
+			add(store.KindProject, project, "cpus", float64(u.RunningNCPUs))
+			add(store.KindProject, project, "gpus", float64(u.RunningNGPUs))
+		}
+		for queue, u := range jobs.QueueUsage {
+			add(store.KindQueue, queue, "cpus", float64(u.RunningNCPUs))
+			add(store.KindQueue, queue, "gpus", float64(u.RunningNGPUs))
+		}
+	}
+
+	for name, q := range snap.Queues {
+		add(store.KindQueue, name, "running", float64(q.Running))
+		add(store.KindQueue, name, "queued", float64(q.Queued))
+	}
+
+	if nodes := snap.Nodes; nodes != nil {
+		var cpus, cpusFree, gpus, gpusFree int
+		var mem, memFree float64
+		states := make(map[string]int)
+		for _, n := range nodes.Nodes {
+			states[n.State]++
+			cpus += n.CPUsTotal
+			cpusFree += n.CPUsAvailable
+			gpus += n.GPUsTotal
+			gpusFree += n.GPUsAvailable
+			mem += n.MemoryTotal
+			memFree += n.MemoryAvailable
+		}
+		for state, count := range states {
+			add(store.KindNodeState, state, "nodes", float64(count))
+		}
+
+		add(store.KindCluster, "", "nodes", float64(len(nodes.Nodes)))
+		add(store.KindCluster, "", "cpus_total", float64(cpus))
+		add(store.KindCluster, "", "cpus_used", float64(cpus-cpusFree))
+		add(store.KindCluster, "", "gpus_total", float64(gpus))
+		add(store.KindCluster, "", "gpus_used", float64(gpus-gpusFree))
+		add(store.KindCluster, "", "memory_total_gb", mem)
+		add(store.KindCluster, "", "memory_used_gb", mem-memFree)
+		add(store.KindCluster, "", "cpu_utilization", ratio(float64(cpus-cpusFree), float64(cpus)))
+		add(store.KindCluster, "", "gpu_utilization", ratio(float64(gpus-gpusFree), float64(gpus)))
+		add(store.KindCluster, "", "memory_utilization", ratio(mem-memFree, mem))
+	}
+
+	return samples
+}
+
+// ratio returns used/total, or 0 when there is nothing to use
+func ratio(used, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return used / total
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pbs-exporter/internal/metrics"
+	"pbs-exporter/internal/pbs"
+	"pbs-exporter/internal/store"
+)
+
+// testJobs is job data with one running job of alice
+var testJobs = &pbs.JobData{
+	TotalR:    1,
+	UserUsage: map[string]*pbs.Usage{"alice": {Running: 1, RunningNCPUs: 8}},
+}
+
+// testQueues is `qstat -q` data of one queue
+var testQueues = map[string]QueueInfo{"long": {Running: 1, Queued: 2}}
+
+func TestUnrecordedSnapshot(t *testing.T) {
+	s := New(metrics.NewRegistry(), pbs.NewClient())
+	nodes := &pbs.NodeData{Nodes: map[string]pbs.NodeInfo{"n1": {State: "free"}}}
+
+	// Each step runs the collectors that succeeded, then takes the snapshot to record
+	tests := []struct {
+		name                string
+		collect             func()
+		jobs, nodes, queues bool
+	}{
+		{"first collection", func() { s.storeJobs(testJobs); s.storeQueues(testQueues) }, true, false, true},
+		{"every collector failed", func() {}, false, false, false},
+		{"jobs failed", func() { s.storeQueues(testQueues) }, false, false, true},
+		{"only nodes collected", func() { s.storeNodes(nodes) }, false, true, false},
+		{"all collected", func() { s.storeJobs(testJobs); s.storeNodes(nodes); s.storeQueues(testQueues) }, true, true, true},
+	}
+	for _, tt := range tests {
+		tt.collect()
+		snap := s.unrecordedSnapshot()
+		if (snap.Jobs != nil) != tt.jobs || (snap.Nodes != nil) != tt.nodes || (snap.Queues != nil) != tt.queues {
+			t.Errorf("%s: snapshot has jobs %v, nodes %v, queues %v; want %v, %v, %v", tt.name,
+				snap.Jobs != nil, snap.Nodes != nil, snap.Queues != nil, tt.jobs, tt.nodes, tt.queues)
+		}
+	}
+}
+
+func TestRecordSummarySkipsFailedParts(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "history.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	s := New(metrics.NewRegistry(), pbs.NewClient())
+	s.SetStore(st)
+
+	// collect stores the given parts and records the summary in its own second,
+	// as the store keeps timestamps to the second
+	collect := func(parts ...func()) {
+		time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+		for _, part := range parts {
+			part()
+		}
+		s.recordSummary()
+	}
+	jobs := func() { s.storeJobs(testJobs) }
+	queues := func() { s.storeQueues(testQueues) }
+
+	start := time.Now().Add(-time.Second)
+	collect(jobs, queues)
+	collect(queues)
+	collect()
+	collect(jobs)
+
+	tests := []struct {
+		kind, name, metric string
+		want               int
+	}{
+		// The failed jobs collection is not stored again
+		{store.KindCluster, "", "jobs_running", 2},
+		{store.KindUser, "alice", "cpus", 2},
+		{store.KindQueue, "long", "queued", 2},
+		// Nothing is stored when every collector failed
+		{"", "", "", 3},
+	}
+	for _, tt := range tests {
+		times, err := st.Timestamps(store.Query{Kind: tt.kind, Name: tt.name, Metric: tt.metric, From: start, To: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(times) != tt.want {
+			t.Errorf("%s %s %s recorded at %v, want %d collections", tt.kind, tt.name, tt.metric, times, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Sample kinds stored per collection
+const (
+	KindCluster   = "cluster"
+	KindQueue     = "queue"
+	KindUser      = "user"
+	KindProject   = "project"
+	KindNodeState = "node_state"
+)
+
+// pruneInterval is how often samples older than the retention are deleted
+const pruneInterval = time.Hour
+
+const schema = `
+CREATE TABLE IF NOT EXISTS samples (
+	ts      INTEGER NOT NULL,
+	kind    TEXT    NOT NULL,
+	name    TEXT    NOT NULL,
+	metric  TEXT    NOT NULL,
+	value   REAL    NOT NULL,
+	cluster TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS samples_series ON samples (kind, metric, name, ts);
+CREATE INDEX IF NOT EXISTS samples_ts ON samples (ts);
+`
+
+// Sample is one value of a collection summary, e.g. kind "queue", name "gpu", metric "queued"
+type Sample struct {
+	Kind   string
+	Name   string
+	Metric string
+	Value  float64
+}
+
+// Point is a stored value at a time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is the points of one (kind, name, metric)
+type Series struct {
+	Kind   string
+	Name   string
+	Metric string
+	Points []Point
+}
+
+// Query selects stored samples of one cluster (empty when a single PBS server
+// is monitored). Empty Name and Metric match everything of the kind.
+// A non-zero Step averages the samples into buckets of that size.
+type Query struct {
+	Cluster string
+	Kind    string
+	Name    string
+	Metric  string
+	From    time.Time
+	To      time.Time
+	Step    time.Duration
+}
+
+// Store persists collection summaries in a local SQLite database
+type Store struct {
+	db        *sql.DB
+	retention time.Duration
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// Open opens (creating if needed) the database at path. Samples older than
+// retention are deleted; 0 keeps everything.
+func Open(path string, retention time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %w", pragma, err)
+		}
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema in %s: %w", path, err)
+	}
+	if err := addClusterColumn(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("upgrading schema in %s: %w", path, err)
+	}
+
+	return &Store{db: db, retention: retention}, nil
+}
+
+// addClusterColumn upgrades databases created before samples had a cluster
+func addClusterColumn(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('samples')")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == "cluster" {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE samples ADD COLUMN cluster TEXT NOT NULL DEFAULT ''")
+	return err
+}
+
+// Record stores the samples of one collection from cluster and prunes expired data
+func (s *Store) Record(ts time.Time, cluster string, samples []Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO samples (ts, kind, name, metric, value, cluster) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	unix := ts.Unix()
+	for _, sm := range samples {
+		if _, err := stmt.Exec(unix, sm.Kind, sm.Name, sm.Metric, sm.Value, cluster); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if s.retention > 0 && ts.Sub(s.lastPrune) >= pruneInterval {
+		if _, err := s.db.Exec("DELETE FROM samples WHERE ts < ?", ts.Add(-s.retention).Unix()); err != nil {
+			return fmt.Errorf("pruning samples: %w", err)
+		}
+		s.lastPrune = ts
+	}
+	return nil
+}
+
+// Query returns the matching series ordered by kind, metric and name
+func (s *Store) Query(q Query) ([]Series, error) {
+	where := []string{"cluster = ?", "kind = ?", "ts >= ?", "ts <= ?"}
+	args := []interface{}{q.Cluster, q.Kind, q.From.Unix(), q.To.Unix()}
+	if q.Name != "" {
+		where = append(where, "name = ?")
+		args = append(args, q.Name)
+	}
+	if q.Metric != "" {
+		where = append(where, "metric = ?")
+		args = append(args, q.Metric)
+	}
+
+	bucket := "ts"
+	if step := int64(q.Step / time.Second); step > 1 {
+		bucket = fmt.Sprintf("(ts / %d) * %d", step, step)
+	}
+	query := fmt.Sprintf(`SELECT name, metric, %s AS bucket, AVG(value) FROM samples
+		WHERE %s GROUP BY metric, name, bucket ORDER BY metric, name, bucket`,
+		bucket, strings.Join(where, " AND "))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []Series
+	for rows.Next() {
+		var name, metric string
+		var ts int64
+		var value float64
+		if err := rows.Scan(&name, &metric, &ts, &value); err != nil {
+			return nil, err
+		}
+		if n := len(series); n == 0 || series[n-1].Name != name || series[n-1].Metric != metric {
+			series = append(series, Series{Kind: q.Kind, Name: name, Metric: metric})
+		}
+		cur := &series[len(series)-1]
+		cur.Points = append(cur.Points, Point{Time: time.Unix(ts, 0), Value: value})
+	}
+	return series, rows.Err()
+}
+
+// Timestamps returns, in order, the times at which samples matching q were
+// recorded. Empty Kind, Name and Metric match everything; Step is ignored.
+func (s *Store) Timestamps(q Query) ([]time.Time, error) {
+	where := []string{"cluster = ?", "ts >= ?", "ts <= ?"}
+	args := []interface{}{q.Cluster, q.From.Unix(), q.To.Unix()}
+	for column, value := range map[string]string{"kind": q.Kind, "name": q.Name, "metric": q.Metric} {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+
+	rows, err := s.db.Query("SELECT DISTINCT ts FROM samples WHERE "+strings.Join(where, " AND ")+" ORDER BY ts", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var ts int64
+		if err := rows.Scan(&ts); err != nil {
+			return nil, err
+		}
+		times = append(times, time.Unix(ts, 0))
+	}
+	return times, rows.Err()
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
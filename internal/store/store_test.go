@@ -0,0 +1,188 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openStore opens a store in a temporary directory, closed when the test ends
+func openStore(t *testing.T, retention time.Duration) *Store {
+	t.Helper()
+	st, err := Open(filepath.Join(t.TempDir(), "history.db"), retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// record stores samples at start plus the given minutes
+func record(t *testing.T, st *Store, start time.Time, minutes int, cluster string, samples ...Sample) {
+	t.Helper()
+	if err := st.Record(start.Add(time.Duration(minutes)*time.Minute), cluster, samples); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seed stores queue and user samples of the default cluster at 09:00, 09:01
+// and 09:02, a node state sample at 09:03 and a sample of cluster "b" at 09:01
+func seed(t *testing.T, st *Store) time.Time {
+	t.Helper()
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	for m := 0; m < 3; m++ {
+		record(t, st, start, m, "",
+			Sample{Kind: KindQueue, Name: "long", Metric: "queued", Value: float64(m)},
+			Sample{Kind: KindQueue, Name: "long", Metric: "running", Value: 10},
+			Sample{Kind: KindQueue, Name: "gpu", Metric: "queued", Value: float64(2 * m)},
+			Sample{Kind: KindUser, Name: "alice", Metric: "cpus", Value: 8},
+		)
+	}
+	record(t, st, start, 3, "", Sample{Kind: KindNodeState, Name: "free", Metric: "nodes", Value: 4})
+	record(t, st, start, 1, "b", Sample{Kind: KindQueue, Name: "long", Metric: "queued", Value: 100})
+	return start
+}
+
+func TestQuery(t *testing.T) {
+	st := openStore(t, 0)
+	start := seed(t, st)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	points := func(values ...float64) []Point {
+		var points []Point
+		for m, v := range values {
+			points = append(points, Point{Time: at(m), Value: v})
+		}
+		return points
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []Series
+	}{
+		{
+			name: "every queue metric, ordered by metric and name",
+			q:    Query{Kind: KindQueue, From: at(0), To: at(10)},
+			want: []Series{
+				{Kind: KindQueue, Name: "gpu", Metric: "queued", Points: points(0, 2, 4)},
+				{Kind: KindQueue, Name: "long", Metric: "queued", Points: points(0, 1, 2)},
+				{Kind: KindQueue, Name: "long", Metric: "running", Points: points(10, 10, 10)},
+			},
+		},
+		{
+			name: "name and metric",
+			q:    Query{Kind: KindQueue, Name: "long", Metric: "queued", From: at(0), To: at(10)},
+			want: []Series{{Kind: KindQueue, Name: "long", Metric: "queued", Points: points(0, 1, 2)}},
+		},
+		{
+			name: "time range is inclusive",
+			q:    Query{Kind: KindQueue, Name: "gpu", From: at(1), To: at(2)},
+			want: []Series{{Kind: KindQueue, Name: "gpu", Metric: "queued", Points: []Point{{at(1), 2}, {at(2), 4}}}},
+		},
+		{
+			name: "another cluster",
+			q:    Query{Cluster: "b", Kind: KindQueue, From: at(0), To: at(10)},
+			want: []Series{{Kind: KindQueue, Name: "long", Metric: "queued", Points: []Point{{at(1), 100}}}},
+		},
+		{
+			name: "step averages buckets",
+			q:    Query{Kind: KindQueue, Name: "gpu", From: at(0), To: at(10), Step: 2 * time.Minute},
+			want: []Series{{Kind: KindQueue, Name: "gpu", Metric: "queued", Points: []Point{{at(0), 1}, {at(2), 4}}}},
+		},
+		{
+			name: "nothing matches",
+			q:    Query{Kind: KindProject, From: at(0), To: at(10)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.Query(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range got {
+				for j := range got[i].Points {
+					got[i].Points[j].Time = got[i].Points[j].Time.UTC()
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	st := openStore(t, 0)
+	start := seed(t, st)
+	at := func(minutes ...int) []time.Time {
+		var times []time.Time
+		for _, m := range minutes {
+			times = append(times, start.Add(time.Duration(m)*time.Minute))
+		}
+		return times
+	}
+	from, to := start, start.Add(10*time.Minute)
+
+	tests := []struct {
+		name string
+		q    Query
+		want []time.Time
+	}{
+		{"everything", Query{From: from, To: to}, at(0, 1, 2, 3)},
+		{"kind", Query{Kind: KindNodeState, From: from, To: to}, at(3)},
+		{"name and metric", Query{Kind: KindQueue, Name: "long", Metric: "running", From: from, To: to}, at(0, 1, 2)},
+		{"time range", Query{From: from.Add(time.Minute), To: from.Add(2 * time.Minute)}, at(1, 2)},
+		{"another cluster", Query{Cluster: "b", From: from, To: to}, at(1)},
+		{"step is ignored", Query{Kind: KindUser, From: from, To: to, Step: time.Hour}, at(0, 1, 2)},
+		{"nothing matches", Query{Kind: KindProject, From: from, To: to}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.Timestamps(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range got {
+				got[i] = got[i].UTC()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Timestamps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordPrunes(t *testing.T) {
+	st := openStore(t, 2*time.Hour)
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	sample := Sample{Kind: KindCluster, Metric: "jobs_running", Value: 1}
+	kept := func(minutes ...int) {
+		t.Helper()
+		got, err := st.Timestamps(Query{From: start, To: start.Add(24 * time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []time.Time
+		for _, m := range minutes {
+			want = append(want, start.Add(time.Duration(m)*time.Minute))
+		}
+		for i := range got {
+			got[i] = got[i].UTC()
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("kept %v, want %v", got, want)
+		}
+	}
+
+	record(t, st, start, 0, "", sample)
+	record(t, st, start, 20, "", sample)
+	record(t, st, start, 130, "", sample)
+	kept(20, 130)
+	// Pruning runs at most once per pruneInterval
+	record(t, st, start, 150, "", sample)
+	kept(20, 130, 150)
+	record(t, st, start, 200, "", sample)
+	kept(130, 150, 200)
+}
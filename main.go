@@ -1,43 +1,415 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 
+	"pbs-exporter/internal/api"
+	"pbs-exporter/internal/dashboard"
+	"pbs-exporter/internal/events"
 	"pbs-exporter/internal/metrics"
+	"pbs-exporter/internal/notify"
+	"pbs-exporter/internal/output"
 	"pbs-exporter/internal/pbs"
 	"pbs-exporter/internal/server"
+	"pbs-exporter/internal/store"
 )
 
 func main() {
-	// Initialize metrics registry
-	registry := metrics.NewRegistry()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			os.Exit(runReport(os.Args[2:]))
+		case "dump":
+			os.Exit(runDump(os.Args[2:]))
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		}
+	}
 
-	// Initialize PBS client
-	pbsClient := pbs.NewClient()
+	collector := registerCollectorFlags(flag.CommandLine)
+	var clusters clusterFlag
+	flag.Var(&clusters, "cluster", "Monitor several PBS servers as name=target, target being a server name or ssh://[user@]host[/server]; adds a cluster label to every metric; may be repeated")
+	eventBuffer := flag.Int("events-buffer", 256, "Events buffered per /events client before further events are dropped")
+	queueWaitThreshold := flag.Duration("queue-wait-threshold", 0, "Raise a queue_wait_exceeded event when a queue's oldest job waits longer than this (0 disables)")
+	var webhooks webhookFlag
+	flag.Var(&webhooks, "webhook", "Webhook target as [json|slack|teams=]URL; may be repeated")
+	webhookDedup := flag.Duration("webhook-dedup-window", 30*time.Minute, "Suppress repeated webhook notifications for the same event within this window")
+	webhookRate := flag.Int("webhook-rate-limit", 20, "Maximum webhook notifications per minute (0 disables the limit)")
+	storeDB := flag.String("store-db", "", "SQLite database to persist each collection's summary for /api/v1/history (empty disables)")
+	storeRetention := flag.Duration("store-retention", 30*24*time.Hour, "How long samples are kept in -store-db (0 keeps everything)")
+	textfileDir := flag.String("textfile-dir", "", "Write metrics to this node_exporter textfile directory after every collection instead of serving HTTP")
+	textfileName := flag.String("textfile-name", "pbs.prom", "File name used in -textfile-dir")
+	pushgatewayURL := flag.String("pushgateway-url", "", "Push metrics to this Pushgateway after every collection, e.g. http://pushgw:9091")
+	pushgatewayJob := flag.String("pushgateway-job", "pbs_exporter", "Job name used for -pushgateway-url")
+	remoteWriteURL := flag.String("remote-write-url", "", "Send metrics with the Prometheus remote-write protocol to this URL after every collection")
+	remoteWriteToken := flag.String("remote-write-bearer-token-file", "", "File holding a bearer token for -remote-write-url")
+	pushLabels := labelFlag{}
+	flag.Var(pushLabels, "push-label", "name=value label identifying this exporter (Pushgateway grouping key, remote-write external label, InfluxDB tag); may be repeated")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Export metrics over OTLP to this collector after every collection: host:port or URL (empty disables)")
+	otlpProtocol := flag.String("otlp-protocol", output.OTLPProtocolGRPC, "OTLP transport: grpc or http")
+	otlpInsecure := flag.Bool("otlp-insecure", false, "Disable TLS for a host:port -otlp-endpoint")
+	otlpHeaders := headerFlag{}
+	flag.Var(otlpHeaders, "otlp-header", "Name=value header sent with OTLP exports, e.g. for authentication; may be repeated")
+	clusterName := flag.String("cluster-name", "", "Cluster name exported as the pbs.cluster OTLP resource attribute (use -cluster to monitor several)")
+	influxURL := flag.String("influx-url", "", "InfluxDB write URL, e.g. http://influx:8086/write?db=pbs or http://influx:8086/api/v2/write?org=hpc&bucket=pbs (empty disables)")
+	influxTokenFile := flag.String("influx-token-file", "", "File holding an InfluxDB API token for -influx-url")
+	influxFile := flag.String("influx-file", "", "Append every collection in InfluxDB line protocol to this file (empty disables)")
+	graphiteAddr := flag.String("graphite-address", "", "Send metrics in Graphite plaintext protocol to this host:port (empty disables)")
+	graphitePrefix := flag.String("graphite-prefix", "pbs", "Prefix of every Graphite metric path")
+	var graphiteTemplates graphiteTemplateFlag
+	flag.Var(&graphiteTemplates, "graphite-template", "Graphite path template as [glob=]template, e.g. 'pbs_node_*=nodes.{node}.{__name__}'; may be repeated, first match wins")
+	graphiteTags := flag.Bool("graphite-tags", false, "Send labels not used by the template as Graphite tags instead of path segments")
+	probeAllowSSH := flag.Bool("probe-allow-ssh", false, "Accept ssh:// targets on /probe (they run ssh to a host named in the request)")
+	apiJobAttributes := flag.Bool("api-job-attributes", false, "Include the raw qstat -f attributes of jobs in /api/v1/jobs (they include paths, the environment and submit arguments)")
+	listenAddr := flag.String("listen-address", "0.0.0.0:8888", "Address to serve metrics, events and the API on (empty disables HTTP)")
+	flag.Parse()
 
-	// Create and configure server
-	srv := server.New(registry, pbsClient)
+	// registry holds the process-wide metrics; with -cluster it is the first
+	// cluster's, whose Prometheus registry is shared by all clusters
+	var registry *metrics.Registry
+	var servers []*server.Server
+	if len(clusters) == 0 {
+		reg, _, srv, err := collector.build()
+		if err != nil {
+			log.Fatal(err)
+		}
+		registry, servers = reg, []*server.Server{srv}
+	} else {
+		if *collector.pbsServer != "" {
+			log.Fatal("-pbs-server cannot be combined with -cluster")
+		}
+		if *collector.accountingDir != "" && len(clusters) > 1 {
+			log.Fatal("-accounting-dir cannot be shared by several clusters")
+		}
+		if *collector.schedLogDir != "" && len(clusters) > 1 {
+			log.Fatal("-sched-log-dir cannot be shared by several clusters")
+		}
+		if *collector.historyState != "" && len(clusters) > 1 {
+			log.Fatal("-history-state-file cannot be shared by several clusters")
+		}
+		root := metrics.NewRoot()
+		for _, c := range clusters {
+			reg := metrics.NewClusterRegistry(root, c.name, collector.collectorNames())
+			_, srv, err := collector.buildFor(reg, c.target)
+			if err != nil {
+				log.Fatal(err)
+			}
+			srv.SetCluster(c.name)
+			if err := collector.enableHistory(reg, srv); err != nil {
+				log.Fatal(err)
+			}
+			collector.enableLogs(reg, srv)
+			if registry == nil {
+				registry = reg
+			}
+			servers = append(servers, srv)
+			log.Printf("Monitoring cluster %s (%s)", c.name, c.target)
+		}
+	}
+	var st *store.Store
+	if *storeDB != "" {
+		var err error
+		if st, err = store.Open(*storeDB, *storeRetention); err != nil {
+			log.Fatalf("Error opening history store: %v", err)
+		}
+		for _, srv := range servers {
+			srv.SetStore(st)
+		}
+	}
 
-	// Start metrics collection in background
-	go func() {
-		// Update immediately on start
-		srv.UpdateMetrics()
+	// ctx is cancelled on SIGINT or SIGTERM to stop collecting and serving
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// shutdown closes the history store once collection has stopped
+	shutdown := func() {
+		if st != nil {
+			if err := st.Close(); err != nil {
+				log.Printf("Error closing history store: %v", err)
+			}
+		}
+		log.Print("Stopped")
+	}
 
-		ticker := time.NewTicker(60 * time.Second)
-		defer ticker.Stop()
+	// Publish snapshot diffs on /events
+	broker := events.NewBroker(*eventBuffer)
+	for _, srv := range servers {
+		srv.SetEventBroker(broker)
+	}
+	registry.EnableEventMetrics(
+		func() float64 { return float64(broker.Subscribers()) },
+		func() float64 { return float64(broker.Published()) },
+		func() float64 { return float64(broker.Dropped()) },
+	)
+
+	// Send webhook notifications for node, queue and server problems
+	for _, srv := range servers {
+		srv.SetQueueWaitThreshold(*queueWaitThreshold)
+	}
+	if len(webhooks) > 0 {
+		notifier := notify.New(webhooks)
+		notifier.DedupWindow = *webhookDedup
+		notifier.RatePerMinute = *webhookRate
+		registry.EnableNotifierMetrics(
+			func() float64 { return float64(notifier.Sent()) },
+			func() float64 { return float64(notifier.Failed()) },
+			func() float64 { return float64(notifier.Suppressed()) },
+			func() float64 { return float64(notifier.Dropped()) },
+		)
+		sub := broker.Subscribe(events.Filter{Types: []string{
+			events.TypeNodeStateChanged,
+			events.TypeSchedulingStopped,
+			events.TypeQueueWaitExceeded,
+		}})
+		go notifier.Run(ctx, sub)
+	}
+
+	// Metrics written somewhere besides /metrics after every collection
+	var sinks []output.Sink
+	if *textfileDir != "" {
+		textfile, err := output.NewTextfile(*textfileDir, *textfileName)
+		if err != nil {
+			log.Fatalf("Invalid -textfile-dir: %v", err)
+		}
+		sinks = append(sinks, textfile)
+	}
+	if *pushgatewayURL != "" {
+		sinks = append(sinks, output.NewPushgateway(*pushgatewayURL, *pushgatewayJob, pushLabels))
+	}
+	if *remoteWriteURL != "" {
+		sinks = append(sinks, output.NewRemoteWrite(*remoteWriteURL, pushLabels, *remoteWriteToken))
+	}
+	if *otlpEndpoint != "" {
+		otlp, err := output.NewOTLP(output.OTLPConfig{
+			Protocol: *otlpProtocol,
+			Endpoint: *otlpEndpoint,
+			Insecure: *otlpInsecure,
+			Headers:  otlpHeaders,
+			Cluster:  *clusterName,
+			Server: func() string {
+				// With several clusters the cluster label already tells the servers apart
+				if len(servers) > 1 {
+					return ""
+				}
+				if status := servers[0].Snapshot().Server; status != nil {
+					return status.Name
+				}
+				return ""
+			},
+		})
+		if err != nil {
+			log.Fatalf("Invalid OTLP settings: %v", err)
+		}
+		sinks = append(sinks, otlp)
+	}
+	if *influxURL != "" {
+		sinks = append(sinks, output.NewInfluxHTTP(*influxURL, pushLabels, *influxTokenFile))
+	}
+	if *influxFile != "" {
+		sinks = append(sinks, output.NewInfluxFile(*influxFile, pushLabels))
+	}
+	if *graphiteAddr != "" {
+		sinks = append(sinks, output.NewGraphite(*graphiteAddr, *graphitePrefix, graphiteTemplates, *graphiteTags))
+	}
+	if len(sinks) > 0 {
+		registry.EnableOutputMetrics()
+	}
+
+	// collect runs the first collection from every cluster, then keeps each
+	// collector on its own interval and writes the sinks on -collection-interval,
+	// until ctx is cancelled
+	collect := func() {
+		// Clusters are collected concurrently so a slow or unreachable server does not hold up the others
+		var wg sync.WaitGroup
+		for _, srv := range servers {
+			wg.Add(1)
+			go func(srv *server.Server) {
+				defer wg.Done()
+				srv.UpdateMetrics()
+			}(srv)
+		}
+		wg.Wait()
+		var runs sync.WaitGroup
+		for _, srv := range servers {
+			runs.Add(1)
+			go func(srv *server.Server) {
+				defer runs.Done()
+				srv.Run(ctx)
+			}(srv)
+		}
+		defer runs.Wait()
 
+		if len(sinks) == 0 {
+			return
+		}
+		output.WriteAll(registry, sinks)
+		ticker := time.NewTicker(*collector.interval)
+		defer ticker.Stop()
 		for {
-			<-ticker.C
-			srv.UpdateMetrics()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				output.WriteAll(registry, sinks)
+			}
+		}
+	}
+
+	// In textfile mode, or with HTTP disabled, no port is opened; collect in the foreground
+	if *textfileDir != "" || *listenAddr == "" {
+		if len(sinks) == 0 {
+			log.Fatal("HTTP is disabled and no output (-textfile-dir, -pushgateway-url, -remote-write-url, -otlp-endpoint, -influx-url, -influx-file, -graphite-address) is configured")
 		}
+		for _, sink := range sinks {
+			log.Printf("Writing metrics to %s every %s", sink.Name(), *collector.interval)
+		}
+		collect()
+		shutdown()
+		return
+	}
+
+	// Start metrics collection in background
+	collected := make(chan struct{})
+	go func() {
+		collect()
+		close(collected)
 	}()
 
 	// Start HTTP server
-	log.Println("PBS cluster monitoring server starting on 0.0.0.0:8888")
-	log.Println("Metrics available at http://0.0.0.0:8888/metrics")
-	log.Fatal(http.ListenAndServe("0.0.0.0:8888", promhttp.HandlerFor(registry.GetRegistry(), promhttp.HandlerOpts{})))
-}
\ No newline at end of file
+	log.Printf("PBS cluster monitoring server starting on %s", *listenAddr)
+	log.Printf("Metrics available at http://%s/metrics", *listenAddr)
+	log.Printf("Events available at http://%s/events", *listenAddr)
+	log.Printf("Probes available at http://%s/probe?target=<server>", *listenAddr)
+	mux := http.NewServeMux()
+	mux.Handle("/events", events.Handler(broker))
+	mux.Handle("/probe", probeHandler(collector, *probeAllowSSH))
+	mux.Handle(api.Prefix, api.Handler(*apiJobAttributes, servers...))
+	mux.Handle(dashboard.Prefix, dashboard.Handler())
+	mux.Handle("/", promhttp.HandlerFor(registry.Gatherer(), promhttp.HandlerOpts{}))
+
+	// Requests share ctx, so /events streams end when the server shuts down
+	httpServer := &http.Server{
+		Addr:        *listenAddr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-collected
+	shutdown()
+}
+
+// webhookFlag collects repeated -webhook flags
+type webhookFlag []notify.Target
+
+func (w *webhookFlag) String() string {
+	return fmt.Sprint(len(*w), " webhook(s)")
+}
+
+func (w *webhookFlag) Set(value string) error {
+	target, err := notify.ParseTarget(value)
+	if err != nil {
+		return err
+	}
+	*w = append(*w, target)
+	return nil
+}
+
+// clusterSpec is one -cluster flag
+type clusterSpec struct {
+	name   string
+	target pbs.Target
+}
+
+// clusterFlag collects repeated -cluster flags in the order given
+type clusterFlag []clusterSpec
+
+func (c *clusterFlag) String() string {
+	return fmt.Sprint(len(*c), " cluster(s)")
+}
+
+func (c *clusterFlag) Set(value string) error {
+	name, spec, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=target, got %q", value)
+	}
+	for _, existing := range *c {
+		if existing.name == name {
+			return fmt.Errorf("cluster %q given twice", name)
+		}
+	}
+	target, err := pbs.ParseTarget(spec)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, clusterSpec{name: name, target: target})
+	return nil
+}
+
+// labelFlag collects repeated name=value flags
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	return fmt.Sprint(len(l), " label(s)")
+}
+
+func (l labelFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || !model.LabelName(name).IsValid() {
+		return fmt.Errorf("expected name=value with a valid label name, got %q", value)
+	}
+	l[name] = val
+	return nil
+}
+
+// headerFlag collects repeated name=value header flags
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	return fmt.Sprint(len(h), " header(s)")
+}
+
+func (h headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(val)
+	return nil
+}
+
+// graphiteTemplateFlag collects repeated -graphite-template flags
+type graphiteTemplateFlag []output.GraphiteTemplate
+
+func (g *graphiteTemplateFlag) String() string {
+	return fmt.Sprint(len(*g), " template(s)")
+}
+
+func (g *graphiteTemplateFlag) Set(value string) error {
+	t, err := output.ParseGraphiteTemplate(value)
+	if err != nil {
+		return err
+	}
+	*g = append(*g, t)
+	return nil
+}
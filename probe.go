@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"pbs-exporter/internal/metrics"
+	"pbs-exporter/internal/pbs"
+)
+
+// probeTimeoutOffset leaves Prometheus time to receive the response before its scrape timeout
+const probeTimeoutOffset = 500 * time.Millisecond
+
+// probeHandler serves /probe?target=<server>&module=<collectors>. Like the
+// blackbox and snmp exporters it collects from the target on demand and
+// returns only that server's metrics, so Prometheus service discovery
+// decides which PBS servers are monitored.
+func probeHandler(collector *collectorFlags, allowSSH bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("target") == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		target, err := pbs.ParseTarget(q.Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if target.SSHHost != "" && !allowSSH {
+			http.Error(w, "ssh targets are not allowed (see -probe-allow-ssh)", http.StatusForbidden)
+			return
+		}
+		groups, err := parseProbeModule(q.Get("module"), collector.collectorNames())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		registry := metrics.NewRegistryFor(groups)
+		pbsClient, srv, err := collector.buildFor(registry, target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if timeout := scrapeTimeout(r); timeout > 0 && (pbsClient.Timeout == 0 || timeout < pbsClient.Timeout) {
+			pbsClient.Timeout = timeout
+		}
+		srv.SetCollectors(groups)
+		srv.UpdateMetrics()
+
+		promhttp.HandlerFor(registry.Gatherer(), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// parseProbeModule resolves the module parameter: empty or "default" selects
+// every enabled collector, otherwise it is a comma-separated list of them.
+// Accounting and scheduler logs are local files and never part of a probe.
+// Nor is history: its counters would restart with every probe's new registry.
+func parseProbeModule(module string, enabled []string) ([]string, error) {
+	var available []string
+	for _, name := range enabled {
+		switch name {
+		case metrics.GroupAccounting, metrics.GroupScheduler, metrics.GroupHistory:
+		default:
+			available = append(available, name)
+		}
+	}
+	if module == "" || module == "default" {
+		return available, nil
+	}
+
+	var groups []string
+	for _, name := range strings.Split(module, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, a := range available {
+			found = found || a == name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown or disabled module %q (expected default or a list of %s)", name, strings.Join(available, ", "))
+		}
+		groups = append(groups, name)
+	}
+	return groups, nil
+}
+
+// scrapeTimeout returns the time left for the probe from Prometheus' scrape timeout header, or 0
+func scrapeTimeout(r *http.Request) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	timeout := time.Duration(seconds*float64(time.Second)) - probeTimeoutOffset
+	if timeout <= 0 {
+		return 0
+	}
+	return timeout
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeLeavesHistoryStateAlone(t *testing.T) {
+	// No PBS commands: the probe reports the failed collectors
+	t.Setenv("PATH", t.TempDir())
+	state := filepath.Join(t.TempDir(), "history.state")
+	if err := os.WriteFile(state, []byte("kept by the daemon\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := parseCollectorFlags(t, "-history-window=1h", "-history-state-file="+state)
+
+	rec := httptest.NewRecorder()
+	probeHandler(f, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target=pbs01", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("probe status = %d: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "pbs_history_") {
+		t.Error("probe exported history metrics")
+	}
+	data, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "kept by the daemon\n" {
+		t.Errorf("history state file = %q, want it untouched", data)
+	}
+}
+
+func TestParseProbeModule(t *testing.T) {
+	enabled := []string{"jobs", "nodes", "queues", "server", "history", "accounting", "reservations", "scheduler"}
+	tests := []struct {
+		module  string
+		enabled []string
+		want    []string
+		wantErr string
+	}{
+		// Log and history collectors are never probed
+		{module: "", enabled: enabled, want: []string{"jobs", "nodes", "queues", "server", "reservations"}},
+		{module: "default", enabled: enabled, want: []string{"jobs", "nodes", "queues", "server", "reservations"}},
+		{module: "default", enabled: []string{"jobs", "accounting"}, want: []string{"jobs"}},
+		{module: "nodes", enabled: enabled, want: []string{"nodes"}},
+		{module: " jobs , queues", enabled: enabled, want: []string{"jobs", "queues"}},
+		{module: "history", enabled: enabled, wantErr: `unknown or disabled module "history"`},
+		{module: "jobs,accounting", enabled: enabled, wantErr: `unknown or disabled module "accounting"`},
+		{module: "reservations", enabled: []string{"jobs", "nodes"}, wantErr: "expected default or a list of jobs, nodes"},
+		{module: "jobs,", enabled: enabled, wantErr: `unknown or disabled module ""`},
+	}
+	for _, tt := range tests {
+		got, err := parseProbeModule(tt.module, tt.enabled)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseProbeModule(%q) error = %v, want it to contain %q", tt.module, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseProbeModule(%q) = %v, %v; want %v", tt.module, got, err, tt.want)
+		}
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"10", 9500 * time.Millisecond},
+		{"0.75", 250 * time.Millisecond},
+		{"0.5", 0},
+		{"-3", 0},
+		{"ten", 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/probe", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+		}
+		if got := scrapeTimeout(r); got != tt.want {
+			t.Errorf("scrapeTimeout(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestProbeRequests(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	h := probeHandler(parseCollectorFlags(t), false)
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"?target=ssh://login01/pbs01", http.StatusForbidden},
+		{"?target=pbs01&module=history", http.StatusBadRequest},
+		{"?target=pbs01&module=jobs", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET /probe%s status = %d, want %d: %s", tt.query, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"pbs-exporter/internal/accounting"
+	"pbs-exporter/internal/privacy"
+	"pbs-exporter/internal/report"
+	"pbs-exporter/internal/store"
+)
+
+// dateFlagLayout is the format of the report -from and -to flags
+const dateFlagLayout = "2006-01-02"
+
+// runReport implements `pbs-exporter report`: CPU-hour/GPU-hour usage over a date range
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pbs-exporter report [flags]\n\nCPU-hour and GPU-hour usage per user, project and queue over a date range.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	lastMonth := time.Now().AddDate(0, -1, 0)
+	firstOfLastMonth := time.Date(lastMonth.Year(), lastMonth.Month(), 1, 0, 0, 0, 0, time.Local)
+	fromFlag := fs.String("from", firstOfLastMonth.Format(dateFlagLayout), "First day of the report (YYYY-MM-DD)")
+	toFlag := fs.String("to", firstOfLastMonth.AddDate(0, 1, -1).Format(dateFlagLayout), "Last day of the report, inclusive (YYYY-MM-DD)")
+	accountingDir := fs.String("accounting-dir", "", "Read usage from PBS accounting logs in this directory")
+	storeDB := fs.String("store-db", "", "Read usage from the exporter's history store instead")
+	cluster := fs.String("cluster", "", "Cluster to report on from -store-db when the exporter monitors several (see -cluster)")
+	groupBy := fs.String("by", "user,project,queue", "Comma-separated groupings: user, project, queue")
+	format := fs.String("format", report.FormatTable, "Output format: table, csv, json or markdown")
+	top := fs.Int("top", 0, "Only list the N largest entries of each grouping (0 lists all)")
+	userPrivacy := fs.String("user-privacy", string(privacy.ModeKeep), "How usernames from accounting logs are shown: keep, hmac, alias or drop")
+	hmacKeyFile := fs.String("user-hmac-key-file", "", "File holding the HMAC key for -user-privacy=hmac")
+	aliasFile := fs.String("user-alias-file", "", "File mapping usernames to aliases for -user-privacy=alias")
+	fs.Parse(args)
+
+	from, err := time.ParseInLocation(dateFlagLayout, *fromFlag, time.Local)
+	if err != nil {
+		return subcommandError("report", "invalid -from: %v", err)
+	}
+	to, err := time.ParseInLocation(dateFlagLayout, *toFlag, time.Local)
+	if err != nil {
+		return subcommandError("report", "invalid -to: %v", err)
+	}
+	// -to is inclusive; the range ends at the following midnight
+	to = to.AddDate(0, 0, 1)
+	if !from.Before(to) {
+		return subcommandError("report", "-from must not be after -to")
+	}
+
+	by, err := report.ParseGroupings(*groupBy)
+	if err != nil {
+		return subcommandError("report", "invalid -by: %v", err)
+	}
+
+	switch *format {
+	case report.FormatTable, report.FormatCSV, report.FormatJSON, report.FormatMarkdown:
+	default:
+		return subcommandError("report", "unknown -format %q (expected table, csv, json or markdown)", *format)
+	}
+
+	var rep *report.Report
+	switch {
+	case *accountingDir != "" && *storeDB != "":
+		return subcommandError("report", "use either -accounting-dir or -store-db, not both")
+	case *accountingDir != "":
+		mapper, err := privacy.NewUserMapper(*userPrivacy, *hmacKeyFile, *aliasFile)
+		if err != nil {
+			return subcommandError("report", "invalid user privacy settings: %v", err)
+		}
+		records, parseErrors, err := accounting.ReadRange(*accountingDir, from, to)
+		if err != nil {
+			return subcommandError("report", "reading accounting logs: %v", err)
+		}
+		if parseErrors > 0 {
+			fmt.Fprintf(os.Stderr, "warning: skipped %d malformed accounting lines\n", parseErrors)
+		}
+		if mapper.Mode() != privacy.ModeKeep {
+			for i := range records {
+				records[i].User = mapper.Map(records[i].User)
+			}
+		}
+		rep = report.FromAccounting(records, from, to, by)
+	case *storeDB != "":
+		if _, err := os.Stat(*storeDB); err != nil {
+			return subcommandError("report", "opening history store: %v", err)
+		}
+		st, err := store.Open(*storeDB, 0)
+		if err != nil {
+			return subcommandError("report", "opening history store: %v", err)
+		}
+		defer st.Close()
+		rep, err = report.FromStore(st, *cluster, from, to, by)
+		if err != nil {
+			return subcommandError("report", "reading history store: %v", err)
+		}
+	default:
+		return subcommandError("report", "one of -accounting-dir or -store-db is required")
+	}
+
+	rep.Top(*top)
+	if err := report.Write(os.Stdout, rep, *format); err != nil {
+		return subcommandError("report", "%v", err)
+	}
+	return 0
+}
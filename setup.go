@@ -0,0 +1,287 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"time"
+
+	"pbs-exporter/internal/accounting"
+	"pbs-exporter/internal/department"
+	"pbs-exporter/internal/metrics"
+	"pbs-exporter/internal/pbs"
+	"pbs-exporter/internal/privacy"
+	"pbs-exporter/internal/schedlog"
+	"pbs-exporter/internal/server"
+)
+
+// collectorFlags are the flags that shape what is collected and how it is
+// labelled; they are shared by the daemon and the one-shot subcommands
+type collectorFlags struct {
+	arrayMode        *string
+	byProject        *bool
+	byAccount        *bool
+	byGroup          *bool
+	userPrivacy      *string
+	hmacKeyFile      *string
+	aliasFile        *string
+	deptSource       *string
+	deptFile         *string
+	deptGroupPattern *string
+	deptLDAPURI      *string
+	deptLDAPBase     *string
+	deptLDAPFilter   *string
+	deptLDAPUserAttr *string
+	deptLDAPAttr     *string
+	deptRefresh      *time.Duration
+	accountingDir    *string
+	schedLogDir      *string
+	exitsByUser      *bool
+	historyWindow    *time.Duration
+	historyState     *string
+	pbsServer        *string
+	commandTimeout   *time.Duration
+	interval         *time.Duration
+	enabled          map[string]*bool
+	intervals        map[string]*time.Duration
+
+	// Mappers shared by every cluster, created on first use
+	userMapper *privacy.UserMapper
+	deptMapper *department.Mapper
+}
+
+// collectors lists the collectors that -collector.<name> flags control, in
+// the order they are shown, with what each collects
+var collectors = []struct {
+	name    string
+	help    string
+	enabled bool
+}{
+	{metrics.GroupJobs, "jobs from qstat -f", true},
+	{metrics.GroupNodes, "nodes from pbsnodes -aSj", true},
+	{metrics.GroupQueues, "queue totals from qstat -q", true},
+	{metrics.GroupServer, "server status from qstat -B -f", true},
+	{metrics.GroupHistory, "finished jobs from qselect -x and qstat -x -f (also needs -history-window)", true},
+	{metrics.GroupAccounting, "accounting log counters (also needs -accounting-dir)", true},
+	{metrics.GroupReservations, "reservations from pbs_rstat -f", false},
+	{metrics.GroupScheduler, "scheduling cycles from the scheduler log (also needs -sched-log-dir)", true},
+}
+
+// registerCollectorFlags defines the collector flags on fs
+func registerCollectorFlags(fs *flag.FlagSet) *collectorFlags {
+	f := &collectorFlags{
+		arrayMode:        fs.String("array-mode", string(pbs.ArrayModeSubjobs), "How array jobs are counted: \"subjobs\" (each subjob is a job) or \"arrays\" (each array is one job)"),
+		byProject:        fs.Bool("aggregate-project", false, "Export job metrics aggregated by PBS project"),
+		byAccount:        fs.Bool("aggregate-account", false, "Export job metrics aggregated by Account_Name"),
+		byGroup:          fs.Bool("aggregate-egroup", false, "Export job metrics aggregated by egroup"),
+		userPrivacy:      fs.String("user-privacy", string(privacy.ModeKeep), "How usernames are published: keep, hmac, alias or drop"),
+		hmacKeyFile:      fs.String("user-hmac-key-file", "", "File holding the secret key for -user-privacy=hmac"),
+		aliasFile:        fs.String("user-alias-file", "", "File of \"user alias\" lines for -user-privacy=alias"),
+		deptSource:       fs.String("department-source", "", "Where user departments come from: file, getent or ldap (empty disables)"),
+		deptFile:         fs.String("department-file", "", "CSV (user,department) or YAML mapping file for -department-source=file"),
+		deptGroupPattern: fs.String("department-group-pattern", "", "Regexp selecting department groups for -department-source=getent"),
+		deptLDAPURI:      fs.String("department-ldap-uri", "ldap://localhost", "LDAP URI for -department-source=ldap"),
+		deptLDAPBase:     fs.String("department-ldap-base", "", "LDAP search base"),
+		deptLDAPFilter:   fs.String("department-ldap-filter", "(objectClass=posixAccount)", "LDAP search filter"),
+		deptLDAPUserAttr: fs.String("department-ldap-user-attr", "uid", "LDAP attribute holding the username"),
+		deptLDAPAttr:     fs.String("department-ldap-attr", "departmentNumber", "LDAP attribute holding the department"),
+		deptRefresh:      fs.Duration("department-refresh", 10*time.Minute, "How often the department mapping is reloaded"),
+		accountingDir:    fs.String("accounting-dir", "", "PBS accounting log directory to tail, e.g. /var/spool/pbs/server_priv/accounting (empty disables)"),
+		schedLogDir:      fs.String("sched-log-dir", "", "PBS scheduler log directory to tail, e.g. /var/spool/pbs/sched_logs (empty disables)"),
+		exitsByUser:      fs.Bool("job-exits-by-user", false, "Also export job exit classes per user"),
+		historyWindow:    fs.Duration("history-window", 0, "Collect finished jobs from qstat -x -f that ended within this window, e.g. 1h (0 disables)"),
+		historyState:     fs.String("history-state-file", "", "File keeping the end time of the last job counted by the history collector across restarts (empty disables)"),
+		pbsServer:        fs.String("pbs-server", "", "PBS server to query: a server name (passed in PBS_SERVER) or ssh://[user@]host[/server] (empty uses the default server)"),
+		commandTimeout:   fs.Duration("command-timeout", 30*time.Second, "Maximum time a PBS command may take before the collector run fails, also bounding getent and ldapsearch (0 disables)"),
+		interval:         fs.Duration("collection-interval", server.DefaultInterval, "How often collectors without their own -collector.<name>.interval run"),
+		enabled:          make(map[string]*bool),
+		intervals:        make(map[string]*time.Duration),
+	}
+	for _, c := range collectors {
+		f.enabled[c.name] = fs.Bool("collector."+c.name, c.enabled, "Enable the "+c.name+" collector: "+c.help)
+		f.intervals[c.name] = fs.Duration("collector."+c.name+".interval", 0, "How often the "+c.name+" collector runs (0 uses -collection-interval)")
+	}
+	return f
+}
+
+// collectorNames returns the collectors that are enabled and configured
+func (f *collectorFlags) collectorNames() []string {
+	var names []string
+	for _, c := range collectors {
+		switch {
+		case !*f.enabled[c.name]:
+		case c.name == metrics.GroupHistory && *f.historyWindow <= 0:
+		case c.name == metrics.GroupAccounting && *f.accountingDir == "":
+		case c.name == metrics.GroupScheduler && *f.schedLogDir == "":
+		default:
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// enabledCollector reports whether the named collector is enabled and configured
+func (f *collectorFlags) enabledCollector(name string) bool {
+	for _, n := range f.collectorNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// build creates the metrics registry, PBS client and server configured by the flags
+func (f *collectorFlags) build() (*metrics.Registry, *pbs.Client, *server.Server, error) {
+	target, err := pbs.ParseTarget(*f.pbsServer)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid -pbs-server: %w", err)
+	}
+	registry := metrics.NewRegistryFor(f.collectorNames())
+	pbsClient, srv, err := f.buildFor(registry, target)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := f.enableHistory(registry, srv); err != nil {
+		return nil, nil, nil, err
+	}
+	f.enableLogs(registry, srv)
+	return registry, pbsClient, srv, nil
+}
+
+// enableHistory counts finished jobs into srv when the history collector is
+// enabled. Probes leave it out: their registry lives for one request, and they
+// must not load or rewrite the daemon's -history-state-file.
+func (f *collectorFlags) enableHistory(registry *metrics.Registry, srv *server.Server) error {
+	if !f.enabledCollector(metrics.GroupHistory) {
+		return nil
+	}
+	registry.EnableHistoryMetrics()
+	srv.SetHistoryWindow(*f.historyWindow)
+	if *f.historyState != "" {
+		if err := srv.SetHistoryStateFile(*f.historyState); err != nil {
+			return fmt.Errorf("loading -history-state-file: %w", err)
+		}
+	}
+	return nil
+}
+
+// enableLogs tails -accounting-dir and -sched-log-dir into srv when they are
+// set. The logs are local files, so this only applies to the server running on this host.
+func (f *collectorFlags) enableLogs(registry *metrics.Registry, srv *server.Server) {
+	if f.enabledCollector(metrics.GroupAccounting) {
+		registry.EnableAccountingMetrics()
+		srv.SetAccountingReader(accounting.NewReader(*f.accountingDir))
+	}
+	if f.enabledCollector(metrics.GroupScheduler) {
+		registry.EnableSchedulerMetrics()
+		srv.SetSchedulerLogReader(schedlog.NewReader(*f.schedLogDir))
+	}
+}
+
+// mappers creates the user privacy and department mappers once for all clusters
+func (f *collectorFlags) mappers() (*privacy.UserMapper, *department.Mapper, error) {
+	if f.userMapper != nil {
+		return f.userMapper, f.deptMapper, nil
+	}
+
+	userMapper, err := privacy.NewUserMapper(*f.userPrivacy, *f.hmacKeyFile, *f.aliasFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid user privacy settings: %w", err)
+	}
+
+	// Initialize department mapping
+	var deptMapper *department.Mapper
+	if *f.deptSource != "" {
+		var source department.Source
+		switch *f.deptSource {
+		case "file":
+			source = department.FileSource{Path: *f.deptFile}
+		case "getent":
+			var pattern *regexp.Regexp
+			if *f.deptGroupPattern != "" {
+				if pattern, err = regexp.Compile(*f.deptGroupPattern); err != nil {
+					return nil, nil, fmt.Errorf("invalid -department-group-pattern: %w", err)
+				}
+			}
+			source = department.GroupSource{Pattern: pattern, Timeout: *f.commandTimeout}
+		case "ldap":
+			source = department.LDAPSource{
+				URI:      *f.deptLDAPURI,
+				BaseDN:   *f.deptLDAPBase,
+				Filter:   *f.deptLDAPFilter,
+				UserAttr: *f.deptLDAPUserAttr,
+				DeptAttr: *f.deptLDAPAttr,
+				Timeout:  *f.commandTimeout,
+			}
+		default:
+			return nil, nil, fmt.Errorf("invalid -department-source %q (expected file, getent or ldap)", *f.deptSource)
+		}
+		if deptMapper, err = department.NewMapper(source, *f.deptRefresh); err != nil {
+			return nil, nil, fmt.Errorf("loading department mapping: %w", err)
+		}
+	}
+
+	f.userMapper, f.deptMapper = userMapper, deptMapper
+	return userMapper, deptMapper, nil
+}
+
+// buildFor creates the PBS client and server collecting from target into registry
+func (f *collectorFlags) buildFor(registry *metrics.Registry, target pbs.Target) (*pbs.Client, *server.Server, error) {
+	userMapper, deptMapper, err := f.mappers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *f.byProject {
+		registry.EnableProjectMetrics()
+	}
+	if *f.byAccount {
+		registry.EnableAccountMetrics()
+	}
+	if *f.byGroup {
+		registry.EnableGroupMetrics()
+	}
+	if userMapper.Mode() == privacy.ModeDrop {
+		registry.DropUserMetrics()
+	}
+	if deptMapper != nil {
+		registry.EnableDepartmentMetrics()
+	}
+
+	// Initialize PBS client
+	pbsClient := pbs.NewClient()
+	mode, ok := pbs.ParseArrayMode(*f.arrayMode)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid -array-mode %q (expected subjobs or arrays)", *f.arrayMode)
+	}
+	pbsClient.ArrayMode = mode
+	pbsClient.Target = target
+	pbsClient.Timeout = *f.commandTimeout
+	if userMapper.Mode() != privacy.ModeKeep {
+		pbsClient.MapUser = userMapper.Map
+	}
+	if deptMapper != nil {
+		pbsClient.Department = deptMapper.Lookup
+	}
+
+	// Create and configure server
+	srv := server.New(registry, pbsClient)
+
+	// Schedule the collectors
+	if *f.interval <= 0 {
+		return nil, nil, fmt.Errorf("invalid -collection-interval %s (must be positive)", *f.interval)
+	}
+	srv.SetCollectors(f.collectorNames())
+	srv.SetInterval(*f.interval)
+	for name, interval := range f.intervals {
+		if *interval < 0 {
+			return nil, nil, fmt.Errorf("invalid -collector.%s.interval %s", name, *interval)
+		}
+		srv.SetCollectorInterval(name, *interval)
+	}
+	if *f.exitsByUser && userMapper.Mode() != privacy.ModeDrop {
+		registry.EnableJobExitsByUser()
+	}
+
+	return pbsClient, srv, nil
+}
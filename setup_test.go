@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseCollectorFlags registers the collector flags and parses args
+func parseCollectorFlags(t *testing.T, args ...string) *collectorFlags {
+	t.Helper()
+	fs := flag.NewFlagSet("pbs-exporter", flag.ContinueOnError)
+	f := registerCollectorFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCollectorNames(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"jobs", "nodes", "queues", "server"},
+		},
+		{
+			name: "collectors needing a setting",
+			args: []string{"-history-window=1h", "-accounting-dir=/acct", "-sched-log-dir=/sched"},
+			want: []string{"jobs", "nodes", "queues", "server", "history", "accounting", "scheduler"},
+		},
+		{
+			name: "disabled despite their setting",
+			args: []string{"-history-window=1h", "-collector.history=false", "-accounting-dir=/acct", "-collector.accounting=false"},
+			want: []string{"jobs", "nodes", "queues", "server"},
+		},
+		{
+			name: "reservations are opt-in",
+			args: []string{"-collector.reservations", "-collector.nodes=false"},
+			want: []string{"jobs", "queues", "server", "reservations"},
+		},
+		{
+			name: "a zero history window disables history",
+			args: []string{"-history-window=0", "-collector.history"},
+			want: []string{"jobs", "nodes", "queues", "server"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parseCollectorFlags(t, tt.args...)
+			if got := f.collectorNames(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("collectorNames() = %v, want %v", got, tt.want)
+			}
+			for _, c := range collectors {
+				want := false
+				for _, name := range tt.want {
+					want = want || name == c.name
+				}
+				if got := f.enabledCollector(c.name); got != want {
+					t.Errorf("enabledCollector(%s) = %v, want %v", c.name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildIntervals(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "per-collector intervals", args: []string{"-collection-interval=1m", "-collector.nodes.interval=5m", "-collector.jobs.interval=0"}},
+		{name: "zero collection interval", args: []string{"-collection-interval=0"}, wantErr: "invalid -collection-interval 0s"},
+		{name: "negative collection interval", args: []string{"-collection-interval=-1m"}, wantErr: "must be positive"},
+		{name: "negative collector interval", args: []string{"-collector.queues.interval=-5s"}, wantErr: "invalid -collector.queues.interval -5s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := parseCollectorFlags(t, tt.args...).build()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("build() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}